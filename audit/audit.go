@@ -0,0 +1,87 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"hvmnd/api/store"
+	"net/http"
+	"reflect"
+)
+
+// Wrap audits the changes next makes. The stores log every change made with
+// the route in the request context, in the same transaction as the change,
+// so an entry is never lost or written for a change that was rolled back.
+func Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(store.WithRoute(r.Context(), r.Pattern)))
+	}
+}
+
+// Diff returns the JSON fields of before and after that differ. A nil side
+// is returned as is, with the other side in full.
+func Diff(before, after interface{}) (json.RawMessage, json.RawMessage, error) {
+	oldFields, err := fields(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	newFields, err := fields(after)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if oldFields != nil && newFields != nil {
+		for name, value := range oldFields {
+			if other, ok := newFields[name]; ok && reflect.DeepEqual(value, other) {
+				delete(oldFields, name)
+				delete(newFields, name)
+			}
+		}
+		if len(oldFields) == 0 && len(newFields) == 0 {
+			return nil, nil, nil
+		}
+	}
+
+	oldJSON, err := marshalFields(oldFields)
+	if err != nil {
+		return nil, nil, err
+	}
+	newJSON, err := marshalFields(newFields)
+	return oldJSON, newJSON, err
+}
+
+// isNil reports whether v is nil or a nil pointer.
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	value := reflect.ValueOf(v)
+	return value.Kind() == reflect.Pointer && value.IsNil()
+}
+
+// fields decodes the JSON form of v into its top-level fields.
+func fields(v interface{}) (map[string]interface{}, error) {
+	if isNil(v) {
+		return nil, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	// Numbers are kept as written so money keeps its two decimals
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var decoded map[string]interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+func marshalFields(m map[string]interface{}) (json.RawMessage, error) {
+	if m == nil {
+		return nil, nil
+	}
+	return json.Marshal(m)
+}
@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hvmnd/api/requestlog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Role is the scope granted to an API key.
+type Role string
+
+const (
+	RoleBot             Role = "bot"
+	RoleAdmin           Role = "admin"
+	RoleReadonly        Role = "readonly"
+	RolePaymentProvider Role = "payment-provider"
+	RoleNodeAgent       Role = "node-agent"
+)
+
+// Valid reports whether r is a known role.
+func (r Role) Valid() bool {
+	switch r {
+	case RoleBot, RoleAdmin, RoleReadonly, RolePaymentProvider, RoleNodeAgent:
+		return true
+	}
+	return false
+}
+
+// Principal is the authenticated caller of a request. TelegramID is set for
+// end users authenticated with Telegram initData, who have no API key.
+// MachineID is set for node agents, whose keys are bound to one machine.
+type Principal struct {
+	KeyID      int
+	Name       string
+	Role       Role
+	TelegramID int
+	MachineID  string
+}
+
+type contextKey struct{}
+
+// FromContext returns the principal attached by Require, if any.
+func FromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(contextKey{}).(Principal)
+	return principal, ok
+}
+
+// TelegramIDFrom returns the Telegram id of an end user caller. ok is false
+// for callers authenticated with an API key.
+func TelegramIDFrom(r *http.Request) (telegramID int, ok bool) {
+	principal, found := FromContext(r.Context())
+	if !found || principal.Role != RoleTelegramUser {
+		return 0, false
+	}
+	return principal.TelegramID, true
+}
+
+// IsAdmin reports whether the request was made with an admin key.
+func IsAdmin(r *http.Request) bool {
+	principal, ok := FromContext(r.Context())
+	return ok && principal.Role == RoleAdmin
+}
+
+// Authenticator resolves API keys to principals and enforces route roles.
+type Authenticator struct {
+	keys         KeyStore
+	bootstrapKey string
+
+	botToken       string
+	initDataMaxAge time.Duration
+}
+
+// New returns an authenticator backed by keys. bootstrap, when non-empty, is
+// accepted as an admin key so that the first real keys can be issued.
+func New(keys KeyStore, bootstrap string) *Authenticator {
+	return &Authenticator{keys: keys, bootstrapKey: bootstrap}
+}
+
+// EnableTelegram accepts the initData of the bot's WebApps, sent as
+// "Authorization: tma <initData>", as long as it is at most maxAge old.
+func (a *Authenticator) EnableTelegram(botToken string, maxAge time.Duration) {
+	if maxAge <= 0 {
+		maxAge = DefaultInitDataMaxAge
+	}
+	a.botToken = botToken
+	a.initDataMaxAge = maxAge
+}
+
+// keyFromRequest extracts the API key from the Authorization bearer token or
+// the X-API-Key header.
+func keyFromRequest(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+func (a *Authenticator) authenticate(r *http.Request) (Principal, error) {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "tma ") {
+		if a.botToken == "" {
+			return Principal{}, ErrKeyNotFound
+		}
+
+		user, err := ValidateInitData(strings.TrimPrefix(header, "tma "), a.botToken, a.initDataMaxAge, time.Now())
+		if err != nil {
+			return Principal{}, err
+		}
+		return Principal{
+			Name:       fmt.Sprintf("telegram:%d", user.ID),
+			Role:       RoleTelegramUser,
+			TelegramID: user.ID,
+		}, nil
+	}
+
+	key := keyFromRequest(r)
+	if key == "" {
+		return Principal{}, ErrKeyNotFound
+	}
+
+	if a.bootstrapKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(a.bootstrapKey)) == 1 {
+		return Principal{Name: "bootstrap", Role: RoleAdmin}, nil
+	}
+
+	return a.keys.FindKey(r.Context(), HashKey(key))
+}
+
+// Require authenticates the request and lets it through only if the caller
+// holds one of roles. Admin keys are always allowed. With no roles the route
+// is public.
+func (a *Authenticator) Require(roles ...Role) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if len(roles) == 0 {
+			return next
+		}
+
+		return func(w http.ResponseWriter, r *http.Request) {
+			principal, err := a.authenticate(r)
+			if err != nil {
+				if errors.Is(err, ErrInitDataInvalid) || errors.Is(err, ErrInitDataExpired) {
+					writeError(w, http.StatusUnauthorized, "Telegram initData is invalid or expired")
+					return
+				}
+				if err != ErrKeyNotFound {
+					requestlog.Logger(r.Context()).Error("auth: failed to look up api key", "error", err)
+					writeError(w, http.StatusInternalServerError, "Failed to authenticate request")
+					return
+				}
+				writeError(w, http.StatusUnauthorized, "A valid API key is required")
+				return
+			}
+
+			if !allowed(principal.Role, roles) {
+				writeError(w, http.StatusForbidden, "Caller is not allowed to access this route")
+				return
+			}
+
+			next(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, principal)))
+		}
+	}
+}
+
+func allowed(role Role, roles []Role) bool {
+	if role == RoleAdmin {
+		return true
+	}
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	response := map[string]interface{}{
+		"success": false,
+		"error":   message,
+	}
+	if id := w.Header().Get(requestlog.Header); id != "" {
+		response["request_id"] = id
+	}
+	json.NewEncoder(w).Encode(response)
+}
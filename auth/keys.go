@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hvmnd/api/utils"
+	"time"
+)
+
+const keyPrefix = "hvmnd_"
+
+var ErrKeyNotFound = errors.New("api key not found")
+
+// APIKey is a stored API key. The plaintext key is never persisted.
+// MachineID is the machine a node-agent key sends heartbeats for.
+type APIKey struct {
+	ID        int            `json:"id"`
+	Name      string         `json:"name"`
+	Role      Role           `json:"role"`
+	MachineID sql.NullString `json:"-"`
+	CreatedAt time.Time      `json:"created_at"`
+	RevokedAt sql.NullTime   `json:"-"`
+}
+
+func (k APIKey) MarshalJSON() ([]byte, error) {
+	type Alias APIKey
+	return json.Marshal(&struct {
+		MachineID interface{} `json:"machine_id"`
+		RevokedAt interface{} `json:"revoked_at"`
+		Alias
+	}{
+		MachineID: utils.NullStringOrValue(k.MachineID),
+		RevokedAt: utils.NullTimeOrValue(k.RevokedAt),
+		Alias:     (Alias)(k),
+	})
+}
+
+// KeyStore persists API keys by their hash.
+type KeyStore interface {
+	// CreateKey stores a key. machineID is empty for all but node-agent keys.
+	CreateKey(ctx context.Context, name string, keyHash string, role Role, machineID string) (APIKey, error)
+	// RevokeKey marks a key as revoked. Revoking an already revoked key is a
+	// no-op; an unknown id returns ErrKeyNotFound.
+	RevokeKey(ctx context.Context, id int) error
+	// ListKeys returns all keys, including revoked ones, ordered by id.
+	ListKeys(ctx context.Context) ([]APIKey, error)
+	// FindKey resolves a key hash to its principal. Revoked and unknown keys
+	// return ErrKeyNotFound.
+	FindKey(ctx context.Context, keyHash string) (Principal, error)
+}
+
+// HashKey returns the hex encoded SHA-256 of a plaintext key. Keys are long
+// random strings, so a fast hash is sufficient.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueKey creates a new API key with the given role and returns the
+// plaintext key alongside the stored record. Node-agent keys are bound to
+// machineID; it must be empty for every other role.
+func IssueKey(ctx context.Context, keys KeyStore, name string, role Role, machineID string) (string, APIKey, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", APIKey{}, err
+	}
+	plaintext := keyPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	key, err := keys.CreateKey(ctx, name, HashKey(plaintext), role, machineID)
+	if err != nil {
+		return "", APIKey{}, err
+	}
+
+	return plaintext, key, nil
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RoleTelegramUser is held by end users who authenticate with the initData
+// of a Telegram WebApp. It is not an API key role and cannot be issued.
+const RoleTelegramUser Role = "telegram-user"
+
+// DefaultInitDataMaxAge is how old initData may be when
+// TELEGRAM_INIT_DATA_MAX_AGE is not set.
+const DefaultInitDataMaxAge = 24 * time.Hour
+
+// initDataClockSkew is how far in the future auth_date may be.
+const initDataClockSkew = time.Minute
+
+var (
+	ErrInitDataInvalid = errors.New("auth: invalid telegram initData")
+	ErrInitDataExpired = errors.New("auth: telegram initData has expired")
+)
+
+// TelegramUser is the user described by a WebApp's initData.
+type TelegramUser struct {
+	ID           int    `json:"id"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	Username     string `json:"username"`
+	LanguageCode string `json:"language_code"`
+}
+
+// ValidateInitData checks the signature of the initData query string a
+// Telegram WebApp received against the bot token, rejects it when auth_date
+// is older than maxAge, and returns the user it describes.
+//
+// See https://core.telegram.org/bots/webapps#validating-data-received-via-the-mini-app
+func ValidateInitData(initData, botToken string, maxAge time.Duration, now time.Time) (TelegramUser, error) {
+	values, err := url.ParseQuery(initData)
+	if err != nil {
+		return TelegramUser{}, ErrInitDataInvalid
+	}
+
+	hash := values.Get("hash")
+	if hash == "" || botToken == "" {
+		return TelegramUser{}, ErrInitDataInvalid
+	}
+
+	// The signed data is every other field as key=value, sorted by key
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		if key != "hash" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, len(keys))
+	for i, key := range keys {
+		lines[i] = key + "=" + values.Get(key)
+	}
+
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(botToken))
+
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(strings.Join(lines, "\n")))
+
+	expected, err := hex.DecodeString(hash)
+	if err != nil || !hmac.Equal(mac.Sum(nil), expected) {
+		return TelegramUser{}, ErrInitDataInvalid
+	}
+
+	authDate, err := strconv.ParseInt(values.Get("auth_date"), 10, 64)
+	if err != nil {
+		return TelegramUser{}, ErrInitDataInvalid
+	}
+	signedAt := time.Unix(authDate, 0)
+	if now.Sub(signedAt) > maxAge || signedAt.Sub(now) > initDataClockSkew {
+		return TelegramUser{}, ErrInitDataExpired
+	}
+
+	var user TelegramUser
+	if err := json.Unmarshal([]byte(values.Get("user")), &user); err != nil || user.ID == 0 {
+		return TelegramUser{}, fmt.Errorf("%w: missing user", ErrInitDataInvalid)
+	}
+	return user, nil
+}
@@ -0,0 +1,324 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hvmnd/api/ledger"
+	"hvmnd/api/models"
+	"hvmnd/api/money"
+	"math"
+	"time"
+)
+
+var (
+	ErrNodeNotFound        = errors.New("node not found")
+	ErrNodeUnavailable     = errors.New("node is not available")
+	ErrNodeNotRented       = errors.New("node is not rented")
+	ErrNotRenter           = errors.New("node is rented by another user")
+	ErrUserNotFound        = errors.New("user not found")
+	ErrUserBanned          = errors.New("user is banned")
+	ErrInsufficientBalance = errors.New("insufficient balance")
+)
+
+// EndReason records why a rental ended.
+type EndReason string
+
+const (
+	EndReasonReleased            EndReason = "released"
+	EndReasonInsufficientBalance EndReason = "insufficient_balance"
+	EndReasonBanned              EndReason = "banned"
+)
+
+// lockNode reads the node and holds a row lock on it until tx ends.
+func lockNode(ctx context.Context, tx *sql.Tx, nodeID int) (NodeState, error) {
+	query := `
+		SELECT id, status, price, renter, rent_start_time, last_balance_update_timestamp, billing_paused_at
+		FROM nodes
+		WHERE id = $1
+		FOR UPDATE
+	`
+	var node NodeState
+	err := tx.QueryRowContext(ctx, query, nodeID).Scan(
+		&node.ID,
+		&node.Status,
+		&node.Price,
+		&node.Renter,
+		&node.RentStartTime,
+		&node.LastBalanceUpdateTimestamp,
+		&node.BillingPausedAt,
+	)
+	if err == sql.ErrNoRows {
+		return node, ErrNodeNotFound
+	}
+	return node, err
+}
+
+// lockUser reads the user's balance and whether they are banned at now, and
+// holds a row lock on the user until tx ends.
+func lockUser(ctx context.Context, tx *sql.Tx, userID int, now time.Time) (balance money.Amount, banned bool, err error) {
+	var nullBanned sql.NullBool
+	var bannedUntil sql.NullTime
+	err = tx.QueryRowContext(ctx, "SELECT balance, banned, banned_until FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&balance, &nullBanned, &bannedUntil)
+	if err == sql.ErrNoRows {
+		return 0, false, ErrUserNotFound
+	}
+	return balance, models.BanActive(nullBanned, bannedUntil, now), err
+}
+
+// Rent assigns an available node to the user and opens a rental record. The
+// user must not be banned and must be able to pay for at least one hour of
+// use. It returns the rental id.
+func Rent(ctx context.Context, tx *sql.Tx, nodeID int, userID int, now time.Time) (int, error) {
+	node, err := lockNode(ctx, tx, nodeID)
+	if err != nil {
+		return 0, err
+	}
+	if err := node.CheckAvailable(); err != nil {
+		return 0, err
+	}
+
+	balance, banned, err := lockUser(ctx, tx, userID, now)
+	if err != nil {
+		return 0, err
+	}
+	if err := node.CheckRenter(balance, banned); err != nil {
+		return 0, err
+	}
+
+	query := `
+		UPDATE nodes SET
+		status = 'rented',
+		renter = $1,
+		rent_start_time = $2,
+		last_balance_update_timestamp = $2
+		WHERE id = $3 AND status = 'available'
+	`
+	result, err := tx.ExecContext(ctx, query, userID, now, nodeID)
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rowsAffected != 1 {
+		return 0, ErrNodeUnavailable
+	}
+
+	query = `
+		INSERT INTO rentals (node_id, user_id, started_at, price_per_hour)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`
+	var rentalID int
+	err = tx.QueryRowContext(ctx, query, nodeID, userID, now, node.Price).Scan(&rentalID)
+	return rentalID, err
+}
+
+// openRental returns the id of the node's open rental. Nodes rented before
+// rental records existed get one backfilled from rent_start_time.
+func openRental(ctx context.Context, tx *sql.Tx, node NodeState) (int, error) {
+	var rentalID int
+	err := tx.QueryRowContext(ctx, "SELECT id FROM rentals WHERE node_id = $1 AND ended_at IS NULL", node.ID).Scan(&rentalID)
+	if err != sql.ErrNoRows {
+		return rentalID, err
+	}
+
+	query := `
+		INSERT INTO rentals (node_id, user_id, started_at, price_per_hour)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`
+	err = tx.QueryRowContext(ctx, query, node.ID, node.Renter.Int16, node.RentalStart(), node.Price).Scan(&rentalID)
+	return rentalID, err
+}
+
+// Release charges the renter for the time used since the last balance update,
+// closes the rental record and makes the node available again. If userID is
+// non-zero it must match the current renter. It returns the final charge.
+func Release(ctx context.Context, tx *sql.Tx, nodeID int, userID int, now time.Time, reason EndReason) (money.Amount, error) {
+	node, err := lockNode(ctx, tx, nodeID)
+	if err != nil {
+		return 0, err
+	}
+	if err := node.CheckRelease(userID); err != nil {
+		return 0, err
+	}
+
+	charged, err := charge(ctx, tx, node, now, true)
+	if err != nil {
+		return 0, err
+	}
+
+	rentalID, err := openRental(ctx, tx, node)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE rentals SET ended_at = $1, end_reason = $2 WHERE id = $3", now, reason, rentalID)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `
+		UPDATE nodes SET
+		status = 'available',
+		renter = NULL,
+		rent_start_time = NULL,
+		last_balance_update_timestamp = NULL,
+		billing_paused_at = NULL
+		WHERE id = $1
+	`
+	if _, err := tx.ExecContext(ctx, query, nodeID); err != nil {
+		return 0, err
+	}
+
+	return charged, nil
+}
+
+// Charge computes what a renter owes for the time between since and until at
+// price per hour. Only whole minutes are billed unless final is set, in which
+// case a started minute counts in full. It returns the minutes billed and the
+// amount rounded to cents.
+func Charge(price money.Amount, since, until time.Time, final bool) (int, money.Amount) {
+	elapsed := until.Sub(since).Minutes()
+	minutes := math.Floor(elapsed)
+	if final {
+		minutes = math.Ceil(elapsed)
+	}
+	if minutes <= 0 {
+		return 0, 0
+	}
+
+	return int(minutes), price.MulDiv(int64(minutes), 60)
+}
+
+// charge debits the renter for what is due on the node since its
+// last_balance_update_timestamp, then advances the timestamp by the minutes
+// billed.
+func charge(ctx context.Context, tx *sql.Tx, node NodeState, until time.Time, final bool) (money.Amount, error) {
+	bill, err := node.Due(until, final)
+	if err != nil || bill.Minutes == 0 {
+		return 0, err
+	}
+
+	rentalID, err := openRental(ctx, tx, node)
+	if err != nil {
+		return 0, err
+	}
+
+	renter := int(node.Renter.Int16)
+	balance, _, err := lockUser(ctx, tx, renter, until)
+	if err != nil {
+		return 0, err
+	}
+
+	amount := bill.Collect(balance)
+	if amount > 0 {
+		reference := fmt.Sprintf("rental:%d", rentalID)
+		if _, err := ledger.Post(ctx, tx, renter, -amount, ledger.ReasonRentalCharge, reference); err != nil {
+			return 0, err
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE rentals SET total_charged = total_charged + $1 WHERE id = $2", amount, rentalID)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE users SET total_spent = total_spent + $1 WHERE id = $2", amount, renter)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE nodes SET last_balance_update_timestamp = $1 WHERE id = $2", bill.BilledUntil, node.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	return amount, nil
+}
+
+// BillNode charges the renter of one node up to now and releases the node once
+// the renter's balance is exhausted. It reports whether the node was released.
+func BillNode(ctx context.Context, tx *sql.Tx, nodeID int, now time.Time) (bool, error) {
+	node, err := lockNode(ctx, tx, nodeID)
+	if err != nil {
+		return false, err
+	}
+	// The node may have been released or paused since it was listed
+	if !node.Billable() {
+		return false, nil
+	}
+
+	if _, err := charge(ctx, tx, node, now, false); err != nil {
+		return false, err
+	}
+
+	balance, _, err := lockUser(ctx, tx, int(node.Renter.Int16), now)
+	if err != nil {
+		return false, err
+	}
+	if !Exhausted(balance) {
+		return false, nil
+	}
+
+	if _, err := Release(ctx, tx, nodeID, 0, now, EndReasonInsufficientBalance); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PausedFrom returns when billing of a node that went quiet at lastSeen should
+// stop. Time that has already been billed is not refunded, so the pause never
+// starts before the end of the billed window.
+func PausedFrom(lastSeen time.Time, billedUntil sql.NullTime) time.Time {
+	if billedUntil.Valid && billedUntil.Time.After(lastSeen) {
+		return billedUntil.Time
+	}
+	return lastSeen
+}
+
+// Pause stops billing a rented node whose agent was last seen at lastSeen.
+// Nodes that are not rented or already paused are left alone.
+func Pause(ctx context.Context, tx *sql.Tx, nodeID int, lastSeen time.Time) error {
+	node, err := lockNode(ctx, tx, nodeID)
+	if err != nil {
+		return err
+	}
+	if !node.Billable() {
+		return nil
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"UPDATE nodes SET billing_paused_at = $1 WHERE id = $2",
+		PausedFrom(lastSeen, node.LastBalanceUpdateTimestamp),
+		nodeID,
+	)
+	return err
+}
+
+// Resume restarts billing of a paused node, moving its billing window past
+// the pause.
+func Resume(ctx context.Context, tx *sql.Tx, nodeID int, now time.Time) error {
+	node, err := lockNode(ctx, tx, nodeID)
+	if err != nil {
+		return err
+	}
+	if !node.BillingPausedAt.Valid {
+		return nil
+	}
+
+	query := `
+		UPDATE nodes SET
+		billing_paused_at = NULL,
+		last_balance_update_timestamp = $1
+		WHERE id = $2
+	`
+	_, err = tx.ExecContext(ctx, query, node.Resumed(now), nodeID)
+	return err
+}
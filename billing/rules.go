@@ -0,0 +1,136 @@
+package billing
+
+import (
+	"database/sql"
+	"fmt"
+	"hvmnd/api/models"
+	"hvmnd/api/money"
+	"time"
+)
+
+// The rules below decide what renting, billing and releasing a node do. They
+// work on plain values so that every store applies the same rules; the
+// functions in billing.go apply them inside a Postgres transaction.
+
+// NodeState is the billing-relevant state of a node.
+type NodeState struct {
+	ID                         int
+	Status                     models.NodeStatus
+	Price                      money.Amount
+	Renter                     sql.NullInt16
+	RentStartTime              sql.NullTime
+	LastBalanceUpdateTimestamp sql.NullTime
+	BillingPausedAt            sql.NullTime
+}
+
+// StateOf returns the billing-relevant state of node.
+func StateOf(node models.Node) NodeState {
+	return NodeState{
+		ID:                         node.ID,
+		Status:                     node.Status,
+		Price:                      node.Price,
+		Renter:                     node.Renter,
+		RentStartTime:              node.RentStartTime,
+		LastBalanceUpdateTimestamp: node.LastBalanceUpdateTimestamp,
+		BillingPausedAt:            node.BillingPausedAt,
+	}
+}
+
+// CheckAvailable returns ErrNodeUnavailable unless the node can be rented.
+func (n NodeState) CheckAvailable() error {
+	if n.Status != models.NodeStatusAvailable {
+		return ErrNodeUnavailable
+	}
+	return nil
+}
+
+// CheckRenter returns why a user with balance, who is banned or not, may not
+// rent the node. A renter has to be able to pay for at least one hour.
+func (n NodeState) CheckRenter(balance money.Amount, banned bool) error {
+	if banned {
+		return ErrUserBanned
+	}
+	if balance < n.Price {
+		return ErrInsufficientBalance
+	}
+	return nil
+}
+
+// CheckRelease returns why userID may not release the node. A userID of 0
+// releases the node whoever rents it.
+func (n NodeState) CheckRelease(userID int) error {
+	if n.Status != models.NodeStatusRented || !n.Renter.Valid {
+		return ErrNodeNotRented
+	}
+	if userID != 0 && int(n.Renter.Int16) != userID {
+		return ErrNotRenter
+	}
+	return nil
+}
+
+// Billable reports whether the node is rented and its billing is not paused.
+func (n NodeState) Billable() bool {
+	return n.Status == models.NodeStatusRented && n.Renter.Valid && !n.BillingPausedAt.Valid
+}
+
+// RentalStart is when the open rental of the node began, for nodes rented
+// before rental records existed.
+func (n NodeState) RentalStart() sql.NullTime {
+	if n.RentStartTime.Valid {
+		return n.RentStartTime
+	}
+	return n.LastBalanceUpdateTimestamp
+}
+
+// Bill is what a renter owes for part of a rental.
+type Bill struct {
+	Minutes     int
+	Amount      money.Amount
+	BilledUntil time.Time // The new last_balance_update_timestamp
+}
+
+// Due computes the bill for the time since the node's last balance update up
+// to until, leaving out the time after a billing pause began. A bill of zero
+// minutes charges nothing and leaves the timestamp alone.
+func (n NodeState) Due(until time.Time, final bool) (Bill, error) {
+	if !n.LastBalanceUpdateTimestamp.Valid {
+		return Bill{}, fmt.Errorf("node %d has no last_balance_update_timestamp", n.ID)
+	}
+	since := n.LastBalanceUpdateTimestamp.Time
+	if n.BillingPausedAt.Valid && until.After(n.BillingPausedAt.Time) {
+		until = n.BillingPausedAt.Time
+	}
+
+	minutes, amount := Charge(n.Price, since, until, final)
+	return Bill{
+		Minutes:     minutes,
+		Amount:      amount,
+		BilledUntil: since.Add(time.Duration(minutes) * time.Minute),
+	}, nil
+}
+
+// Collect returns how much of the bill is taken from a renter with balance.
+// A charge never exceeds the balance.
+func (b Bill) Collect(balance money.Amount) money.Amount {
+	if b.Amount > balance {
+		return max(balance, 0)
+	}
+	return b.Amount
+}
+
+// Exhausted reports whether a renter with balance can no longer pay, so
+// their rental ends.
+func Exhausted(balance money.Amount) bool {
+	return balance <= 0
+}
+
+// Resumed returns the node's last_balance_update_timestamp once billing
+// resumes at now. The billing window is moved forward by the length of the
+// pause so the time the node was unreachable is never charged.
+func (n NodeState) Resumed(now time.Time) sql.NullTime {
+	billedUntil := n.LastBalanceUpdateTimestamp
+	if billedUntil.Valid && n.BillingPausedAt.Valid && now.After(n.BillingPausedAt.Time) {
+		billedUntil.Time = billedUntil.Time.Add(now.Sub(n.BillingPausedAt.Time))
+	}
+	return billedUntil
+}
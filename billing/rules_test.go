@@ -0,0 +1,76 @@
+package billing
+
+import (
+	"database/sql"
+	"hvmnd/api/models"
+	"hvmnd/api/money"
+	"testing"
+	"time"
+)
+
+func rented(price string, since time.Time) NodeState {
+	return NodeState{
+		ID:                         1,
+		Status:                     models.NodeStatusRented,
+		Price:                      money.MustParse(price),
+		Renter:                     sql.NullInt16{Int16: 1, Valid: true},
+		LastBalanceUpdateTimestamp: sql.NullTime{Time: since, Valid: true},
+	}
+}
+
+func TestDue(t *testing.T) {
+	since := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	node := rented("60", since)
+
+	tests := []struct {
+		name        string
+		until       time.Time
+		final       bool
+		pausedAt    time.Time
+		minutes     int
+		amount      string
+		billedUntil time.Time
+	}{
+		{"whole minutes only", since.Add(90*time.Minute + 30*time.Second), false, time.Time{}, 90, "90", since.Add(90 * time.Minute)},
+		{"final rounds up", since.Add(90*time.Minute + 30*time.Second), true, time.Time{}, 91, "91", since.Add(91 * time.Minute)},
+		{"stops at the pause", since.Add(2 * time.Hour), false, since.Add(30 * time.Minute), 30, "30", since.Add(30 * time.Minute)},
+		{"nothing yet", since.Add(30 * time.Second), false, time.Time{}, 0, "0", since},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := node
+			if !tt.pausedAt.IsZero() {
+				node.BillingPausedAt = sql.NullTime{Time: tt.pausedAt, Valid: true}
+			}
+
+			bill, err := node.Due(tt.until, tt.final)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bill.Minutes != tt.minutes || bill.Amount != money.MustParse(tt.amount) || !bill.BilledUntil.Equal(tt.billedUntil) {
+				t.Fatalf("got %+v", bill)
+			}
+		})
+	}
+}
+
+func TestCollectNeverExceedsTheBalance(t *testing.T) {
+	bill := Bill{Minutes: 10, Amount: money.MustParse("10")}
+
+	for balance, want := range map[string]string{"25": "10", "4": "4", "-3": "0"} {
+		if got := bill.Collect(money.MustParse(balance)); got != money.MustParse(want) {
+			t.Errorf("balance %s: want %s, got %v", balance, want, got)
+		}
+	}
+}
+
+func TestResumedSkipsThePause(t *testing.T) {
+	since := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	node := rented("60", since)
+	node.BillingPausedAt = sql.NullTime{Time: since.Add(10 * time.Minute), Valid: true}
+
+	resumed := node.Resumed(since.Add(40 * time.Minute))
+	if !resumed.Valid || !resumed.Time.Equal(since.Add(30*time.Minute)) {
+		t.Fatalf("want the window moved by the 30 minute pause, got %v", resumed)
+	}
+}
@@ -0,0 +1,73 @@
+package billing
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// DefaultTick is the billing interval used when BILLING_TICK is not set.
+const DefaultTick = time.Minute
+
+// Biller is the storage the worker bills through.
+type Biller interface {
+	RentedNodeIDs(ctx context.Context) ([]int, error)
+	// BillNode charges one node up to now in a single transaction and
+	// reports whether the node was released for lack of balance.
+	BillNode(ctx context.Context, nodeID int, now time.Time) (bool, error)
+}
+
+// Worker periodically charges renters for every rented node.
+type Worker struct {
+	Nodes Biller
+	Tick  time.Duration
+}
+
+// NewWorker returns a worker that bills on every tick.
+func NewWorker(nodes Biller, tick time.Duration) *Worker {
+	if tick <= 0 {
+		tick = DefaultTick
+	}
+	return &Worker{Nodes: nodes, Tick: tick}
+}
+
+// Run bills rented nodes on every tick until stop is closed.
+func (w *Worker) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(w.Tick)
+	defer ticker.Stop()
+
+	for {
+		w.RunOnce(time.Now())
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce charges every rented node up to now. Each node is billed in its own
+// transaction so a failure on one node does not block the others. Because the
+// node's last_balance_update_timestamp is advanced in the same transaction as
+// the debit, repeated or restarted runs never bill the same minute twice.
+func (w *Worker) RunOnce(now time.Time) {
+	ctx := context.Background()
+
+	nodeIDs, err := w.Nodes.RentedNodeIDs(ctx)
+	if err != nil {
+		log.Printf("billing: failed to list rented nodes: %v", err)
+		return
+	}
+
+	for _, nodeID := range nodeIDs {
+		released, err := w.Nodes.BillNode(ctx, nodeID, now)
+		if err != nil {
+			log.Printf("billing: failed to bill node %d: %v", nodeID, err)
+			continue
+		}
+		if released {
+			log.Printf("billing: released node %d, renter ran out of balance", nodeID)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package currency
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultBase is the base currency used when BASE_CURRENCY is not set.
+const DefaultBase = "USD"
+
+// base is the currency balances, node prices and the ledger are kept in.
+var base = DefaultBase
+
+// Init configures the base currency. It must be called before the API starts
+// serving.
+func Init(code string) error {
+	if code == "" {
+		base = DefaultBase
+		return nil
+	}
+
+	code = Normalize(code)
+	if !Valid(code) {
+		return fmt.Errorf("currency: invalid base currency %q", code)
+	}
+	base = code
+	return nil
+}
+
+// Base returns the base currency.
+func Base() string {
+	return base
+}
+
+// Normalize upper-cases a currency code.
+func Normalize(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Valid reports whether code looks like an ISO 4217 code: three upper-case
+// letters.
+func Valid(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
@@ -12,7 +12,27 @@ import (
 
 var PostgresEngine *sql.DB
 
+// InitDB connects to Postgres and, unless AUTO_MIGRATE is set to false,
+// applies any pending schema migrations.
 func InitDB() {
+	Connect()
+
+	if os.Getenv("AUTO_MIGRATE") == "false" {
+		return
+	}
+
+	applied, err := MigrateUp(PostgresEngine)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, migration := range applied {
+		fmt.Printf("Applied migration %04d_%s\n", migration.Version, migration.Name)
+	}
+}
+
+// Connect opens the connection pool from POSTGRES_URL without touching the
+// schema.
+func Connect() {
 	err := godotenv.Load()
 	if err != nil {
 		log.Fatal("Error loading .env file")
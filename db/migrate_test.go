@@ -0,0 +1,132 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMigrationsAreNumberedInOrder(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Fatalf("want migration %d to be version %d, got %04d_%s", i, i+1, migration.Version, migration.Name)
+		}
+		if migration.Down == "" {
+			t.Errorf("migration %04d_%s has no down script", migration.Version, migration.Name)
+		}
+	}
+}
+
+// emptyDatabase connects to the database in TEST_POSTGRES_URL with a new,
+// empty schema first on the search path, and drops the schema afterwards.
+// It is skipped when TEST_POSTGRES_URL is not set.
+func emptyDatabase(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_POSTGRES_URL")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_URL is not set")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := fmt.Sprintf("migrate_test_%d", rand.Int63())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	// lib/pq passes unknown connection parameters on as settings
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := u.Query()
+		query.Set("search_path", schema)
+		u.RawQuery = query.Encode()
+		dsn = u.String()
+	} else {
+		dsn += " search_path=" + schema
+	}
+
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestMigrateUpFromAnEmptyDatabase(t *testing.T) {
+	conn := emptyDatabase(t)
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := MigrateUp(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Fatalf("want all %d migrations applied, got %d", len(migrations), len(applied))
+	}
+
+	// A second run has nothing left to do
+	applied, err = MigrateUp(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 {
+		t.Fatalf("want nothing applied again, got %d migrations", len(applied))
+	}
+
+	rows, err := conn.Query("SELECT version, name FROM schema_migrations ORDER BY version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var recorded []Migration
+	for rows.Next() {
+		var migration Migration
+		if err := rows.Scan(&migration.Version, &migration.Name); err != nil {
+			t.Fatal(err)
+		}
+		recorded = append(recorded, migration)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != len(migrations) {
+		t.Fatalf("want %d versions recorded, got %d", len(migrations), len(recorded))
+	}
+	for i, migration := range migrations {
+		if recorded[i].Version != migration.Version || recorded[i].Name != migration.Name {
+			t.Fatalf("want %04d_%s recorded, got %04d_%s", migration.Version, migration.Name, recorded[i].Version, recorded[i].Name)
+		}
+	}
+
+	states, err := MigrationStatus(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range states {
+		if !state.AppliedAt.Valid {
+			t.Fatalf("want %04d_%s reported as applied", state.Version, state.Name)
+		}
+	}
+}
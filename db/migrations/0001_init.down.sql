@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS quiz_answers;
+DROP TABLE IF EXISTS quiz_hash_map;
+DROP TABLE IF EXISTS payments;
+DROP TABLE IF EXISTS nodes;
+DROP TABLE IF EXISTS users;
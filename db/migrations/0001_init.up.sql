@@ -0,0 +1,56 @@
+-- Base schema. Statements use IF NOT EXISTS so databases created before
+-- migrations existed can adopt this version without changes.
+CREATE TABLE IF NOT EXISTS users (
+    id            SERIAL PRIMARY KEY,
+    telegram_id   BIGINT           NOT NULL UNIQUE,
+    total_spent   DOUBLE PRECISION NOT NULL DEFAULT 0,
+    balance       DOUBLE PRECISION NOT NULL DEFAULT 0,
+    first_name    TEXT,
+    last_name     TEXT,
+    username      TEXT,
+    language_code TEXT,
+    banned        BOOLEAN DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS nodes (
+    id                            SERIAL PRIMARY KEY,
+    old_id                        INTEGER UNIQUE,
+    any_desk_address              TEXT             NOT NULL UNIQUE,
+    any_desk_password             TEXT             NOT NULL,
+    status                        TEXT             NOT NULL DEFAULT 'available',
+    software                      TEXT,
+    price                         DOUBLE PRECISION NOT NULL DEFAULT 0,
+    renter                        SMALLINT REFERENCES users (id),
+    rent_start_time               TIMESTAMPTZ,
+    last_balance_update_timestamp TIMESTAMPTZ,
+    cpu                           TEXT,
+    gpu                           TEXT,
+    other_specs                   TEXT,
+    licenses                      TEXT,
+    machine_id                    TEXT
+);
+
+CREATE TABLE IF NOT EXISTS payments (
+    id       SERIAL PRIMARY KEY,
+    user_id  INTEGER          NOT NULL REFERENCES users (id),
+    amount   DOUBLE PRECISION NOT NULL,
+    status   TEXT             NOT NULL DEFAULT 'unpaid',
+    datetime TIMESTAMPTZ      NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS payments_user_id_idx ON payments (user_id);
+
+CREATE TABLE IF NOT EXISTS quiz_hash_map (
+    hash     TEXT PRIMARY KEY,
+    question TEXT NOT NULL,
+    answer   TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS quiz_answers (
+    id          SERIAL PRIMARY KEY,
+    telegram_id BIGINT NOT NULL,
+    question    TEXT   NOT NULL,
+    answer      TEXT   NOT NULL,
+    hash        TEXT   NOT NULL,
+    UNIQUE (telegram_id, question)
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS balance_ledger;
+DROP FUNCTION IF EXISTS balance_ledger_immutable();
+DROP SEQUENCE IF EXISTS balance_ledger_transaction_seq;
@@ -0,0 +1,41 @@
+-- Append-only, double-entry record of every change to users.balance.
+-- Each transfer is stored as two rows sharing a transaction_id: one on the
+-- user's account and one on a system counter account, summing to zero.
+CREATE SEQUENCE IF NOT EXISTS balance_ledger_transaction_seq;
+
+CREATE TABLE IF NOT EXISTS balance_ledger (
+    id             BIGSERIAL PRIMARY KEY,
+    transaction_id BIGINT      NOT NULL,
+    account        TEXT        NOT NULL,
+    user_id        INTEGER     REFERENCES users (id),
+    amount         NUMERIC     NOT NULL,
+    reason         TEXT        NOT NULL,
+    reference_id   TEXT,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS balance_ledger_user_id_idx ON balance_ledger (user_id, id);
+CREATE INDEX IF NOT EXISTS balance_ledger_transaction_id_idx ON balance_ledger (transaction_id);
+
+CREATE OR REPLACE FUNCTION balance_ledger_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'balance_ledger is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS balance_ledger_immutable ON balance_ledger;
+CREATE TRIGGER balance_ledger_immutable
+    BEFORE UPDATE OR DELETE ON balance_ledger
+    FOR EACH ROW EXECUTE FUNCTION balance_ledger_immutable();
+
+-- Seed an opening balance for users that existed before the ledger.
+WITH opening AS (
+    SELECT u.id, u.balance, nextval('balance_ledger_transaction_seq') AS transaction_id
+    FROM users u
+    WHERE u.balance <> 0
+      AND NOT EXISTS (SELECT 1 FROM balance_ledger l WHERE l.user_id = u.id)
+)
+INSERT INTO balance_ledger (transaction_id, account, user_id, amount, reason)
+SELECT transaction_id, 'user:' || id, id, balance, 'opening_balance' FROM opening
+UNION ALL
+SELECT transaction_id, 'equity:opening_balances', NULL, -balance, 'opening_balance' FROM opening;
@@ -0,0 +1,18 @@
+-- One row per rental of a node, opened by the rent flow and closed when the
+-- node is released.
+CREATE TABLE IF NOT EXISTS rentals (
+    id             SERIAL PRIMARY KEY,
+    node_id        INTEGER     NOT NULL REFERENCES nodes (id),
+    user_id        INTEGER     NOT NULL REFERENCES users (id),
+    started_at     TIMESTAMPTZ NOT NULL,
+    ended_at       TIMESTAMPTZ,
+    price_per_hour NUMERIC     NOT NULL,
+    total_charged  NUMERIC     NOT NULL DEFAULT 0,
+    end_reason     TEXT
+);
+
+CREATE INDEX IF NOT EXISTS rentals_user_id_idx ON rentals (user_id, started_at);
+CREATE INDEX IF NOT EXISTS rentals_node_id_idx ON rentals (node_id, started_at);
+
+-- A node can only have one open rental at a time.
+CREATE UNIQUE INDEX IF NOT EXISTS rentals_open_node_idx ON rentals (node_id) WHERE ended_at IS NULL;
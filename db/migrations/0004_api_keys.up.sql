@@ -0,0 +1,10 @@
+-- API keys used to authenticate callers. Only the SHA-256 hash of each key
+-- is stored; the plaintext is shown once when the key is issued.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id         SERIAL PRIMARY KEY,
+    name       TEXT        NOT NULL,
+    key_hash   TEXT        NOT NULL UNIQUE,
+    role       TEXT        NOT NULL CHECK (role IN ('bot', 'admin', 'readonly', 'payment-provider')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    revoked_at TIMESTAMPTZ
+);
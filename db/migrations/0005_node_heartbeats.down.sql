@@ -0,0 +1,10 @@
+DELETE FROM api_keys WHERE role = 'node-agent';
+ALTER TABLE api_keys DROP CONSTRAINT IF EXISTS api_keys_role_check;
+ALTER TABLE api_keys ADD CONSTRAINT api_keys_role_check
+    CHECK (role IN ('bot', 'admin', 'readonly', 'payment-provider'));
+
+DROP TABLE IF EXISTS node_heartbeats;
+
+ALTER TABLE nodes
+    DROP COLUMN IF EXISTS billing_paused_at,
+    DROP COLUMN IF EXISTS last_heartbeat_at;
@@ -0,0 +1,21 @@
+-- Liveness reported by the agent running on each node. A rented node that
+-- stops sending heartbeats has its billing paused from billing_paused_at.
+ALTER TABLE nodes
+    ADD COLUMN IF NOT EXISTS last_heartbeat_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS billing_paused_at TIMESTAMPTZ;
+
+-- The most recent heartbeat of each node
+CREATE TABLE IF NOT EXISTS node_heartbeats (
+    node_id          INTEGER PRIMARY KEY REFERENCES nodes (id),
+    received_at      TIMESTAMPTZ NOT NULL,
+    uptime_seconds   BIGINT      NOT NULL,
+    any_desk_address TEXT        NOT NULL,
+    cpu_percent      DOUBLE PRECISION,
+    memory_percent   DOUBLE PRECISION,
+    gpu_percent      DOUBLE PRECISION
+);
+
+-- Node agents authenticate with their own role
+ALTER TABLE api_keys DROP CONSTRAINT IF EXISTS api_keys_role_check;
+ALTER TABLE api_keys ADD CONSTRAINT api_keys_role_check
+    CHECK (role IN ('bot', 'admin', 'readonly', 'payment-provider', 'node-agent'));
@@ -0,0 +1,4 @@
+ALTER TABLE nodes DROP CONSTRAINT IF EXISTS nodes_status_check;
+DROP TRIGGER IF EXISTS nodes_status_history ON nodes;
+DROP FUNCTION IF EXISTS record_node_status_change();
+DROP TABLE IF EXISTS node_status_history;
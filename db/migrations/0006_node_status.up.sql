@@ -0,0 +1,43 @@
+-- Every change of nodes.status, attributed to the actor the API sets in the
+-- hvmnd.actor setting of the transaction.
+CREATE TABLE IF NOT EXISTS node_status_history (
+    id          BIGSERIAL PRIMARY KEY,
+    node_id     INTEGER     NOT NULL REFERENCES nodes (id),
+    from_status TEXT,
+    to_status   TEXT        NOT NULL,
+    changed_by  TEXT        NOT NULL,
+    changed_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS node_status_history_node_id_idx ON node_status_history (node_id, id);
+
+CREATE OR REPLACE FUNCTION record_node_status_change() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' OR NEW.status IS DISTINCT FROM OLD.status THEN
+        INSERT INTO node_status_history (node_id, from_status, to_status, changed_by)
+        VALUES (
+            NEW.id,
+            CASE WHEN TG_OP = 'UPDATE' THEN OLD.status END,
+            NEW.status,
+            COALESCE(NULLIF(current_setting('hvmnd.actor', true), ''), current_user)
+        );
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS nodes_status_history ON nodes;
+CREATE TRIGGER nodes_status_history
+    AFTER INSERT OR UPDATE OF status ON nodes
+    FOR EACH ROW EXECUTE FUNCTION record_node_status_change();
+
+-- Park nodes with a misspelled status in maintenance so they can be fixed,
+-- then reject unknown statuses from now on.
+SELECT set_config('hvmnd.actor', 'migration:0006_node_status', true);
+
+UPDATE nodes SET status = 'maintenance'
+WHERE status NOT IN ('available', 'rented', 'maintenance', 'offline', 'decommissioned');
+
+ALTER TABLE nodes DROP CONSTRAINT IF EXISTS nodes_status_check;
+ALTER TABLE nodes ADD CONSTRAINT nodes_status_check
+    CHECK (status IN ('available', 'rented', 'maintenance', 'offline', 'decommissioned'));
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS node_software;
+
+ALTER TABLE nodes
+    DROP COLUMN IF EXISTS storage_gb,
+    DROP COLUMN IF EXISTS ram_gb,
+    DROP COLUMN IF EXISTS cpu_cores,
+    DROP COLUMN IF EXISTS vram_gb,
+    DROP COLUMN IF EXISTS gpu_model;
@@ -0,0 +1,29 @@
+-- Structured hardware specs next to the free-text cpu, gpu and other_specs
+-- columns, which are kept for older clients.
+ALTER TABLE nodes
+    ADD COLUMN IF NOT EXISTS gpu_model  TEXT,
+    ADD COLUMN IF NOT EXISTS vram_gb    INTEGER CHECK (vram_gb >= 0),
+    ADD COLUMN IF NOT EXISTS cpu_cores  INTEGER CHECK (cpu_cores >= 0),
+    ADD COLUMN IF NOT EXISTS ram_gb     INTEGER CHECK (ram_gb >= 0),
+    ADD COLUMN IF NOT EXISTS storage_gb INTEGER CHECK (storage_gb >= 0);
+
+-- Software installed on a node and the licenses it holds, one row each.
+CREATE TABLE IF NOT EXISTS node_software (
+    node_id INTEGER NOT NULL REFERENCES nodes (id),
+    name    TEXT    NOT NULL,
+    kind    TEXT    NOT NULL CHECK (kind IN ('software', 'license')),
+    PRIMARY KEY (node_id, kind, name)
+);
+
+CREATE INDEX IF NOT EXISTS node_software_name_idx ON node_software (lower(name));
+
+-- Seed the catalog from the comma separated software and licenses columns.
+INSERT INTO node_software (node_id, name, kind)
+SELECT id, trim(name), 'software'
+FROM nodes, unnest(string_to_array(software, ',')) AS name
+WHERE trim(name) <> ''
+UNION
+SELECT id, trim(name), 'license'
+FROM nodes, unnest(string_to_array(licenses, ',')) AS name
+WHERE trim(name) <> ''
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,15 @@
+-- Responses to requests made with an Idempotency-Key, replayed when a client
+-- retries. status_code is NULL while the first request is in flight.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    scope        TEXT        NOT NULL,
+    key          TEXT        NOT NULL,
+    fingerprint  TEXT        NOT NULL,
+    status_code  INTEGER,
+    content_type TEXT,
+    body         BYTEA,
+    created_at   TIMESTAMPTZ NOT NULL,
+    expires_at   TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (scope, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
@@ -0,0 +1,17 @@
+-- Webhook events delivered by payment providers. The payload is kept exactly
+-- as received so that its signature can be checked again later.
+CREATE TABLE IF NOT EXISTS payment_events (
+    id           BIGSERIAL PRIMARY KEY,
+    provider     TEXT             NOT NULL,
+    event_id     TEXT             NOT NULL,
+    event_type   TEXT             NOT NULL,
+    payment_id   INTEGER          NOT NULL,
+    amount       DOUBLE PRECISION NOT NULL DEFAULT 0,
+    payload      BYTEA            NOT NULL,
+    result       TEXT,
+    received_at  TIMESTAMPTZ      NOT NULL,
+    processed_at TIMESTAMPTZ,
+    UNIQUE (provider, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS payment_events_payment_id_idx ON payment_events (payment_id);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS payments_unpaid_datetime_idx;
+
+-- Expired tickets go back to the queue of unpaid ones
+UPDATE payments SET status = 'unpaid' WHERE status = 'expired';
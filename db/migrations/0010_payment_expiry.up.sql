@@ -0,0 +1,2 @@
+-- Unpaid tickets are expired by a background job that scans them by age
+CREATE INDEX IF NOT EXISTS payments_unpaid_datetime_idx ON payments (datetime) WHERE status = 'unpaid';
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS payment_refunds;
+
+ALTER TABLE payments DROP COLUMN IF EXISTS refunded_amount;
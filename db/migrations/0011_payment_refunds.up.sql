@@ -0,0 +1,15 @@
+-- Partial refunds of paid tickets. payments.refunded_amount is the sum of a
+-- ticket's refunds, kept next to the amount so both are read under one lock.
+ALTER TABLE payments
+    ADD COLUMN IF NOT EXISTS refunded_amount DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS payment_refunds (
+    id         SERIAL PRIMARY KEY,
+    payment_id INTEGER          NOT NULL REFERENCES payments (id),
+    amount     DOUBLE PRECISION NOT NULL CHECK (amount > 0),
+    reason     TEXT             NOT NULL,
+    created_by TEXT             NOT NULL,
+    created_at TIMESTAMPTZ      NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS payment_refunds_payment_id_idx ON payment_refunds (payment_id);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS exchange_rates;
+
+ALTER TABLE payments
+    DROP COLUMN IF EXISTS exchange_rate,
+    DROP COLUMN IF EXISTS currency;
@@ -0,0 +1,15 @@
+-- Tickets carry the currency they are paid in. Tickets created before this
+-- migration have no currency and are in the base currency.
+ALTER TABLE payments
+    ADD COLUMN IF NOT EXISTS currency      TEXT,
+    ADD COLUMN IF NOT EXISTS exchange_rate DOUBLE PRECISION;
+
+-- Base currency value of one unit of each other currency, by the time from
+-- which it applies
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    currency       TEXT             NOT NULL,
+    rate           DOUBLE PRECISION NOT NULL CHECK (rate > 0),
+    effective_from TIMESTAMPTZ      NOT NULL,
+    created_at     TIMESTAMPTZ      NOT NULL DEFAULT now(),
+    PRIMARY KEY (currency, effective_from)
+);
@@ -0,0 +1,27 @@
+ALTER TABLE rentals
+    ALTER COLUMN price_per_hour TYPE NUMERIC,
+    ALTER COLUMN total_charged  TYPE NUMERIC;
+
+ALTER TABLE balance_ledger
+    ALTER COLUMN amount TYPE NUMERIC;
+
+ALTER TABLE payment_events
+    ALTER COLUMN amount TYPE DOUBLE PRECISION;
+
+ALTER TABLE payment_refunds
+    ALTER COLUMN amount TYPE DOUBLE PRECISION;
+
+ALTER TABLE exchange_rates
+    ALTER COLUMN rate TYPE DOUBLE PRECISION;
+
+ALTER TABLE payments
+    ALTER COLUMN amount          TYPE DOUBLE PRECISION,
+    ALTER COLUMN refunded_amount TYPE DOUBLE PRECISION,
+    ALTER COLUMN exchange_rate   TYPE DOUBLE PRECISION;
+
+ALTER TABLE nodes
+    ALTER COLUMN price TYPE DOUBLE PRECISION;
+
+ALTER TABLE users
+    ALTER COLUMN total_spent TYPE DOUBLE PRECISION,
+    ALTER COLUMN balance     TYPE DOUBLE PRECISION;
@@ -0,0 +1,61 @@
+-- Money is kept as exact decimals with two places instead of floating point,
+-- and exchange rates with ten. Existing values are rounded.
+
+-- Users whose balance agreed with their ledger, up to floating point error,
+-- before anything is rounded
+CREATE TEMPORARY TABLE reconciled_users ON COMMIT DROP AS
+SELECT u.id
+FROM users u
+LEFT JOIN balance_ledger l ON l.user_id = u.id
+GROUP BY u.id, u.balance
+HAVING abs(u.balance::NUMERIC - COALESCE(SUM(l.amount), 0)) < 0.005;
+
+-- Ledger rows are rounded first. Both sides of a transfer round the same
+-- way, so transactions still sum to zero.
+ALTER TABLE balance_ledger
+    ALTER COLUMN amount TYPE NUMERIC(20, 2) USING round(amount, 2);
+
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM balance_ledger GROUP BY transaction_id HAVING SUM(amount) <> 0) THEN
+        RAISE EXCEPTION 'balance_ledger transactions no longer sum to zero after rounding';
+    END IF;
+END;
+$$;
+
+-- Rounding the balance and its ledger rows separately can leave them a cent
+-- apart, so the balances that agreed with the ledger are recomputed from it
+ALTER TABLE users
+    ALTER COLUMN total_spent TYPE NUMERIC(20, 2) USING round(total_spent::NUMERIC, 2),
+    ALTER COLUMN balance     TYPE NUMERIC(20, 2) USING round(balance::NUMERIC, 2);
+
+UPDATE users u
+SET balance = ledger.total
+FROM (
+    SELECT r.id, COALESCE(SUM(l.amount), 0) AS total
+    FROM reconciled_users r
+    LEFT JOIN balance_ledger l ON l.user_id = r.id
+    GROUP BY r.id
+) ledger
+WHERE u.id = ledger.id AND u.balance <> ledger.total;
+
+ALTER TABLE nodes
+    ALTER COLUMN price TYPE NUMERIC(20, 2) USING round(price::NUMERIC, 2);
+
+ALTER TABLE payments
+    ALTER COLUMN amount          TYPE NUMERIC(20, 2) USING round(amount::NUMERIC, 2),
+    ALTER COLUMN refunded_amount TYPE NUMERIC(20, 2) USING round(refunded_amount::NUMERIC, 2),
+    ALTER COLUMN exchange_rate   TYPE NUMERIC(18, 10) USING round(exchange_rate::NUMERIC, 10);
+
+ALTER TABLE exchange_rates
+    ALTER COLUMN rate TYPE NUMERIC(18, 10) USING round(rate::NUMERIC, 10);
+
+ALTER TABLE payment_refunds
+    ALTER COLUMN amount TYPE NUMERIC(20, 2) USING round(amount::NUMERIC, 2);
+
+ALTER TABLE payment_events
+    ALTER COLUMN amount TYPE NUMERIC(20, 2) USING round(amount::NUMERIC, 2);
+
+ALTER TABLE rentals
+    ALTER COLUMN price_per_hour TYPE NUMERIC(20, 2) USING round(price_per_hour, 2),
+    ALTER COLUMN total_charged  TYPE NUMERIC(20, 2) USING round(total_charged, 2);
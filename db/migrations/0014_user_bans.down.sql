@@ -0,0 +1,3 @@
+ALTER TABLE users
+    DROP COLUMN IF EXISTS banned_until,
+    DROP COLUMN IF EXISTS ban_reason;
@@ -0,0 +1,5 @@
+-- Bans carry a reason and, for temporary bans, the time they lapse.
+-- banned_until is NULL for a permanent ban.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS ban_reason   TEXT,
+    ADD COLUMN IF NOT EXISTS banned_until TIMESTAMPTZ;
@@ -0,0 +1,14 @@
+-- Manual changes to a user's balance and total spent, made by admins with a
+-- reason. The balance delta is also in balance_ledger, referenced as
+-- adjustment:<id>.
+CREATE TABLE IF NOT EXISTS user_adjustments (
+    id                SERIAL PRIMARY KEY,
+    user_id           INTEGER        NOT NULL REFERENCES users (id),
+    balance_delta     NUMERIC(20, 2) NOT NULL DEFAULT 0,
+    total_spent_delta NUMERIC(20, 2) NOT NULL DEFAULT 0,
+    reason            TEXT           NOT NULL,
+    created_by        TEXT           NOT NULL,
+    created_at        TIMESTAMPTZ    NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS user_adjustments_user_id_idx ON user_adjustments (user_id);
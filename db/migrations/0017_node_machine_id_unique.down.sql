@@ -0,0 +1 @@
+DROP INDEX IF EXISTS nodes_machine_id_active_key;
@@ -0,0 +1,7 @@
+-- Only one node in service may use a machine id. Decommissioned nodes keep
+-- theirs so the id can be reused by the machine's next registration. Creating
+-- the index fails if two nodes in service already share a machine id; those
+-- have to be resolved by hand first.
+CREATE UNIQUE INDEX IF NOT EXISTS nodes_machine_id_active_key
+    ON nodes (machine_id)
+    WHERE status <> 'decommissioned';
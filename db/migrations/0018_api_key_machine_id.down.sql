@@ -0,0 +1,2 @@
+ALTER TABLE api_keys DROP CONSTRAINT IF EXISTS api_keys_machine_id_check;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS machine_id;
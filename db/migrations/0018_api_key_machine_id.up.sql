@@ -0,0 +1,8 @@
+-- Node-agent keys are bound to the machine whose heartbeats they send. Keys
+-- issued before this have no machine and can no longer send heartbeats; each
+-- agent needs a new key.
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS machine_id TEXT;
+
+ALTER TABLE api_keys DROP CONSTRAINT IF EXISTS api_keys_machine_id_check;
+ALTER TABLE api_keys ADD CONSTRAINT api_keys_machine_id_check
+    CHECK (machine_id IS NULL OR role = 'node-agent');
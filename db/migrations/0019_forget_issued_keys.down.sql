@@ -0,0 +1 @@
+-- The deleted responses cannot be restored.
@@ -0,0 +1,4 @@
+-- Issuing an API key is no longer idempotent, because the stored response
+-- held the plaintext key. Drop the responses already stored for it.
+DELETE FROM idempotency_keys
+WHERE position(convert_to('"key":"hvmnd_', 'UTF8') IN body) > 0;
@@ -0,0 +1,12 @@
+DROP TRIGGER IF EXISTS quiz_answers_audit ON quiz_answers;
+DROP TRIGGER IF EXISTS quiz_hash_map_audit ON quiz_hash_map;
+DROP TRIGGER IF EXISTS api_keys_audit ON api_keys;
+DROP TRIGGER IF EXISTS user_adjustments_audit ON user_adjustments;
+DROP TRIGGER IF EXISTS exchange_rates_audit ON exchange_rates;
+DROP TRIGGER IF EXISTS payment_refunds_audit ON payment_refunds;
+DROP TRIGGER IF EXISTS payments_audit ON payments;
+DROP TRIGGER IF EXISTS rentals_audit ON rentals;
+DROP TRIGGER IF EXISTS node_software_audit ON node_software;
+DROP TRIGGER IF EXISTS nodes_audit ON nodes;
+DROP TRIGGER IF EXISTS users_audit ON users;
+DROP FUNCTION IF EXISTS audit_row_change();
@@ -0,0 +1,105 @@
+-- Changes made through the API are logged by triggers, in the same
+-- transaction as the change. The API names the route in the hvmnd.route
+-- setting of the transaction; changes made without one, such as by the
+-- billing worker, are not logged here.
+--
+-- The trigger arguments are the entity type, the column that identifies the
+-- entity, and any columns that must not be logged.
+CREATE OR REPLACE FUNCTION audit_row_change() RETURNS trigger AS $$
+DECLARE
+    audit_route TEXT := current_setting('hvmnd.route', true);
+    old_fields  JSONB;
+    new_fields  JSONB;
+    field       TEXT;
+BEGIN
+    IF audit_route IS NULL OR audit_route = '' THEN
+        RETURN NULL;
+    END IF;
+
+    IF TG_OP <> 'INSERT' THEN
+        old_fields := to_jsonb(OLD) - TG_ARGV[2:];
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        new_fields := to_jsonb(NEW) - TG_ARGV[2:];
+    END IF;
+
+    -- An update keeps only the fields that changed
+    IF TG_OP = 'UPDATE' THEN
+        FOR field IN SELECT jsonb_object_keys(old_fields) LOOP
+            IF old_fields -> field = new_fields -> field THEN
+                old_fields := old_fields - field;
+                new_fields := new_fields - field;
+            END IF;
+        END LOOP;
+        IF old_fields = '{}'::JSONB AND new_fields = '{}'::JSONB THEN
+            RETURN NULL;
+        END IF;
+    END IF;
+
+    INSERT INTO audit_log (actor, route, entity_type, entity_id, before, after)
+    VALUES (
+        COALESCE(NULLIF(current_setting('hvmnd.actor', true), ''), current_user),
+        audit_route,
+        TG_ARGV[0],
+        COALESCE(to_jsonb(NEW), to_jsonb(OLD)) ->> TG_ARGV[1],
+        old_fields,
+        new_fields
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_audit ON users;
+CREATE TRIGGER users_audit
+    AFTER INSERT OR UPDATE OR DELETE ON users
+    FOR EACH ROW EXECUTE FUNCTION audit_row_change('user', 'id');
+
+DROP TRIGGER IF EXISTS nodes_audit ON nodes;
+CREATE TRIGGER nodes_audit
+    AFTER INSERT OR UPDATE OR DELETE ON nodes
+    FOR EACH ROW EXECUTE FUNCTION audit_row_change('node', 'id', 'any_desk_password');
+
+DROP TRIGGER IF EXISTS node_software_audit ON node_software;
+CREATE TRIGGER node_software_audit
+    AFTER INSERT OR UPDATE OR DELETE ON node_software
+    FOR EACH ROW EXECUTE FUNCTION audit_row_change('node_software', 'node_id');
+
+DROP TRIGGER IF EXISTS rentals_audit ON rentals;
+CREATE TRIGGER rentals_audit
+    AFTER INSERT OR UPDATE OR DELETE ON rentals
+    FOR EACH ROW EXECUTE FUNCTION audit_row_change('rental', 'id');
+
+DROP TRIGGER IF EXISTS payments_audit ON payments;
+CREATE TRIGGER payments_audit
+    AFTER INSERT OR UPDATE OR DELETE ON payments
+    FOR EACH ROW EXECUTE FUNCTION audit_row_change('payment', 'id');
+
+DROP TRIGGER IF EXISTS payment_refunds_audit ON payment_refunds;
+CREATE TRIGGER payment_refunds_audit
+    AFTER INSERT OR UPDATE OR DELETE ON payment_refunds
+    FOR EACH ROW EXECUTE FUNCTION audit_row_change('payment_refund', 'id');
+
+DROP TRIGGER IF EXISTS exchange_rates_audit ON exchange_rates;
+CREATE TRIGGER exchange_rates_audit
+    AFTER INSERT OR UPDATE OR DELETE ON exchange_rates
+    FOR EACH ROW EXECUTE FUNCTION audit_row_change('exchange_rate', 'currency');
+
+DROP TRIGGER IF EXISTS user_adjustments_audit ON user_adjustments;
+CREATE TRIGGER user_adjustments_audit
+    AFTER INSERT OR UPDATE OR DELETE ON user_adjustments
+    FOR EACH ROW EXECUTE FUNCTION audit_row_change('user_adjustment', 'id');
+
+DROP TRIGGER IF EXISTS api_keys_audit ON api_keys;
+CREATE TRIGGER api_keys_audit
+    AFTER INSERT OR UPDATE OR DELETE ON api_keys
+    FOR EACH ROW EXECUTE FUNCTION audit_row_change('api_key', 'id', 'key_hash');
+
+DROP TRIGGER IF EXISTS quiz_hash_map_audit ON quiz_hash_map;
+CREATE TRIGGER quiz_hash_map_audit
+    AFTER INSERT OR UPDATE OR DELETE ON quiz_hash_map
+    FOR EACH ROW EXECUTE FUNCTION audit_row_change('quiz_hash', 'hash');
+
+DROP TRIGGER IF EXISTS quiz_answers_audit ON quiz_answers;
+CREATE TRIGGER quiz_answers_audit
+    AFTER INSERT OR UPDATE OR DELETE ON quiz_answers
+    FOR EACH ROW EXECUTE FUNCTION audit_row_change('quiz_answer', 'telegram_id');
@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS nodes_audit ON nodes;
+CREATE TRIGGER nodes_audit
+    AFTER INSERT OR UPDATE OR DELETE ON nodes
+    FOR EACH ROW EXECUTE FUNCTION audit_row_change('node', 'id', 'any_desk_password');
+
+ALTER TABLE nodes DROP COLUMN IF EXISTS heartbeat_lost;
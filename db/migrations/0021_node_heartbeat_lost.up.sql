@@ -0,0 +1,21 @@
+-- heartbeat_lost is set while a node is offline because the heartbeat
+-- monitor stopped hearing from it. Only those nodes come back when a
+-- heartbeat arrives; a node an admin took offline stays offline.
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS heartbeat_lost BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Offline nodes whose last status change was made by the monitor
+UPDATE nodes n SET heartbeat_lost = TRUE
+WHERE n.status = 'offline'
+  AND (
+      SELECT h.changed_by
+      FROM node_status_history h
+      WHERE h.node_id = n.id
+      ORDER BY h.id DESC
+      LIMIT 1
+  ) = 'system';
+
+-- The flag is bookkeeping for the monitor, not a change worth auditing
+DROP TRIGGER IF EXISTS nodes_audit ON nodes;
+CREATE TRIGGER nodes_audit
+    AFTER INSERT OR UPDATE OR DELETE ON nodes
+    FOR EACH ROW EXECUTE FUNCTION audit_row_change('node', 'id', 'any_desk_password', 'heartbeat_lost');
@@ -0,0 +1,51 @@
+package db
+
+import (
+	"hvmnd/api/secrets"
+)
+
+// EncryptNodePasswords encrypts any any_desk_password values still stored in
+// plaintext and returns the number of rows migrated. It is safe to run on
+// every start.
+func EncryptNodePasswords() (int, error) {
+	rows, err := PostgresEngine.Query("SELECT id, any_desk_password FROM nodes WHERE any_desk_password NOT LIKE 'enc:%'")
+	if err != nil {
+		return 0, err
+	}
+
+	plaintexts := map[int]string{}
+	for rows.Next() {
+		var id int
+		var password string
+		if err := rows.Scan(&id, &password); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		plaintexts[id] = password
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for id, password := range plaintexts {
+		if secrets.IsEncrypted(password) {
+			continue
+		}
+
+		encrypted, err := secrets.Encrypt(password)
+		if err != nil {
+			return migrated, err
+		}
+
+		// Guard against a concurrent writer having replaced the value
+		_, err = PostgresEngine.Exec("UPDATE nodes SET any_desk_password = $1 WHERE id = $2 AND any_desk_password = $3", encrypted, id, password)
+		if err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
@@ -0,0 +1,69 @@
+package expiry
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// DefaultTTL is how long a payment ticket may stay unpaid when
+// PAYMENT_TICKET_TTL is not set.
+const DefaultTTL = 24 * time.Hour
+
+// DefaultTick is the longest interval between two sweeps.
+const DefaultTick = time.Minute
+
+// Expirer is the storage the sweeper expires tickets through.
+type Expirer interface {
+	ExpirePayments(ctx context.Context, cutoff time.Time) ([]int, error)
+}
+
+// Sweeper periodically expires payment tickets that stayed unpaid for longer
+// than the TTL.
+type Sweeper struct {
+	Payments Expirer
+	TTL      time.Duration
+	Tick     time.Duration
+}
+
+// NewSweeper returns a sweeper that runs every minute, or more often when the
+// TTL is short.
+func NewSweeper(payments Expirer, ttl time.Duration) *Sweeper {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	tick := DefaultTick
+	if ttl/4 < tick {
+		tick = ttl / 4
+	}
+	return &Sweeper{Payments: payments, TTL: ttl, Tick: tick}
+}
+
+// Run expires stale tickets on every tick until stop is closed.
+func (s *Sweeper) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.Tick)
+	defer ticker.Stop()
+
+	for {
+		s.RunOnce(time.Now())
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce expires every unpaid ticket created more than the TTL before now.
+func (s *Sweeper) RunOnce(now time.Time) {
+	paymentIDs, err := s.Payments.ExpirePayments(context.Background(), now.Add(-s.TTL))
+	if err != nil {
+		log.Printf("expiry: failed to expire unpaid tickets: %v", err)
+		return
+	}
+
+	for _, paymentID := range paymentIDs {
+		log.Printf("expiry: payment ticket %d expired unpaid", paymentID)
+	}
+}
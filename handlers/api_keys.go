@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"hvmnd/api/auth"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func (h *Handler) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.keys.ListKeys(r.Context())
+	if err != nil {
+		writeServerError(w, err.Error())
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Found %d API keys", len(keys)),
+		Data:    keys,
+	})
+}
+
+func (h *Handler) IssueAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name      string    `json:"name"`
+		Role      auth.Role `json:"role"`
+		MachineID string    `json:"machine_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	if req.Name == "" {
+		writeBadRequest(w, "name is required")
+		return
+	}
+	if !req.Role.Valid() {
+		writeBadRequest(w, "role must be one of bot, admin, readonly, payment-provider, node-agent")
+		return
+	}
+
+	// A node agent may only report on the machine it runs on
+	req.MachineID = strings.TrimSpace(req.MachineID)
+	if (req.Role == auth.RoleNodeAgent) != (req.MachineID != "") {
+		writeBadRequest(w, "machine_id is required for node-agent keys and not allowed for other roles")
+		return
+	}
+
+	plaintext, key, err := auth.IssueKey(r.Context(), h.keys, req.Name, req.Role, req.MachineID)
+	if err != nil {
+		writeJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Failed to issue API key: " + err.Error(),
+		})
+		return
+	}
+
+	writeJSONResponse(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "API key issued; store it now, it will not be shown again",
+		Data: map[string]interface{}{
+			"key":     plaintext,
+			"api_key": key,
+		},
+	})
+}
+
+func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeBadRequest(w, "Invalid API key id")
+		return
+	}
+
+	err = h.keys.RevokeKey(r.Context(), id)
+	if err != nil {
+		if err == auth.ErrKeyNotFound {
+			writeJSONResponse(w, http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   "API key not found",
+			})
+			return
+		}
+		writeServerError(w, err.Error())
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "API key revoked successfully",
+	})
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"fmt"
+	"hvmnd/api/models"
+	"hvmnd/api/store"
+	"net/http"
+	"time"
+)
+
+func (h *Handler) GetAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := store.AuditFilter{
+		Actor:      query.Get("actor"),
+		Route:      query.Get("route"),
+		EntityType: query.Get("entity_type"),
+		EntityID:   query.Get("entity_id"),
+	}
+
+	// from/to bound the time the changes were made
+	var err error
+	if from := query.Get("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			writeBadParam(w, "from")
+			return
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			writeBadParam(w, "to")
+			return
+		}
+	}
+
+	page, err := pageParam(r, store.AuditSortColumns)
+	if err != nil {
+		writeBadPage(w, err)
+		return
+	}
+	// The audit log only grows, so it is never listed in full
+	if page.Limit == 0 {
+		page.Limit = store.DefaultPageLimit
+	}
+
+	entries, info, err := h.audit.ListAudit(r.Context(), filter, page)
+	if err != nil {
+		writeJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Failed to fetch audit log: " + err.Error(),
+		})
+		return
+	}
+
+	if entries == nil {
+		entries = []models.AuditEntry{}
+	}
+
+	writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success:    true,
+		Message:    fmt.Sprintf("Found %d audit entries", len(entries)),
+		Data:       entries,
+		NextCursor: info.NextCursor,
+		Total:      &info.Total,
+	})
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"hvmnd/api/store"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func writeBanned(w http.ResponseWriter) {
+	writeJSONResponse(w, http.StatusForbidden, APIResponse{
+		Success: false,
+		Error:   "User is banned",
+	})
+}
+
+func (h *Handler) BanUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeBadParam(w, "user id")
+		return
+	}
+
+	// until is optional; without it the ban is permanent
+	var req struct {
+		Reason string     `json:"reason"`
+		Until  *time.Time `json:"until"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		writeBadRequest(w, "reason is required")
+		return
+	}
+
+	now := time.Now()
+	var until time.Time
+	if req.Until != nil {
+		if !req.Until.After(now) {
+			writeBadRequest(w, "until must be in the future")
+			return
+		}
+		until = *req.Until
+	}
+
+	user, released, err := h.users.BanUser(r.Context(), userID, req.Reason, until, now)
+	if err != nil {
+		if err == store.ErrNotFound {
+			writeJSONResponse(w, http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   "User not found",
+			})
+			return
+		}
+		writeJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Failed to ban user: " + err.Error(),
+		})
+		return
+	}
+
+	if released == nil {
+		released = []int{}
+	}
+
+	writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("User banned; released %d nodes", len(released)),
+		Data: map[string]interface{}{
+			"user":           user,
+			"released_nodes": released,
+		},
+	})
+}
+
+func (h *Handler) UnbanUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeBadParam(w, "user id")
+		return
+	}
+
+	user, err := h.users.UnbanUser(r.Context(), userID)
+	if err != nil {
+		if err == store.ErrNotFound {
+			writeJSONResponse(w, http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   "User not found",
+			})
+			return
+		}
+		writeJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Failed to unban user: " + err.Error(),
+		})
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "User unbanned",
+		Data:    user,
+	})
+}
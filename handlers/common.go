@@ -2,18 +2,167 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"hvmnd/api/auth"
+	"hvmnd/api/providers"
+	"hvmnd/api/requestlog"
+	"hvmnd/api/store"
+	"log/slog"
 	"net/http"
+	"strconv"
 )
 
 type APIResponse struct {
-	Success bool        `json:"success"`
-	Message string      `json:"message,omitempty"`
-	Data    interface{} `json:"data,omitempty"`
-	Error   string      `json:"error,omitempty"`
+	Success    bool        `json:"success"`
+	Message    string      `json:"message,omitempty"`
+	Data       interface{} `json:"data,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	Total      *int        `json:"total,omitempty"`
+	RequestID  string      `json:"request_id,omitempty"`
 }
 
+// Stores bundles the persistence dependencies of the handlers.
+type Stores struct {
+	Users    store.UserStore
+	Nodes    store.NodeStore
+	Payments store.PaymentStore
+	Rates    store.ExchangeRateStore
+	Quiz     store.QuizStore
+	Keys     auth.KeyStore
+	Audit    store.AuditStore
+}
+
+// Handler serves the HTTP API on top of the given stores.
+type Handler struct {
+	users    store.UserStore
+	nodes    store.NodeStore
+	payments store.PaymentStore
+	rates    store.ExchangeRateStore
+	quiz     store.QuizStore
+	keys     auth.KeyStore
+	audit    store.AuditStore
+
+	providers    providers.Registry
+	refundPolicy store.RefundPolicy
+}
+
+// Options configures the behaviour of the handlers.
+type Options struct {
+	// Providers are the payment providers webhooks are accepted from.
+	Providers providers.Registry
+	// RefundPolicy applies when a refund or cancellation takes back more
+	// than the user's balance. It defaults to store.DefaultRefundPolicy.
+	RefundPolicy store.RefundPolicy
+}
+
+func New(stores Stores, options Options) *Handler {
+	if options.RefundPolicy == "" {
+		options.RefundPolicy = store.DefaultRefundPolicy
+	}
+	return &Handler{
+		users:        stores.Users,
+		nodes:        stores.Nodes,
+		payments:     stores.Payments,
+		rates:        stores.Rates,
+		quiz:         stores.Quiz,
+		keys:         stores.Keys,
+		audit:        stores.Audit,
+		providers:    options.Providers,
+		refundPolicy: options.RefundPolicy,
+	}
+}
+
+// writeJSONResponse writes response with the request id the access log set
+// on w. Server errors are logged with it as well.
 func writeJSONResponse(w http.ResponseWriter, statusCode int, response APIResponse) {
+	response.RequestID = w.Header().Get(requestlog.Header)
+	if statusCode >= http.StatusInternalServerError {
+		slog.Error("request failed",
+			"request_id", response.RequestID,
+			"status", statusCode,
+			"error", response.Error,
+		)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 	json.NewEncoder(w).Encode(response)
 }
+
+// idParam returns the "id" query parameter, falling back to the {id} path
+// value, as an integer. It returns 0 when neither is set.
+func idParam(r *http.Request) (int, error) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		id = r.PathValue("id")
+	}
+	if id == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(id)
+}
+
+// intQuery parses an optional integer query parameter, returning 0 when it is
+// not set.
+func intQuery(r *http.Request, name string) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// pageParam reads the limit, sort, order and cursor query parameters of a
+// list endpoint. sortable is the resource's sort whitelist.
+func pageParam(r *http.Request, sortable []string) (store.Page, error) {
+	limit, err := intQuery(r, "limit")
+	if err != nil {
+		return store.Page{}, errors.New("invalid limit")
+	}
+
+	query := r.URL.Query()
+	return store.NewPage(limit, query.Get("sort"), query.Get("order"), query.Get("cursor"), sortable)
+}
+
+func writeBadPage(w http.ResponseWriter, err error) {
+	writeBadRequest(w, "Invalid pagination: "+err.Error())
+}
+
+// writeServerError answers 500 with message, which writeJSONResponse also
+// logs.
+func writeServerError(w http.ResponseWriter, message string) {
+	writeJSONResponse(w, http.StatusInternalServerError, APIResponse{
+		Success: false,
+		Error:   message,
+	})
+}
+
+// NotFound answers 404 for a path that names nothing the route serves.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	writeJSONResponse(w, http.StatusNotFound, APIResponse{
+		Success: false,
+		Error:   "Not found",
+	})
+}
+
+// writeBadRequest answers 400 with message.
+func writeBadRequest(w http.ResponseWriter, message string) {
+	writeJSONResponse(w, http.StatusBadRequest, APIResponse{
+		Success: false,
+		Error:   message,
+	})
+}
+
+func writeBadParam(w http.ResponseWriter, name string) {
+	writeBadRequest(w, "Invalid "+name)
+}
+
+// valueOf dereferences an optional input field, returning nil when it is not
+// set.
+func valueOf[T any](p *T) interface{} {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"hvmnd/api/currency"
+	"hvmnd/api/models"
+	"hvmnd/api/money"
+	"net/http"
+	"time"
+)
+
+func (h *Handler) GetExchangeRates(w http.ResponseWriter, r *http.Request) {
+	code := currency.Normalize(r.URL.Query().Get("currency"))
+	if code != "" && !currency.Valid(code) {
+		writeBadParam(w, "currency")
+		return
+	}
+
+	rates, err := h.rates.ListExchangeRates(r.Context(), code)
+	if err != nil {
+		writeJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Failed to fetch exchange rates: " + err.Error(),
+		})
+		return
+	}
+
+	if rates == nil {
+		rates = []models.ExchangeRate{}
+	}
+
+	writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Found %d exchange rates to %s", len(rates), currency.Base()),
+		Data:    rates,
+	})
+}
+
+func (h *Handler) SetExchangeRate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Currency      string     `json:"currency"`
+		Rate          money.Rate `json:"rate"`
+		EffectiveFrom *time.Time `json:"effective_from"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	req.Currency = currency.Normalize(req.Currency)
+	if !currency.Valid(req.Currency) {
+		writeBadParam(w, "currency")
+		return
+	}
+	if req.Currency == currency.Base() {
+		writeBadRequest(w, fmt.Sprintf("%s is the base currency and always has a rate of 1", req.Currency))
+		return
+	}
+	if req.Rate <= 0 {
+		writeBadRequest(w, "Rate must be greater than 0")
+		return
+	}
+
+	// A rate without an effective time applies from now on
+	now := time.Now()
+	effectiveFrom := now
+	if req.EffectiveFrom != nil {
+		effectiveFrom = *req.EffectiveFrom
+	}
+
+	rate, err := h.rates.SetExchangeRate(r.Context(), models.ExchangeRate{
+		Currency:      req.Currency,
+		Rate:          req.Rate,
+		EffectiveFrom: effectiveFrom,
+		CreatedAt:     now,
+	})
+	if err != nil {
+		writeJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Failed to set exchange rate: " + err.Error(),
+		})
+		return
+	}
+
+	writeJSONResponse(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "Exchange rate set successfully",
+		Data:    rate,
+	})
+}
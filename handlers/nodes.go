@@ -2,250 +2,569 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"strings"
-	"hvmnd/api/db"
+	"hvmnd/api/auth"
 	"hvmnd/api/models"
+	"hvmnd/api/money"
+	"hvmnd/api/secrets"
+	"hvmnd/api/store"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func GetNodes(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	if id == "" {
-		id = r.PathValue("id")
+func (h *Handler) GetNodes(w http.ResponseWriter, r *http.Request) {
+	var filter store.NodeFilter
+	var err error
+
+	if filter.ID, err = idParam(r); err != nil {
+		writeBadParam(w, "id")
+		return
 	}
 
 	renter := r.URL.Query().Get("renter")
-	status := r.URL.Query().Get("status")
-	anyDeskAddress := r.URL.Query().Get("any_desk_address")
-	software := r.URL.Query().Get("software")
-
-	query := `
-		SELECT 
-		id, old_id, any_desk_address, 
-		any_desk_password, status, software, 
-		price, renter, rent_start_time, 
-		last_balance_update_timestamp, 
-		cpu, gpu, other_specs, licenses, 
-		machine_id FROM nodes WHERE 1=1
-	`
-	var args []interface{}
-	argIndex := 1
-
-	if id != "" {
-		query += fmt.Sprintf(" AND id = $%d", argIndex)
-		args = append(args, id)
-		argIndex++
-	}
-
-	if renter != "" {
-		if renter == "non_null" {
-			query += " AND renter IS NOT NULL"
-		} else {
-			query += fmt.Sprintf(" AND renter = $%d", argIndex)
-			args = append(args, renter)
-			argIndex++
+	if renter == "non_null" {
+		filter.RenterNotNull = true
+	} else if filter.Renter, err = intQuery(r, "renter"); err != nil {
+		writeBadParam(w, "renter")
+		return
+	}
+
+	filter.Status = models.NodeStatus(r.URL.Query().Get("status"))
+	if filter.Status != "" && !filter.Status.Valid() {
+		writeBadStatus(w)
+		return
+	}
+	filter.AnyDeskAddress = r.URL.Query().Get("any_desk_address")
+	filter.GPUModel = r.URL.Query().Get("gpu_model")
+
+	// software may be repeated or comma separated; a node must have them all
+	for _, value := range r.URL.Query()["software"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				filter.Software = append(filter.Software, name)
+			}
 		}
 	}
 
-	if status != "" {
-		query += fmt.Sprintf(" AND status = $%d", argIndex)
-		args = append(args, status)
-		argIndex++
+	minimums := []struct {
+		name  string
+		value *int
+	}{
+		{"min_vram", &filter.MinVRAMGB},
+		{"min_cpu_cores", &filter.MinCPUCores},
+		{"min_ram", &filter.MinRAMGB},
+		{"min_storage", &filter.MinStorageGB},
+	}
+	for _, minimum := range minimums {
+		if *minimum.value, err = intQuery(r, minimum.name); err != nil || *minimum.value < 0 {
+			writeBadParam(w, minimum.name)
+			return
+		}
 	}
 
-	if anyDeskAddress != "" {
-		query += fmt.Sprintf(" AND any_desk_address = $%d", argIndex)
-		args = append(args, anyDeskAddress)
-		argIndex++
+	if maxPrice := r.URL.Query().Get("max_price"); maxPrice != "" {
+		if filter.MaxPrice, err = money.Parse(maxPrice); err != nil || filter.MaxPrice <= 0 {
+			writeBadParam(w, "max_price")
+			return
+		}
 	}
 
-	if software != "" {
-		// Use two placeholders: one for software and one for licenses
-		query += fmt.Sprintf(" AND (software ILIKE $%d OR licenses ILIKE $%d)", argIndex, argIndex+1)
-		args = append(args, "%"+software+"%", "%"+software+"%")
+	page, err := pageParam(r, store.NodeSortColumns)
+	if err != nil {
+		writeBadPage(w, err)
+		return
+	}
 
-		// Increment argIndex by 2 because we've used two placeholders
-		argIndex += 2
+	nodes, info, err := h.nodes.ListNodes(r.Context(), filter, page)
+	if err != nil {
+		writeServerError(w, err.Error())
+		return
 	}
 
-	rows, err := db.PostgresEngine.Query(query, args...)
+	if nodes == nil {
+		writeJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Error:   "No nodes found matching the criteria",
+		})
+		return
+	}
 
+	writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success:    true,
+		Message:    fmt.Sprintf("Found %d nodes", len(nodes)),
+		Data:       nodes,
+		NextCursor: info.NextCursor,
+		Total:      &info.Total,
+	})
+}
+
+func (h *Handler) CreateNode(w http.ResponseWriter, r *http.Request) {
+	var input models.NodeInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	// The id is assigned by the database and rental state by the rent
+	// endpoint
+	if input.ID != nil || input.Renter != nil || input.RentStartTime != nil || input.LastBalanceUpdateTimestamp != nil {
+		writeBadRequest(w, "id, renter, rent_start_time and last_balance_update_timestamp cannot be set on a new node")
+		return
+	}
+
+	var problem string
+	switch {
+	case input.AnyDeskAddress == nil || strings.TrimSpace(*input.AnyDeskAddress) == "":
+		problem = "any_desk_address is required"
+	case input.AnyDeskPassword == nil || *input.AnyDeskPassword == "":
+		problem = "any_desk_password is required"
+	case input.Price == nil || *input.Price <= 0:
+		problem = "price must be greater than zero"
+	case input.MachineID == nil || strings.TrimSpace(*input.MachineID) == "":
+		problem = "machine_id is required"
+	case input.Status != nil && !input.Status.Valid():
+		problem = "status must be one of " + nodeStatusList()
+	case specProblem(input) != "":
+		problem = specProblem(input)
+	case input.Status != nil && (*input.Status == models.NodeStatusRented || *input.Status == models.NodeStatusDecommissioned):
+		problem = "a new node cannot be created as " + string(*input.Status)
+	}
+	if problem != "" {
+		writeBadRequest(w, problem)
+		return
+	}
+
+	address := strings.TrimSpace(*input.AnyDeskAddress)
+	machineID := strings.TrimSpace(*input.MachineID)
+	input.AnyDeskAddress = &address
+	input.MachineID = &machineID
+
+	encrypted, err := secrets.Encrypt(*input.AnyDeskPassword)
+	if err != nil {
+		writeServerError(w, err.Error())
+		return
+	}
+	input.AnyDeskPassword = &encrypted
+
+	node, err := h.nodes.CreateNode(r.Context(), input)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	defer rows.Close()
-
-	var nodes []models.Node
-	for rows.Next() {
-		var node models.Node
-		err := rows.Scan(
-			&node.ID,
-			&node.OldID,
-			&node.AnyDeskAddress,
-			&node.AnyDeskPassword,
-			&node.Status,
-			&node.Software,
-			&node.Price,
-			&node.Renter,
-			&node.RentStartTime,
-			&node.LastBalanceUpdateTimestamp,
-			&node.CPU,
-			&node.GPU,
-			&node.OtherSpecs,
-			&node.Licenses,
-			&node.MachineID,
-		)
-
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err == store.ErrDuplicateAnyDeskAddress || err == store.ErrDuplicateMachineID {
+			writeJSONResponse(w, http.StatusConflict, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
 			return
 		}
+		writeServerError(w, err.Error())
+		return
+	}
 
-		nodes = append(nodes, node)
+	writeJSONResponse(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "Node registered successfully",
+		Data:    node,
+	})
+}
+
+func (h *Handler) DecommissionNode(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeBadParam(w, "node id")
+		return
 	}
 
-	if nodes == nil {
+	err = h.nodes.DecommissionNode(r.Context(), id)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			writeJSONResponse(w, http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   "Node not found",
+			})
+		case store.ErrNodeRented:
+			writeJSONResponse(w, http.StatusConflict, APIResponse{
+				Success: false,
+				Error:   "Node is rented; release it before decommissioning",
+			})
+		default:
+			if !writeTransitionError(w, err) {
+				writeServerError(w, err.Error())
+			}
+		}
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Node decommissioned successfully",
+	})
+}
+
+func (h *Handler) GetNodeStatusHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeBadParam(w, "node id")
+		return
+	}
+
+	changes, err := h.nodes.NodeStatusHistory(r.Context(), id)
+	if err != nil {
+		if err == store.ErrNotFound {
+			writeJSONResponse(w, http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   "Node not found",
+			})
+			return
+		}
+		writeServerError(w, err.Error())
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Found %d status changes", len(changes)),
+		Data:    changes,
+	})
+}
+
+func (h *Handler) UpdateNode(w http.ResponseWriter, r *http.Request) {
+	// Read the raw body first
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	// Decode into a map to check which fields are present and if they are null
+	var inputMap map[string]interface{}
+	if err := json.Unmarshal(body, &inputMap); err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	// Decode into the node struct
+	var node models.NodeInput
+	if err := json.Unmarshal(body, &node); err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	// Ensure that at least one identifier is provided
+	if node.AnyDeskAddress == nil && node.OldID == nil && node.ID == nil {
+		writeBadRequest(w, "At least one of any_desk_address, old_id, or id must be provided")
+		return
+	}
+
+	// Rental state is owned by the rent/release endpoints so that nodes are
+	// assigned under a row lock; it cannot be written here.
+	for _, field := range []string{"renter", "rent_start_time", "last_balance_update_timestamp"} {
+		if _, present := inputMap[field]; present {
+			writeBadRequest(w, field+" can only be changed through /api/v1/nodes/{id}/rent and /api/v1/nodes/{id}/release")
+			return
+		}
+	}
+	if status, present := inputMap["status"]; present && status == nil {
+		writeBadRequest(w, "status cannot be null")
+		return
+	}
+	if node.Status != nil && !node.Status.Valid() {
+		writeBadStatus(w)
+		return
+	}
+	if node.Status != nil && *node.Status == models.NodeStatusRented {
+		writeBadRequest(w, "Use /api/v1/nodes/{id}/rent to rent a node")
+		return
+	}
+	if problem := specProblem(node); problem != "" {
+		writeBadRequest(w, problem)
+		return
+	}
+	if node.Status != nil && *node.Status == models.NodeStatusDecommissioned {
+		writeBadRequest(w, "Use DELETE /api/v1/nodes/{id} to decommission a node")
+		return
+	}
+
+	changes := map[string]interface{}{}
+
+	// setField records a column change if the field is present in the body.
+	// An explicit null clears the column; absent fields are left untouched.
+	setField := func(fieldName string, fieldValue interface{}) {
+		val, present := inputMap[fieldName]
+		if !present {
+			return
+		}
+		if val == nil {
+			changes[fieldName] = nil
+		} else {
+			changes[fieldName] = fieldValue
+		}
+	}
+
+	setField("status", valueOf(node.Status))
+	setField("software", valueOf(node.Software))
+	setField("price", valueOf(node.Price))
+	setField("cpu", valueOf(node.CPU))
+	setField("gpu", valueOf(node.GPU))
+	setField("other_specs", valueOf(node.OtherSpecs))
+	setField("licenses", valueOf(node.Licenses))
+	setField("machine_id", valueOf(node.MachineID))
+	setField("any_desk_address", valueOf(node.AnyDeskAddress))
+	setField("gpu_model", valueOf(node.GPUModel))
+	setField("vram_gb", valueOf(node.VRAMGB))
+	setField("cpu_cores", valueOf(node.CPUCores))
+	setField("ram_gb", valueOf(node.RAMGB))
+	setField("storage_gb", valueOf(node.StorageGB))
+	setField("software_catalog", valueOf(node.SoftwareCatalog))
+	if node.OldID != nil {
+		setField("old_id", int(*node.OldID))
+	} else {
+		setField("old_id", nil)
+	}
+
+	if len(changes) == 0 {
 		writeJSONResponse(w, http.StatusNotFound, APIResponse{
 			Success: false,
-			Error:   "No nodes found matching the criteria",
+			Error:   "No updatable fields provided",
+		})
+		return
+	}
+
+	// Identify the node by the unique key provided
+	var key store.NodeKey
+	if node.ID != nil {
+		id := int(*node.ID)
+		key.ID = &id
+	} else if node.OldID != nil {
+		oldID := int(*node.OldID)
+		key.OldID = &oldID
+	} else {
+		key.AnyDeskAddress = node.AnyDeskAddress
+	}
+
+	err = h.nodes.UpdateNode(r.Context(), key, changes)
+	if err != nil {
+		if err == store.ErrNotFound {
+			writeJSONResponse(w, http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   "Node not found or no changes applied",
+			})
+			return
+		}
+		if err == store.ErrDuplicateAnyDeskAddress || err == store.ErrDuplicateMachineID {
+			writeJSONResponse(w, http.StatusConflict, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return
+		}
+		if writeTransitionError(w, err) {
+			return
+		}
+		writeServerError(w, err.Error())
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Node updated successfully",
+	})
+}
+
+func (h *Handler) GetNodeCredentials(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeBadParam(w, "node id")
+		return
+	}
+
+	// The renter is the authenticated Telegram user, never a user id the
+	// caller names
+	isAdmin := auth.IsAdmin(r)
+	var userID int
+	if !isAdmin {
+		var ok bool
+		if userID, ok = h.ownUserID(w, r, 0); !ok {
+			return
+		}
+	}
+
+	credentials, renter, err := h.nodes.NodeCredentials(r.Context(), id)
+	if err != nil {
+		if err == store.ErrNotFound {
+			writeJSONResponse(w, http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   "Node not found",
+			})
+			return
+		}
+		writeServerError(w, err.Error())
+		return
+	}
+
+	// Only the current renter or an admin may see the credentials
+	if !isAdmin && (renter == nil || userID == 0 || *renter != userID) {
+		writeJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Error:   "Credentials are only available to the current renter",
 		})
 		return
 	}
 
+	credentials.AnyDeskPassword, err = secrets.Decrypt(credentials.AnyDeskPassword)
+	if err != nil {
+		writeServerError(w, err.Error())
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    credentials,
+	})
+}
+
+func (h *Handler) NodeHeartbeat(w http.ResponseWriter, r *http.Request) {
+	var heartbeat models.Heartbeat
+	if err := json.NewDecoder(r.Body).Decode(&heartbeat); err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	var problem string
+	switch {
+	case heartbeat.MachineID == "":
+		problem = "machine_id is required"
+	case heartbeat.AnyDeskAddress == "":
+		problem = "any_desk_address is required"
+	case heartbeat.UptimeSeconds < 0:
+		problem = "uptime_seconds must not be negative"
+	case !validPercent(heartbeat.CPUPercent) || !validPercent(heartbeat.MemoryPercent) || !validPercent(heartbeat.GPUPercent):
+		problem = "load metrics must be between 0 and 100"
+	}
+	if problem != "" {
+		writeBadRequest(w, problem)
+		return
+	}
+
+	// Node-agent keys are bound to one machine, so an agent cannot take over
+	// another node's AnyDesk address
+	if principal, ok := auth.FromContext(r.Context()); ok && principal.Role == auth.RoleNodeAgent && principal.MachineID != heartbeat.MachineID {
+		writeJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Error:   "This API key may only send heartbeats for its own machine",
+		})
+		return
+	}
+
+	// Liveness is judged by the server clock, not the agent's
+	heartbeat.ReceivedAt = time.Now()
+
+	node, err := h.nodes.RecordHeartbeat(r.Context(), heartbeat)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			writeJSONResponse(w, http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   "No node in service has this machine_id",
+			})
+		case store.ErrDuplicateAnyDeskAddress:
+			writeJSONResponse(w, http.StatusConflict, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		default:
+			writeServerError(w, err.Error())
+		}
+		return
+	}
+
 	writeJSONResponse(w, http.StatusOK, APIResponse{
 		Success: true,
-		Message: fmt.Sprintf("Found %d nodes", len(nodes)),
-		Data:    nodes,
+		Message: "Heartbeat recorded",
+		Data: map[string]interface{}{
+			"node_id": node.ID,
+			"status":  node.Status,
+		},
 	})
 }
 
-func UpdateNode(w http.ResponseWriter, r *http.Request) {
-    // Read the raw body first
-    body, err := ioutil.ReadAll(r.Body)
-    if err != nil {
-        http.Error(w, err.Error(), http.StatusBadRequest)
-        return
-    }
-
-    // Decode into a map to check which fields are present and if they are null
-    var inputMap map[string]interface{}
-    if err := json.Unmarshal(body, &inputMap); err != nil {
-        http.Error(w, err.Error(), http.StatusBadRequest)
-        return
-    }
-
-    // Decode into the node struct
-    var node models.NodeInput
-    if err := json.Unmarshal(body, &node); err != nil {
-        http.Error(w, err.Error(), http.StatusBadRequest)
-        return
-    }
-
-    // Ensure that at least one identifier is provided
-    if node.AnyDeskAddress == nil && node.OldID == nil && node.ID == nil {
-        http.Error(w, "At least one of any_desk_address, old_id, or id must be provided", http.StatusBadRequest)
-        return
-    }
-
-    // Start building the UPDATE query dynamically
-    query := "UPDATE nodes SET "
-    sets := []string{}
-    args := []interface{}{}
-    argIndex := 1
-
-    // Helper function to handle fields
-    setField := func(fieldName string, fieldValue interface{}, inputVal interface{}) {
-        // Check presence in inputMap:
-        val, present := inputMap[fieldName]
-        if !present {
-            // Field not provided at all, do not update this column
-            return
-        }
-
-        // Field is provided
-        if val == nil {
-            // Explicitly set to NULL
-            sets = append(sets, fmt.Sprintf("%s = NULL", fieldName))
-        } else {
-            // Set to given value (non-null)
-            sets = append(sets, fmt.Sprintf("%s = $%d", fieldName, argIndex))
-            args = append(args, fieldValue)
-            argIndex++
-        }
-    }
-
-    // Call setField for each updatable column
-    // Here we rely on node.* fields and their presence in inputMap.
-    // If a field is a pointer and node.* is nil, that means user passed null.
-    // If the field is absent from inputMap, we don't update that field at all.
-
-    setField("status", node.Status, inputMap["status"])
-    setField("software", node.Software, inputMap["software"])
-    setField("price", node.Price, inputMap["price"])
-    setField("renter", node.Renter, inputMap["renter"])
-    setField("rent_start_time", node.RentStartTime, inputMap["rent_start_time"])
-    setField("last_balance_update_timestamp", node.LastBalanceUpdateTimestamp, inputMap["last_balance_update_timestamp"])
-    setField("cpu", node.CPU, inputMap["cpu"])
-    setField("gpu", node.GPU, inputMap["gpu"])
-    setField("other_specs", node.OtherSpecs, inputMap["other_specs"])
-    setField("licenses", node.Licenses, inputMap["licenses"])
-    setField("machine_id", node.MachineID, inputMap["machine_id"])
-    setField("old_id", node.OldID, inputMap["old_id"])
-    setField("any_desk_address", node.AnyDeskAddress, inputMap["any_desk_address"])
-
-	if len(sets) == 0 {
-        writeJSONResponse(w, http.StatusNotFound, APIResponse{
-            Success: false,
-            Error:   "No updatable fields provided",
-        })
-        return
-    }
-
-    query += strings.Join(sets, ", ") + " WHERE "
-
-    // Dynamically add the WHERE clause based on the unique key provided
-    if node.ID != nil {
-        query += fmt.Sprintf("id = $%d", argIndex)
-        args = append(args, *node.ID)
-    } else if node.OldID != nil {
-        query += fmt.Sprintf("old_id = $%d", argIndex)
-        args = append(args, *node.OldID)
-    } else if node.AnyDeskAddress != nil {
-        query += fmt.Sprintf("any_desk_address = $%d", argIndex)
-        args = append(args, *node.AnyDeskAddress)
-    }
-    // Now argIndex not incremented here because we only added one condition.
-
-    // Execute the query
-    result, err := db.PostgresEngine.Exec(query, args...)
-    if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
-        return
-    }
-
-    // Check how many rows were affected
-    rowsAffected, err := result.RowsAffected()
-    if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
-        return
-    }
-
-    // If no rows were affected, return 404 Not Found
-    if rowsAffected == 0 {
-        writeJSONResponse(w, http.StatusNotFound, APIResponse{
-            Success: false,
-            Error:   "Node not found or no changes applied",
-        })
-        return
-    }
-
-    writeJSONResponse(w, http.StatusOK, APIResponse{
-        Success: true,
-        Message: "Node updated successfully",
-    })
+func validPercent(value *float64) bool {
+	return value == nil || (*value >= 0 && *value <= 100)
+}
+
+func nodeStatusList() string {
+	names := make([]string, len(models.NodeStatuses))
+	for i, status := range models.NodeStatuses {
+		names[i] = string(status)
+	}
+	return strings.Join(names, ", ")
 }
 
+func writeBadStatus(w http.ResponseWriter) {
+	writeBadRequest(w, "status must be one of "+nodeStatusList())
+}
+
+// writeTransitionError reports a rejected status change together with the
+// statuses the node may move to. It returns false if err is not a
+// *models.TransitionError.
+func writeTransitionError(w http.ResponseWriter, err error) bool {
+	var transitionErr *models.TransitionError
+	if !errors.As(err, &transitionErr) {
+		return false
+	}
+
+	writeJSONResponse(w, http.StatusConflict, APIResponse{
+		Success: false,
+		Error:   transitionErr.Error(),
+		Data:    transitionErr,
+	})
+	return true
+}
+
+// specProblem validates the structured specs and software catalog of a node
+// input, returning a message for the first invalid field.
+func specProblem(input models.NodeInput) string {
+	specs := []struct {
+		name  string
+		value *int32
+	}{
+		{"vram_gb", input.VRAMGB},
+		{"cpu_cores", input.CPUCores},
+		{"ram_gb", input.RAMGB},
+		{"storage_gb", input.StorageGB},
+	}
+	for _, spec := range specs {
+		if spec.value != nil && *spec.value < 0 {
+			return spec.name + " must not be negative"
+		}
+	}
+
+	if input.SoftwareCatalog != nil {
+		for _, item := range *input.SoftwareCatalog {
+			if strings.TrimSpace(item.Name) == "" {
+				return "software_catalog entries need a name"
+			}
+			if item.Kind != models.SoftwareKindSoftware && item.Kind != models.SoftwareKindLicense {
+				return "software_catalog kind must be software or license"
+			}
+		}
+	}
+
+	return ""
+}
+
+// nodeFilter selects the node identified by key.
+func nodeFilter(key store.NodeKey) store.NodeFilter {
+	switch {
+	case key.ID != nil:
+		return store.NodeFilter{ID: *key.ID}
+	case key.OldID != nil:
+		return store.NodeFilter{OldID: *key.OldID}
+	case key.AnyDeskAddress != nil:
+		return store.NodeFilter{AnyDeskAddress: *key.AnyDeskAddress}
+	}
+	return store.NodeFilter{}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"hvmnd/api/auth"
+	"hvmnd/api/store"
+	"net/http"
+)
+
+// Telegram users authenticate as themselves rather than with an API key, so
+// the handlers they can reach limit them to their own records.
+
+func writeNotOwner(w http.ResponseWriter) {
+	writeJSONResponse(w, http.StatusForbidden, APIResponse{
+		Success: false,
+		Error:   "Telegram users can only access their own records",
+	})
+}
+
+// ownTelegramID returns the telegram id a request may act on. API key callers
+// may act on any requested id; Telegram users only on their own, which is
+// also the default when requested is 0. ok is false once a response has been
+// written.
+func ownTelegramID(w http.ResponseWriter, r *http.Request, requested int) (telegramID int, ok bool) {
+	own, scoped := auth.TelegramIDFrom(r)
+	if !scoped {
+		return requested, true
+	}
+	if requested != 0 && requested != own {
+		writeNotOwner(w)
+		return 0, false
+	}
+	return own, true
+}
+
+// ownUserID is ownTelegramID for user ids. A Telegram user has to be
+// registered with POST /users before acting on anything keyed by user id.
+func (h *Handler) ownUserID(w http.ResponseWriter, r *http.Request, requested int) (userID int, ok bool) {
+	telegramID, scoped := auth.TelegramIDFrom(r)
+	if !scoped {
+		return requested, true
+	}
+
+	users, _, err := h.users.ListUsers(r.Context(), store.UserFilter{TelegramID: telegramID}, store.Page{Limit: 1, Sort: "id"})
+	if err != nil {
+		writeServerError(w, err.Error())
+		return 0, false
+	}
+	if len(users) == 0 {
+		writeJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Error:   "User not found",
+		})
+		return 0, false
+	}
+
+	if requested != 0 && requested != users[0].ID {
+		writeNotOwner(w)
+		return 0, false
+	}
+	return users[0].ID, true
+}
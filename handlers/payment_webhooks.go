@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hvmnd/api/models"
+	"hvmnd/api/providers"
+	"hvmnd/api/requestlog"
+	"hvmnd/api/store"
+	"io"
+	"net/http"
+	"time"
+)
+
+// maxWebhookBody bounds the webhook payloads the API accepts.
+const maxWebhookBody = 1 << 20
+
+// Results recorded for processed payment events.
+const (
+	eventCompleted        = "completed"
+	eventCompletedExpired = "completed_expired"
+	eventCancelled        = "cancelled"
+	eventIgnored          = "ignored"
+	eventAlreadyPaid      = "already_paid"
+	eventAlreadyCancelled = "already_cancelled"
+	eventPaymentNotFound  = "payment_not_found"
+	eventAmountMismatch   = "amount_mismatch"
+	eventCurrencyMismatch = "currency_mismatch"
+	eventStatusConflict   = "status_conflict"
+)
+
+// PaymentWebhook ingests a signed event from a payment provider and completes
+// or cancels the payment ticket it refers to. Every verified event is stored
+// with its raw payload; a redelivered event is acknowledged without being
+// applied again.
+func (h *Handler) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("provider")
+	provider, ok := h.providers[name]
+	if !ok {
+		writeJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Error:   "Unknown payment provider",
+		})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		writeBadRequest(w, "Failed to read webhook payload")
+		return
+	}
+
+	event, err := provider.ParseWebhook(r.Header, body)
+	if err != nil {
+		if errors.Is(err, providers.ErrInvalidSignature) {
+			writeJSONResponse(w, http.StatusUnauthorized, APIResponse{
+				Success: false,
+				Error:   "Invalid webhook signature",
+			})
+			return
+		}
+		writeBadRequest(w, "Invalid webhook payload")
+		return
+	}
+
+	ctx := store.WithActor(r.Context(), "payment-provider:"+name)
+
+	stored, _, err := h.payments.RecordPaymentEvent(ctx, models.PaymentEvent{
+		Provider:   name,
+		EventID:    event.ID,
+		EventType:  event.Type,
+		PaymentID:  event.PaymentID,
+		Amount:     event.Amount,
+		Payload:    body,
+		ReceivedAt: time.Now(),
+	})
+	if err != nil {
+		writeJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Failed to store webhook event: " + err.Error(),
+		})
+		return
+	}
+
+	// A redelivery of an event that was processed is only acknowledged;
+	// one whose processing failed is applied again.
+	if stored.ProcessedAt.Valid {
+		writeJSONResponse(w, http.StatusOK, APIResponse{
+			Success: true,
+			Message: "Event already processed",
+			Data:    webhookData(stored.PaymentID, event.ID, stored.Result.String),
+		})
+		return
+	}
+
+	result, statusCode, err := h.applyPaymentEvent(ctx, event)
+	if err != nil {
+		writeJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Failed to process webhook event: " + err.Error(),
+		})
+		return
+	}
+
+	if err := h.payments.FinishPaymentEvent(ctx, stored.ID, result, time.Now()); err != nil {
+		requestlog.Logger(ctx).Error("failed to record result of payment event", "event_id", stored.ID, "error", err)
+	}
+
+	if statusCode != http.StatusOK {
+		writeJSONResponse(w, statusCode, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Event not applied: %s", result),
+			Data:    webhookData(event.PaymentID, event.ID, result),
+		})
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Event processed",
+		Data:    webhookData(event.PaymentID, event.ID, result),
+	})
+}
+
+// applyPaymentEvent applies event to its payment ticket with the semantics of
+// CompletePayment and CancelUnpaidPayment. It returns the result to record
+// and the status code to answer with; err is set only for failures that are
+// worth a redelivery.
+func (h *Handler) applyPaymentEvent(ctx context.Context, event providers.Event) (string, int, error) {
+	if event.Action == providers.ActionIgnore {
+		return eventIgnored, http.StatusOK, nil
+	}
+
+	payments, _, err := h.payments.ListPayments(ctx, store.PaymentFilter{ID: event.PaymentID}, store.Page{Limit: 1, Sort: "id"})
+	if err != nil {
+		return "", 0, err
+	}
+	if len(payments) == 0 {
+		return eventPaymentNotFound, http.StatusNotFound, nil
+	}
+	payment := payments[0]
+
+	switch event.Action {
+	case providers.ActionComplete:
+		if event.Currency != "" && event.Currency != payment.Currency {
+			return eventCurrencyMismatch, http.StatusUnprocessableEntity, nil
+		}
+		if event.Amount != 0 && event.Amount != payment.Amount {
+			return eventAmountMismatch, http.StatusUnprocessableEntity, nil
+		}
+
+		// The provider has captured the money, so a ticket that expired while
+		// the customer was paying is credited all the same
+		previousStatus, err := h.payments.CompletePayment(ctx, payment.ID, true)
+		if err != nil {
+			return "", 0, err
+		}
+		switch previousStatus {
+		case "unpaid":
+			return eventCompleted, http.StatusOK, nil
+		case "expired":
+			return eventCompletedExpired, http.StatusOK, nil
+		case "paid":
+			return eventAlreadyPaid, http.StatusOK, nil
+		}
+		return eventStatusConflict, http.StatusConflict, nil
+
+	case providers.ActionCancel:
+		// A failed or cancelled charge never takes back money that a
+		// completed one brought in, even one completed a moment ago
+		previousStatus, err := h.payments.CancelUnpaidPayment(ctx, payment.ID)
+		if err != nil {
+			return "", 0, err
+		}
+		switch previousStatus {
+		case "unpaid", "expired":
+			return eventCancelled, http.StatusOK, nil
+		case "cancelled":
+			return eventAlreadyCancelled, http.StatusOK, nil
+		}
+		return eventStatusConflict, http.StatusConflict, nil
+	}
+
+	return eventIgnored, http.StatusOK, nil
+}
+
+func webhookData(paymentID int, eventID string, result string) map[string]interface{} {
+	return map[string]interface{}{
+		"payment_ticket_id": paymentID,
+		"event_id":          eventID,
+		"result":            result,
+	}
+}
@@ -1,52 +1,44 @@
 package handlers
 
 import (
-	"database/sql"
 	"encoding/json"
 	"fmt"
-	"hvmnd/api/db"
+	"hvmnd/api/auth"
+	"hvmnd/api/currency"
 	"hvmnd/api/models"
+	"hvmnd/api/money"
+	"hvmnd/api/store"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
-func GetPayments(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	if id == "" {
-		id = r.PathValue("id")
-	}
-	userID := r.URL.Query().Get("user_id")
-	status := r.URL.Query().Get("status")
-	limit := r.URL.Query().Get("limit")
-
-	query := `
-		SELECT id, user_id, amount, status, datetime FROM payments WHERE 1=1
-	`
-	var args []interface{}
-	argIndex := 1
+func (h *Handler) GetPayments(w http.ResponseWriter, r *http.Request) {
+	var filter store.PaymentFilter
+	var err error
 
-	if id != "" {
-		query += fmt.Sprintf(" AND id = $%d", argIndex)
-		args = append(args, id)
-		argIndex++
+	if filter.ID, err = idParam(r); err != nil {
+		writeBadParam(w, "id")
+		return
 	}
-	if userID != "" {
-		query += fmt.Sprintf(" AND user_id = $%d", argIndex)
-		args = append(args, userID)
-		argIndex++
+	if filter.UserID, err = intQuery(r, "user_id"); err != nil {
+		writeBadParam(w, "user_id")
+		return
 	}
-	if status != "" {
-		query += fmt.Sprintf(" AND status = $%d", argIndex)
-		args = append(args, status)
-		argIndex++
+	var ok bool
+	if filter.UserID, ok = h.ownUserID(w, r, filter.UserID); !ok {
+		return
 	}
-	if limit != "" {
-		query += fmt.Sprintf(" LIMIT $%d", argIndex)
-		args = append(args, limit)
-		argIndex++
+	filter.Status = r.URL.Query().Get("status")
+
+	page, err := pageParam(r, store.PaymentSortColumns)
+	if err != nil {
+		writeBadPage(w, err)
+		return
 	}
 
-	rows, err := db.PostgresEngine.Query(query, args...)
+	payments, info, err := h.payments.ListPayments(r.Context(), filter, page)
 	if err != nil {
 		writeJSONResponse(w, http.StatusInternalServerError, APIResponse{
 			Success: false,
@@ -54,24 +46,6 @@ func GetPayments(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
-	defer rows.Close()
-
-	var payments []models.Payment
-	for rows.Next() {
-		var payment models.Payment
-		err := rows.Scan(
-			&payment.ID,
-			&payment.UserID,
-			&payment.Amount,
-			&payment.Status,
-			&payment.Datetime,
-		)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		payments = append(payments, payment)
-	}
 
 	if len(payments) == 0 {
 		writeJSONResponse(w, http.StatusNotFound, APIResponse{
@@ -82,56 +56,74 @@ func GetPayments(w http.ResponseWriter, r *http.Request) {
 	}
 
 	writeJSONResponse(w, http.StatusOK, APIResponse{
-		Success: true,
-		Message: fmt.Sprintf("Found %d payments", len(payments)),
-		Data:    payments,
+		Success:    true,
+		Message:    fmt.Sprintf("Found %d payments", len(payments)),
+		Data:       payments,
+		NextCursor: info.NextCursor,
+		Total:      &info.Total,
 	})
 }
 
-func CreatePaymentTicket(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) CreatePaymentTicket(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		UserID int     `json:"user_id"`
-		Amount float64 `json:"amount"`
+		UserID   int          `json:"user_id"`
+		Amount   money.Amount `json:"amount"`
+		Currency string       `json:"currency"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	var ok bool
+	if req.UserID, ok = h.ownUserID(w, r, req.UserID); !ok {
 		return
 	}
 
 	if req.Amount <= 0 {
-		writeJSONResponse(w, http.StatusBadRequest, APIResponse{
-			Success: false,
-			Error:   "Amount must be greater than 0",
-		})
+		writeBadRequest(w, "Amount must be greater than 0")
 		return
 	}
 
-	// Check if user exists
-	var exists bool
-	err := db.PostgresEngine.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", req.UserID).Scan(&exists)
-	if err != nil {
-		writeJSONResponse(w, http.StatusInternalServerError, APIResponse{
-			Success: false,
-			Error:   "Failed to check user existence: " + err.Error(),
-		})
+	// Tickets are in the base currency unless the client says otherwise
+	req.Currency = currency.Normalize(req.Currency)
+	if req.Currency == "" {
+		req.Currency = currency.Base()
+	}
+	if !currency.Valid(req.Currency) {
+		writeBadParam(w, "currency")
 		return
 	}
 
-	if !exists {
-		writeJSONResponse(w, http.StatusNotFound, APIResponse{
+	now := time.Now()
+	if _, err := h.rates.ExchangeRate(r.Context(), req.Currency, now); err != nil {
+		if err == store.ErrNoExchangeRate {
+			writeJSONResponse(w, http.StatusUnprocessableEntity, APIResponse{
+				Success: false,
+				Error:   fmt.Sprintf("No exchange rate to %s is configured for %s", currency.Base(), req.Currency),
+			})
+			return
+		}
+		writeJSONResponse(w, http.StatusInternalServerError, APIResponse{
 			Success: false,
-			Error:   "User not found",
+			Error:   "Failed to create payment ticket: " + err.Error(),
 		})
 		return
 	}
 
-	query := `
-		INSERT INTO payments (user_id, amount, status, datetime) 
-		VALUES ($1, $2, 'unpaid', $3) RETURNING id
-	`
-	var paymentID int
-	err = db.PostgresEngine.QueryRow(query, req.UserID, req.Amount, time.Now()).Scan(&paymentID)
+	paymentID, err := h.payments.CreatePayment(r.Context(), req.UserID, req.Amount, req.Currency, now)
 	if err != nil {
+		if err == store.ErrNotFound {
+			writeJSONResponse(w, http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   "User not found",
+			})
+			return
+		}
+		if err == store.ErrUserBanned {
+			writeBanned(w)
+			return
+		}
 		writeJSONResponse(w, http.StatusInternalServerError, APIResponse{
 			Success: false,
 			Error:   "Failed to create payment ticket: " + err.Error(),
@@ -148,32 +140,40 @@ func CreatePaymentTicket(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func CompletePayment(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	if id == "" {
-		id = r.PathValue("id")
+func (h *Handler) CompletePayment(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil || id == 0 {
+		writeBadParam(w, "payment id")
+		return
 	}
+	ticketID := strconv.Itoa(id)
 
-	var amount float64
-	var userID int
-	var currentStatus string
-
-	// First, check the current payment status
-	query := `
-		SELECT amount, user_id, status 
-		FROM payments 
-		WHERE id=$1
-	`
-	err := db.PostgresEngine.QueryRow(query, id).Scan(&amount, &userID, &currentStatus)
+	// Only admins may complete a ticket that has expired
+	force := r.URL.Query().Get("force") == "true"
+	if force && !auth.IsAdmin(r) {
+		writeJSONResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Error:   "Only admins can force the completion of a payment",
+		})
+		return
+	}
 
+	previousStatus, err := h.payments.CompletePayment(r.Context(), id, force)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if err == store.ErrNotFound {
 			writeJSONResponse(w, http.StatusNotFound, APIResponse{
 				Success: false,
 				Error:   "Payment not found",
 			})
 			return
 		}
+		if err == store.ErrNoExchangeRate {
+			writeJSONResponse(w, http.StatusConflict, APIResponse{
+				Success: false,
+				Error:   "No exchange rate is in effect for the payment currency",
+			})
+			return
+		}
 		writeJSONResponse(w, http.StatusInternalServerError, APIResponse{
 			Success: false,
 			Error:   err.Error(),
@@ -181,41 +181,32 @@ func CompletePayment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// If the payment is already marked as "paid", return early and do nothing
-	if currentStatus == "paid" {
+	// If the payment is already marked as "paid", nothing was changed
+	if previousStatus == "paid" {
 		writeJSONResponse(w, http.StatusAlreadyReported, APIResponse{
 			Success: true,
 			Message: "Payment already completed",
 			Data: map[string]string{
-				"payment_ticket_id": id,
+				"payment_ticket_id": ticketID,
 				"status":            "paid",
 			},
 		})
 		return
 	}
 
-	// Mark the payment as "paid"
-	query = `
-		UPDATE payments SET
-		status=$1
-		WHERE id=$2
-	`
-	_, err = db.PostgresEngine.Exec(query, "paid", id)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if previousStatus == "expired" && !force {
+		writeJSONResponse(w, http.StatusConflict, APIResponse{
+			Success: false,
+			Error:   "Payment is expired and can only be completed by an admin with force=true",
+		})
 		return
 	}
 
-	// Update the user's balance
-	query = `
-		UPDATE users SET
-		balance = balance + $1
-		WHERE id=$2
-	`
-	_, err = db.PostgresEngine.Exec(query, amount, userID)
-
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if previousStatus != "unpaid" && previousStatus != "expired" {
+		writeJSONResponse(w, http.StatusConflict, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Payment is %s and cannot be completed", previousStatus),
+		})
 		return
 	}
 
@@ -223,97 +214,173 @@ func CompletePayment(w http.ResponseWriter, r *http.Request) {
 		Success: true,
 		Message: "Payment completed successfully",
 		Data: map[string]string{
-			"payment_ticket_id": id,
+			"payment_ticket_id": ticketID,
 		},
 	})
 }
 
-func CancelPayment(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	if id == "" {
-		id = r.PathValue("id")
+func (h *Handler) CancelPayment(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil || id == 0 {
+		writeBadParam(w, "payment id")
+		return
 	}
+	ticketID := strconv.Itoa(id)
 
-	var amount float64
-	var userID int
-	var currentStatus string
-
-	// First, check the current payment status
-	query := `
-		SELECT amount, user_id, status 
-		FROM payments 
-		WHERE id=$1
-	`
-	err := db.PostgresEngine.QueryRow(query, id).Scan(&amount, &userID, &currentStatus)
-
+	previousStatus, err := h.payments.CancelPayment(r.Context(), id, h.refundPolicy)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if err == store.ErrNotFound {
 			writeJSONResponse(w, http.StatusNotFound, APIResponse{
 				Success: false,
 				Error:   "Payment not found",
 			})
 			return
 		}
+		if err == store.ErrInsufficientBalance {
+			writeJSONResponse(w, http.StatusConflict, APIResponse{
+				Success: false,
+				Error:   "User balance does not cover the paid amount; payment not cancelled",
+			})
+			return
+		}
 		writeJSONResponse(w, http.StatusInternalServerError, APIResponse{
 			Success: false,
-			Error:   "Failed to fetch payment: " + err.Error(),
+			Error:   "Failed to cancel payment: " + err.Error(),
 		})
 		return
 	}
 
-	// If the payment is already "cancelled", return early
-	if currentStatus == "cancelled" {
+	if previousStatus == "cancelled" {
 		writeJSONResponse(w, http.StatusOK, APIResponse{
 			Success: true,
 			Message: "Payment already cancelled",
 			Data: map[string]string{
-				"payment_ticket_id": id,
+				"payment_ticket_id": ticketID,
 				"status":            "cancelled",
 			},
 		})
 		return
 	}
 
-	// If the payment was "paid", adjust the user's balance
-	if currentStatus == "paid" {
-		query = `
-			UPDATE users SET
-			balance = balance - $1
-			WHERE id=$2
-		`
-		_, err = db.PostgresEngine.Exec(query, amount, userID)
+	writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Payment cancelled successfully",
+		Data: map[string]string{
+			"payment_ticket_id": ticketID,
+			"status":            "cancelled",
+		},
+	})
+}
+
+func (h *Handler) GetRefunds(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil || id == 0 {
+		writeBadParam(w, "payment id")
+		return
+	}
 
+	// Telegram users only see refunds of their own payments
+	if _, scoped := auth.TelegramIDFrom(r); scoped {
+		userID, ok := h.ownUserID(w, r, 0)
+		if !ok {
+			return
+		}
+		payments, _, err := h.payments.ListPayments(r.Context(), store.PaymentFilter{ID: id, UserID: userID}, store.Page{Limit: 1, Sort: "id"})
 		if err != nil {
 			writeJSONResponse(w, http.StatusInternalServerError, APIResponse{
 				Success: false,
-				Error:   "Failed to update user balance: " + err.Error(),
+				Error:   "Failed to fetch refunds: " + err.Error(),
+			})
+			return
+		}
+		if len(payments) == 0 {
+			writeJSONResponse(w, http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   "Payment not found",
 			})
 			return
 		}
 	}
 
-	// Mark the payment as "cancelled"
-	query = `
-		UPDATE payments SET
-		status=$1
-		WHERE id=$2
-	`
-	_, err = db.PostgresEngine.Exec(query, "cancelled", id)
-
+	refunds, err := h.payments.ListRefunds(r.Context(), id)
 	if err != nil {
+		if err == store.ErrNotFound {
+			writeJSONResponse(w, http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   "Payment not found",
+			})
+			return
+		}
 		writeJSONResponse(w, http.StatusInternalServerError, APIResponse{
 			Success: false,
-			Error:   "Failed to cancel payment: " + err.Error(),
+			Error:   "Failed to fetch refunds: " + err.Error(),
 		})
 		return
 	}
 
+	if refunds == nil {
+		refunds = []models.Refund{}
+	}
+
 	writeJSONResponse(w, http.StatusOK, APIResponse{
 		Success: true,
-		Message: "Payment cancelled successfully",
-		Data: map[string]string{
-			"payment_ticket_id": id,
-			"status":            "cancelled",
-		},
+		Message: fmt.Sprintf("Found %d refunds", len(refunds)),
+		Data:    refunds,
+	})
+}
+
+func (h *Handler) RefundPayment(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil || id == 0 {
+		writeBadParam(w, "payment id")
+		return
+	}
+
+	var req struct {
+		Amount money.Amount `json:"amount"`
+		Reason string       `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Amount <= 0 || req.Reason == "" {
+		writeBadRequest(w, "Amount must be greater than 0 and reason is required")
+		return
+	}
+
+	refund, err := h.payments.RefundPayment(r.Context(), id, req.Amount, req.Reason, h.refundPolicy, time.Now())
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			writeJSONResponse(w, http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   "Payment not found",
+			})
+		case store.ErrPaymentNotRefundable, store.ErrInsufficientBalance:
+			writeJSONResponse(w, http.StatusConflict, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		case store.ErrRefundTooLarge:
+			writeJSONResponse(w, http.StatusUnprocessableEntity, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		default:
+			writeJSONResponse(w, http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error:   "Failed to refund payment: " + err.Error(),
+			})
+		}
+		return
+	}
+
+	writeJSONResponse(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "Payment refunded successfully",
+		Data:    refund,
 	})
 }
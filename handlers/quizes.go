@@ -1,62 +1,56 @@
 package handlers
 
 import (
-	"net/http"
-	"hvmnd/api/db"
 	"encoding/json"
+	"hvmnd/api/store"
 	"hvmnd/api/utils"
+	"net/http"
 )
 
-func SaveHashMapping(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) SaveHashMapping(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Question string `json:"question"`
 		Answer   string `json:"answer"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		http.Error(w, "Invalid input format", http.StatusBadRequest)
+		writeBadRequest(w, "Invalid input format")
 		return
 	}
 
 	// Generate the hash
 	hash := utils.GenerateHash(input.Question, input.Answer)
 
-	query := `
-		INSERT INTO quiz_hash_map (hash, question, answer)
-		VALUES ($1, $2, $3)
-		ON CONFLICT (hash) DO NOTHING;
-	`
-
-	_, err := db.PostgresEngine.Exec(query, hash, input.Question, input.Answer)
+	err := h.quiz.SaveHashMapping(r.Context(), hash, input.Question, input.Answer)
 	if err != nil {
-		http.Error(w, "Failed to save hash mapping: "+err.Error(), http.StatusInternalServerError)
+		writeServerError(w, "Failed to save hash mapping: "+err.Error())
 		return
 	}
 
 	writeJSONResponse(w, http.StatusOK, APIResponse{
 		Success: true,
 		Message: "Hash mapping saved successfully",
-		Data: map[string]string{"hash": hash},
+		Data:    map[string]string{"hash": hash},
 	})
 }
 
-func GetQuestionAnswerByHash(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) GetQuestionAnswerByHash(w http.ResponseWriter, r *http.Request) {
 	hash := r.URL.Query().Get("hash")
 	if hash == "" {
-		http.Error(w, "Missing hash parameter", http.StatusBadRequest)
+		writeBadRequest(w, "Missing hash parameter")
 		return
 	}
 
-	query := `
-		SELECT question, answer
-		FROM quiz_hash_map
-		WHERE hash = $1;
-	`
-
-	var question, answer string
-	err := db.PostgresEngine.QueryRow(query, hash).Scan(&question, &answer)
+	question, answer, err := h.quiz.GetQuestionAnswer(r.Context(), hash)
 	if err != nil {
-		http.Error(w, "Hash not found: "+err.Error(), http.StatusNotFound)
+		if err == store.ErrNotFound {
+			writeJSONResponse(w, http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   "Hash not found",
+			})
+			return
+		}
+		writeServerError(w, err.Error())
 		return
 	}
 
@@ -69,7 +63,7 @@ func GetQuestionAnswerByHash(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func SaveUserAnswer(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) SaveUserAnswer(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		TelegramID int    `json:"telegram_id"`
 		Question   string `json:"question"`
@@ -77,29 +71,31 @@ func SaveUserAnswer(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		http.Error(w, "Invalid input format", http.StatusBadRequest)
+		writeBadRequest(w, "Invalid input format")
+		return
+	}
+
+	var ok bool
+	if input.TelegramID, ok = ownTelegramID(w, r, input.TelegramID); !ok {
 		return
 	}
 
 	// Generate the hash
 	hash := utils.GenerateHash(input.Question, input.Answer)
 
-	query := `
-		INSERT INTO quiz_answers (telegram_id, question, answer, hash)
-		VALUES ($1, $2, $3, $4)
-		ON CONFLICT (telegram_id, question) DO UPDATE
-		SET answer = EXCLUDED.answer, hash = EXCLUDED.hash;
-	`
-
-	_, err := db.PostgresEngine.Exec(query, input.TelegramID, input.Question, input.Answer, hash)
+	err := h.quiz.SaveUserAnswer(r.Context(), input.TelegramID, input.Question, input.Answer, hash)
+	if err == store.ErrUserBanned {
+		writeBanned(w)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Failed to save user answer: "+err.Error(), http.StatusInternalServerError)
+		writeServerError(w, "Failed to save user answer: "+err.Error())
 		return
 	}
 
 	writeJSONResponse(w, http.StatusOK, APIResponse{
 		Success: true,
 		Message: "User answer saved successfully",
-		Data: map[string]string{"hash": hash},
+		Data:    map[string]string{"hash": hash},
 	})
 }
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"hvmnd/api/billing"
+	"hvmnd/api/store"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// rentalErrorStatus maps billing errors to HTTP status codes.
+func rentalErrorStatus(err error) int {
+	switch err {
+	case billing.ErrNodeNotFound, billing.ErrUserNotFound:
+		return http.StatusNotFound
+	case billing.ErrNodeUnavailable, billing.ErrNodeNotRented:
+		return http.StatusConflict
+	case billing.ErrNotRenter, billing.ErrUserBanned:
+		return http.StatusForbidden
+	case billing.ErrInsufficientBalance:
+		return http.StatusPaymentRequired
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (h *Handler) RentNode(w http.ResponseWriter, r *http.Request) {
+	nodeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeBadRequest(w, "Invalid node id")
+		return
+	}
+
+	var req struct {
+		UserID int `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+	var ok bool
+	if req.UserID, ok = h.ownUserID(w, r, req.UserID); !ok {
+		return
+	}
+	if req.UserID == 0 {
+		writeBadRequest(w, "user_id is required")
+		return
+	}
+
+	now := time.Now()
+	rentalID, err := h.nodes.RentNode(r.Context(), nodeID, req.UserID, now)
+	if err != nil {
+		writeJSONResponse(w, rentalErrorStatus(err), APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Node rented successfully",
+		Data: map[string]interface{}{
+			"rental_id":       rentalID,
+			"node_id":         nodeID,
+			"renter":          req.UserID,
+			"rent_start_time": now,
+		},
+	})
+}
+
+func (h *Handler) ReleaseNode(w http.ResponseWriter, r *http.Request) {
+	nodeID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeBadRequest(w, "Invalid node id")
+		return
+	}
+
+	// user_id is optional; when given it must match the current renter
+	var req struct {
+		UserID int `json:"user_id"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeBadRequest(w, err.Error())
+			return
+		}
+	}
+	// Telegram users can only release their own rentals
+	var ok bool
+	if req.UserID, ok = h.ownUserID(w, r, req.UserID); !ok {
+		return
+	}
+
+	// Releasing charges the renter for the last minutes
+
+	charged, err := h.nodes.ReleaseNode(r.Context(), nodeID, req.UserID, time.Now(), billing.EndReasonReleased)
+	if err != nil {
+		writeJSONResponse(w, rentalErrorStatus(err), APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Node released successfully",
+		Data: map[string]interface{}{
+			"node_id":      nodeID,
+			"final_charge": charged,
+		},
+	})
+}
+
+func (h *Handler) GetRentals(w http.ResponseWriter, r *http.Request) {
+	var filter store.RentalFilter
+	var err error
+
+	if filter.UserID, err = intQuery(r, "user_id"); err != nil {
+		writeBadParam(w, "user_id")
+		return
+	}
+	var ok bool
+	if filter.UserID, ok = h.ownUserID(w, r, filter.UserID); !ok {
+		return
+	}
+	if filter.NodeID, err = intQuery(r, "node_id"); err != nil {
+		writeBadParam(w, "node_id")
+		return
+	}
+	if filter.Limit, err = intQuery(r, "limit"); err != nil {
+		writeBadParam(w, "limit")
+		return
+	}
+
+	// from/to select rentals that overlap the given time range
+	if from := r.URL.Query().Get("from"); from != "" {
+		filter.From, err = time.Parse(time.RFC3339, from)
+		if err != nil {
+			writeBadRequest(w, "from must be an RFC 3339 timestamp")
+			return
+		}
+	}
+	if to := r.URL.Query().Get("to"); to != "" {
+		filter.To, err = time.Parse(time.RFC3339, to)
+		if err != nil {
+			writeBadRequest(w, "to must be an RFC 3339 timestamp")
+			return
+		}
+	}
+
+	rentals, err := h.nodes.ListRentals(r.Context(), filter)
+	if err != nil {
+		writeServerError(w, err.Error())
+		return
+	}
+
+	if len(rentals) == 0 {
+		writeJSONResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Error:   "No rentals found matching the criteria",
+		})
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Found %d rentals", len(rentals)),
+		Data:    rentals,
+	})
+}
@@ -3,84 +3,45 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
-	"hvmnd/api/db"
+	"hvmnd/api/auth"
 	"hvmnd/api/models"
+	"hvmnd/api/money"
+	"hvmnd/api/store"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func GetUsers(w http.ResponseWriter, r *http.Request) {
-
-	id := r.URL.Query().Get("id")
-	if id == "" {
-		id = r.PathValue("id")
-	}
-	telegramID := r.URL.Query().Get("telegram_id")
-	username := r.URL.Query().Get("username")
-	limit := r.URL.Query().Get("limit")
-
-	query := `
-		SELECT 
-		id, 
-		telegram_id, 
-		total_spent, 
-		balance, 
-		first_name, 
-		last_name, 
-		username, 
-		language_code,
-		banned
-		FROM users WHERE 1=1
-	`
-	var args []interface{}
-	argIndex := 1
-
-	if telegramID != "" {
-		query += fmt.Sprintf(" AND telegram_id = $%d", argIndex)
-		args = append(args, telegramID)
-		argIndex++
-	}
-	if username != "" {
-		query += fmt.Sprintf(" AND username = $%d", argIndex)
-		args = append(args, username)
-		argIndex++
-	}
-	if id != "" {
-		query += fmt.Sprintf(" AND id = $%d", argIndex)
-		args = append(args, id)
-		argIndex++
-	}
-	if limit != "" {
-		query += fmt.Sprintf(" LIMIT $%d", argIndex)
-		args = append(args, limit)
-		argIndex++
-	}
-
-	rows, err := db.PostgresEngine.Query(query, args...)
+func (h *Handler) GetUsers(w http.ResponseWriter, r *http.Request) {
+	var filter store.UserFilter
+	var err error
+
+	if filter.ID, err = idParam(r); err != nil {
+		writeBadParam(w, "id")
+		return
+	}
+	if filter.TelegramID, err = intQuery(r, "telegram_id"); err != nil {
+		writeBadParam(w, "telegram_id")
+		return
+	}
+	var ok bool
+	if filter.TelegramID, ok = ownTelegramID(w, r, filter.TelegramID); !ok {
+		return
+	}
+	filter.Username = r.URL.Query().Get("username")
+
+	page, err := pageParam(r, store.UserSortColumns)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	var users []models.User
-	for rows.Next() {
-		var user models.User
-		err := rows.Scan(
-			&user.ID,
-			&user.TelegramID,
-			&user.TotalSpent,
-			&user.Balance,
-			&user.FirstName,
-			&user.LastName,
-			&user.Username,
-			&user.LanguageCode,
-			&user.Banned,
-		)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		users = append(users, user)
+		writeBadPage(w, err)
+		return
+	}
+
+	users, info, err := h.users.ListUsers(r.Context(), filter, page)
+	if err != nil {
+		writeServerError(w, err.Error())
+		return
 	}
 
 	if len(users) == 0 {
@@ -92,74 +53,76 @@ func GetUsers(w http.ResponseWriter, r *http.Request) {
 	}
 
 	writeJSONResponse(w, http.StatusOK, APIResponse{
-		Success: true,
-		Message: fmt.Sprintf("Found %d users", len(users)),
-		Data:    users,
+		Success:    true,
+		Message:    fmt.Sprintf("Found %d users", len(users)),
+		Data:       users,
+		NextCursor: info.NextCursor,
+		Total:      &info.Total,
 	})
 }
 
-func CreateOrUpdateUser(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) CreateOrUpdateUser(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	// Decode into a map as well to see which fields are present
+	var inputMap map[string]json.RawMessage
+	if err := json.Unmarshal(body, &inputMap); err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
 	var input models.UserInput
-	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := json.Unmarshal(body, &input); err != nil {
+		writeBadRequest(w, err.Error())
 		return
 	}
 
+	// The account is not part of the profile; it is changed by admins with
+	// a recorded reason
+	for _, field := range []string{"balance", "total_spent"} {
+		if _, present := inputMap[field]; present {
+			writeBadRequest(w, field+" can only be changed through POST /api/v1/users/{id}/adjustments")
+			return
+		}
+	}
+
+	// Bans are set and lifted only by admins, with a reason, through
+	// /api/v1/admin/users/{id}/ban
+	for _, field := range []string{"banned", "ban_reason", "banned_until"} {
+		if _, present := inputMap[field]; present {
+			writeBadRequest(w, field+" can only be changed through /api/v1/admin/users/{id}/ban")
+			return
+		}
+	}
+
+	var ok bool
+	if input.TelegramID, ok = ownTelegramID(w, r, input.TelegramID); !ok {
+		return
+	}
 	if input.TelegramID == 0 {
-		http.Error(w, "telegram_id is required", http.StatusBadRequest)
-		return
-	}
-
-	query := `
-		INSERT INTO public.users (
-			telegram_id, 
-			total_spent, 
-			balance, 
-			first_name, 
-			last_name, 
-			username, 
-			language_code,
-			banned
-		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		ON CONFLICT (telegram_id) DO UPDATE
-		SET 
-			total_spent = COALESCE(EXCLUDED.total_spent, public.users.total_spent), 
-			balance = COALESCE(EXCLUDED.balance, public.users.balance), 
-			first_name = COALESCE(EXCLUDED.first_name, public.users.first_name), 
-			last_name = COALESCE(EXCLUDED.last_name, public.users.last_name), 
-			username = COALESCE(EXCLUDED.username, public.users.username), 
-			language_code = COALESCE(EXCLUDED.language_code, public.users.language_code),
-			banned = COALESCE(EXCLUDED.banned, public.users.banned)
-		WHERE public.users.telegram_id = EXCLUDED.telegram_id
-		RETURNING id, telegram_id, total_spent, balance, first_name, last_name, username, language_code, banned
-	`
-
-	var user models.User
-	err := db.PostgresEngine.QueryRow(
-		query,
-		input.TelegramID,
-		input.TotalSpent,
-		input.Balance,
-		input.FirstName,
-		input.LastName,
-		input.Username,
-		input.LanguageCode,
-		input.Banned,
-	).Scan(
-		&user.ID,
-		&user.TelegramID,
-		&user.TotalSpent,
-		&user.Balance,
-		&user.FirstName,
-		&user.LastName,
-		&user.Username,
-		&user.LanguageCode,
-		&user.Banned,
-	)
+		writeBadRequest(w, "telegram_id is required")
+		return
+	}
 
+	// Banned Telegram users may not update their profile
+	if _, scoped := auth.TelegramIDFrom(r); scoped {
+		existing, _, err := h.users.ListUsers(r.Context(), store.UserFilter{TelegramID: input.TelegramID}, store.Page{Limit: 1, Sort: "id"})
+		if err != nil {
+			writeServerError(w, err.Error())
+			return
+		}
+		if len(existing) > 0 && existing[0].BannedAt(time.Now()) {
+			writeBanned(w)
+			return
+		}
+	}
+
+	user, err := h.users.UpsertUser(r.Context(), input)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeServerError(w, err.Error())
 		return
 	}
 
@@ -169,3 +132,102 @@ func CreateOrUpdateUser(w http.ResponseWriter, r *http.Request) {
 		Data:    user,
 	})
 }
+
+func (h *Handler) AdjustUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeBadParam(w, "user id")
+		return
+	}
+
+	var req struct {
+		BalanceDelta    money.Amount `json:"balance_delta"`
+		TotalSpentDelta money.Amount `json:"total_spent_delta"`
+		Reason          string       `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		writeBadRequest(w, "reason is required")
+		return
+	}
+	if req.BalanceDelta == 0 && req.TotalSpentDelta == 0 {
+		writeBadRequest(w, "balance_delta or total_spent_delta must be non-zero")
+		return
+	}
+
+	adjustment, user, err := h.users.AdjustUser(r.Context(), models.Adjustment{
+		UserID:          userID,
+		BalanceDelta:    req.BalanceDelta,
+		TotalSpentDelta: req.TotalSpentDelta,
+		Reason:          req.Reason,
+		CreatedAt:       time.Now(),
+	})
+	if err != nil {
+		if err == store.ErrNotFound {
+			writeJSONResponse(w, http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   "User not found",
+			})
+			return
+		}
+		writeJSONResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Error:   "Failed to adjust user: " + err.Error(),
+		})
+		return
+	}
+
+	writeJSONResponse(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "User adjusted successfully",
+		Data: map[string]interface{}{
+			"adjustment": adjustment,
+			"user":       user,
+		},
+	})
+}
+
+func (h *Handler) GetUserLedger(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeBadRequest(w, "Invalid user id")
+		return
+	}
+	var ok bool
+	if userID, ok = h.ownUserID(w, r, userID); !ok {
+		return
+	}
+
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			writeBadRequest(w, "Invalid limit")
+			return
+		}
+	}
+
+	summary, err := h.users.Ledger(r.Context(), userID, limit)
+	if err != nil {
+		if err == store.ErrNotFound {
+			writeJSONResponse(w, http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   "User not found",
+			})
+			return
+		}
+		writeServerError(w, err.Error())
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Found %d ledger entries", len(summary.Entries)),
+		Data:    summary,
+	})
+}
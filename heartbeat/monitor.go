@@ -0,0 +1,61 @@
+package heartbeat
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// DefaultTimeout is how long a node may stay silent before it is considered
+// offline when HEARTBEAT_TIMEOUT is not set.
+const DefaultTimeout = 3 * time.Minute
+
+// Marker is the storage the monitor flags silent nodes through.
+type Marker interface {
+	MarkOffline(ctx context.Context, cutoff time.Time) ([]int, error)
+}
+
+// Monitor periodically takes nodes that missed their heartbeat offline.
+type Monitor struct {
+	Nodes   Marker
+	Timeout time.Duration
+	Tick    time.Duration
+}
+
+// NewMonitor returns a monitor that checks several times per timeout so a
+// silent node is noticed soon after the timeout passes.
+func NewMonitor(nodes Marker, timeout time.Duration) *Monitor {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Monitor{Nodes: nodes, Timeout: timeout, Tick: timeout / 4}
+}
+
+// Run checks for silent nodes on every tick until stop is closed.
+func (m *Monitor) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(m.Tick)
+	defer ticker.Stop()
+
+	for {
+		m.RunOnce(time.Now())
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce marks every node whose last heartbeat is older than the timeout.
+func (m *Monitor) RunOnce(now time.Time) {
+	nodeIDs, err := m.Nodes.MarkOffline(context.Background(), now.Add(-m.Timeout))
+	if err != nil {
+		log.Printf("heartbeat: failed to mark silent nodes offline: %v", err)
+		return
+	}
+
+	for _, nodeID := range nodeIDs {
+		log.Printf("heartbeat: node %d missed its heartbeat, marked offline", nodeID)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hvmnd/api/auth"
+	"hvmnd/api/models"
+	"hvmnd/api/money"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+// registerMachine registers an available node for machineID and returns its
+// id.
+func (s *testServer) registerMachine(machineID, price string) int {
+	s.t.Helper()
+
+	body := fmt.Sprintf(`{"any_desk_address": %q, "any_desk_password": "secret", "price": %s, "machine_id": %q}`,
+		"anydesk-"+machineID, price, machineID)
+	resp := s.asAdmin("POST", "/api/v1/nodes", body)
+	expectStatus(s.t, resp, http.StatusCreated)
+	var node struct {
+		ID int `json:"id"`
+	}
+	resp.decode(s.t, &node)
+	return node.ID
+}
+
+// beat records a heartbeat from machineID received at at. The handler stamps
+// heartbeats with the current time, so tests that move the clock go to the
+// store directly.
+func (s *testServer) beat(machineID string, at time.Time) models.Node {
+	s.t.Helper()
+
+	node, err := s.store.RecordHeartbeat(context.Background(), models.Heartbeat{
+		MachineID:      machineID,
+		AnyDeskAddress: "anydesk-" + machineID,
+		ReceivedAt:     at,
+	})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return node
+}
+
+func (s *testServer) markOffline(cutoff time.Time) []int {
+	s.t.Helper()
+
+	nodeIDs, err := s.store.MarkOffline(context.Background(), cutoff)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return nodeIDs
+}
+
+func TestOnlyNodesTheMonitorTookOfflineComeBack(t *testing.T) {
+	s := newTestServer(t)
+	botKey := s.issueKey(auth.RoleBot)
+	start := time.Now()
+
+	nodeID := s.registerMachine("machine-a", "10")
+	silentID := s.registerMachine("machine-b", "10")
+	s.beat("machine-a", start)
+
+	// A node that never sent a heartbeat is left alone
+	if marked := s.markOffline(start.Add(10 * time.Minute)); !slices.Equal(marked, []int{nodeID}) {
+		t.Fatalf("want only node %d marked offline, got %v (node %d never reported)", nodeID, marked, silentID)
+	}
+	if node := s.beat("machine-a", start.Add(11*time.Minute)); node.Status != models.NodeStatusAvailable {
+		t.Fatalf("want the node back once it reports, got %q", node.Status)
+	}
+
+	body := fmt.Sprintf(`{"id": %d, "status": "offline"}`, nodeID)
+	expectStatus(t, s.asKey(botKey, "PATCH", "/api/v1/nodes", body), http.StatusOK)
+	if node := s.beat("machine-a", start.Add(12*time.Minute)); node.Status != models.NodeStatusOffline {
+		t.Fatalf("want a node taken offline by hand to stay offline, got %q", node.Status)
+	}
+}
+
+func TestSilentRentalsAreNotChargedForTheGap(t *testing.T) {
+	s := newTestServer(t)
+	userID := s.createUser(42)
+	s.fund(userID, "100")
+
+	// 60 an hour is one a minute
+	nodeID := s.registerMachine("machine-a", "60")
+	expectStatus(t, s.asAdmin("POST", fmt.Sprintf("/api/v1/nodes/%d/rent", nodeID), fmt.Sprintf(`{"user_id": %d}`, userID)), http.StatusOK)
+	start := time.Now()
+	s.beat("machine-a", start)
+
+	if marked := s.markOffline(start.Add(10 * time.Minute)); !slices.Equal(marked, []int{nodeID}) {
+		t.Fatalf("want the rented node paused, got %v", marked)
+	}
+	rented, err := s.store.RentedNodeIDs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.Contains(rented, nodeID) {
+		t.Fatal("want a paused node left out of billing")
+	}
+	if marked := s.markOffline(start.Add(20 * time.Minute)); len(marked) != 0 {
+		t.Fatalf("want a paused node left alone, got %v", marked)
+	}
+
+	// An hour of silence, then 30 minutes of use
+	node := s.beat("machine-a", start.Add(time.Hour))
+	if node.Status != models.NodeStatusRented || node.BillingPausedAt.Valid {
+		t.Fatalf("want the rental resumed, got %q (paused %v)", node.Status, node.BillingPausedAt)
+	}
+	if _, err := s.store.BillNode(context.Background(), nodeID, start.Add(90*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	balance, reconciled := s.ledger(userID)
+	if balance != money.MustParse("70") || !reconciled {
+		t.Fatalf("want 30 minutes charged for a balance of 70, got %v (reconciled %v)", balance, reconciled)
+	}
+}
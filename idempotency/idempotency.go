@@ -0,0 +1,207 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hvmnd/api/requestlog"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Header is the request header clients set to make a request idempotent.
+const Header = "Idempotency-Key"
+
+// DefaultTTL is how long a key and its response are kept when
+// IDEMPOTENCY_TTL is not set.
+const DefaultTTL = 24 * time.Hour
+
+// maxKeyLength bounds the keys clients may send.
+const maxKeyLength = 255
+
+// Record is what is remembered about a request made with an idempotency key.
+type Record struct {
+	Fingerprint string
+	StatusCode  int // 0 while the first request is still being handled
+	ContentType string
+	Body        []byte
+}
+
+// Store keeps idempotency records. Keys are scoped so that different callers
+// can use the same key without colliding.
+type Store interface {
+	// ClaimIdempotencyKey reserves key for a new request. If a record that
+	// has not expired by now already exists, it is returned with claimed
+	// set to false.
+	ClaimIdempotencyKey(ctx context.Context, scope, key, fingerprint string, now, expiresAt time.Time) (record Record, claimed bool, err error)
+	// SaveIdempotentResponse stores the response of a claimed key.
+	SaveIdempotentResponse(ctx context.Context, scope, key string, record Record) error
+	// ReleaseIdempotencyKey forgets a claimed key so the request can be
+	// retried.
+	ReleaseIdempotencyKey(ctx context.Context, scope, key string) error
+	// PurgeIdempotencyKeys deletes the records that expired by now and
+	// returns how many there were.
+	PurgeIdempotencyKeys(ctx context.Context, now time.Time) (int, error)
+}
+
+// Middleware replays the stored response of a request that is retried with
+// the same Idempotency-Key.
+type Middleware struct {
+	Records Store
+	TTL     time.Duration
+}
+
+func New(records Store, ttl time.Duration) *Middleware {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Middleware{Records: records, TTL: ttl}
+}
+
+// fingerprint identifies a request by its method, target and body.
+func fingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Wrap makes next idempotent for requests that carry the Idempotency-Key
+// header; requests without it are passed through. scope returns the
+// namespace of the request's keys, usually the caller's identity.
+//
+// The first request with a key runs next and its response is stored for the
+// TTL. A retry with the same key and the same request gets the stored
+// response back, while a retry with a different request is rejected with 422.
+// Server errors are not stored, so a request that failed that way can be
+// retried with the same key.
+func (m *Middleware) Wrap(scope func(*http.Request) string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(Header)
+			if key == "" {
+				next(w, r)
+				return
+			}
+			if len(key) > maxKeyLength {
+				writeError(w, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "Failed to read request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			ctx := r.Context()
+			namespace := scope(r)
+			digest := fingerprint(r, body)
+			now := time.Now()
+
+			record, claimed, err := m.Records.ClaimIdempotencyKey(ctx, namespace, key, digest, now, now.Add(m.TTL))
+			if err != nil {
+				requestlog.Logger(ctx).Error("idempotency: failed to claim key", "error", err)
+				writeError(w, http.StatusInternalServerError, "Failed to check Idempotency-Key")
+				return
+			}
+
+			if !claimed {
+				switch {
+				case record.Fingerprint != digest:
+					writeError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+				case record.StatusCode == 0:
+					writeError(w, http.StatusConflict, "A request with this Idempotency-Key is still being processed")
+				default:
+					if record.ContentType != "" {
+						w.Header().Set("Content-Type", record.ContentType)
+					}
+					w.Header().Set("Idempotent-Replayed", "true")
+					w.WriteHeader(record.StatusCode)
+					w.Write(record.Body)
+				}
+				return
+			}
+
+			recorder := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+			next(recorder, r)
+
+			// Stored responses outlive the request, so they are written
+			// with a fresh context.
+			if recorder.statusCode >= http.StatusInternalServerError {
+				if err := m.Records.ReleaseIdempotencyKey(context.Background(), namespace, key); err != nil {
+					requestlog.Logger(ctx).Error("idempotency: failed to release key", "error", err)
+				}
+				return
+			}
+
+			record = Record{
+				Fingerprint: digest,
+				StatusCode:  recorder.statusCode,
+				ContentType: recorder.Header().Get("Content-Type"),
+				Body:        recorder.body.Bytes(),
+			}
+			if err := m.Records.SaveIdempotentResponse(context.Background(), namespace, key, record); err != nil {
+				requestlog.Logger(ctx).Error("idempotency: failed to store response", "error", err)
+			}
+		}
+	}
+}
+
+// Run purges expired records every interval until stop is closed.
+func (m *Middleware) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := m.Records.PurgeIdempotencyKeys(context.Background(), time.Now())
+		if err != nil {
+			log.Printf("idempotency: failed to purge expired keys: %v", err)
+		} else if purged > 0 {
+			log.Printf("idempotency: purged %d expired keys", purged)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// responseRecorder passes a response through while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	if !r.wroteHeader {
+		r.statusCode = statusCode
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(struct {
+		Success   bool   `json:"success"`
+		Error     string `json:"error"`
+		RequestID string `json:"request_id,omitempty"`
+	}{false, message, w.Header().Get(requestlog.Header)})
+}
@@ -0,0 +1,44 @@
+package idempotency_test
+
+import (
+	"hvmnd/api/idempotency"
+	"hvmnd/api/store/memory"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServerErrorsAreNotStored(t *testing.T) {
+	m := idempotency.New(memory.New(), 0)
+
+	calls := 0
+	handler := m.Wrap(func(*http.Request) string { return "test" })(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/things", strings.NewReader(`{"name": "a"}`))
+		req.Header.Set(idempotency.Header, "key-1")
+		recorder := httptest.NewRecorder()
+		handler(recorder, req)
+		return recorder
+	}
+
+	if status := send().Code; status != http.StatusInternalServerError {
+		t.Fatalf("want the first attempt to fail, got %d", status)
+	}
+	retry := send()
+	if retry.Code != http.StatusCreated || retry.Header().Get("Idempotent-Replayed") != "" || calls != 2 {
+		t.Fatalf("want the retry served fresh, got %d (replayed %q, %d calls)", retry.Code, retry.Header().Get("Idempotent-Replayed"), calls)
+	}
+	replay := send()
+	if replay.Code != http.StatusCreated || replay.Header().Get("Idempotent-Replayed") != "true" || calls != 2 {
+		t.Fatalf("want the success replayed, got %d (replayed %q, %d calls)", replay.Code, replay.Header().Get("Idempotent-Replayed"), calls)
+	}
+}
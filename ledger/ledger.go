@@ -0,0 +1,168 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"hvmnd/api/money"
+	"hvmnd/api/utils"
+	"time"
+)
+
+// Reason explains why a user's balance changed.
+type Reason string
+
+const (
+	ReasonOpeningBalance   Reason = "opening_balance"
+	ReasonPaymentCompleted Reason = "payment_completed"
+	ReasonPaymentCancelled Reason = "payment_cancelled"
+	ReasonPaymentRefunded  Reason = "payment_refunded"
+	ReasonRentalCharge     Reason = "rental_charge"
+	ReasonManualAdjustment Reason = "manual_adjustment"
+)
+
+// counterAccounts maps each reason to the system account that takes the
+// opposite side of the transfer.
+var counterAccounts = map[Reason]string{
+	ReasonOpeningBalance:   "equity:opening_balances",
+	ReasonPaymentCompleted: "external:payments",
+	ReasonPaymentCancelled: "external:payments",
+	ReasonPaymentRefunded:  "external:payments",
+	ReasonRentalCharge:     "revenue:rentals",
+	ReasonManualAdjustment: "equity:adjustments",
+}
+
+// Entry is a single immutable line of the balance ledger.
+type Entry struct {
+	ID            int64          `json:"id"`
+	TransactionID int64          `json:"transaction_id"`
+	Account       string         `json:"account"`
+	Amount        money.Amount   `json:"amount"`
+	Reason        Reason         `json:"reason"`
+	ReferenceID   sql.NullString `json:"-"`
+	CreatedAt     time.Time      `json:"created_at"`
+}
+
+func (e Entry) MarshalJSON() ([]byte, error) {
+	type Alias Entry
+	return json.Marshal(&struct {
+		ReferenceID interface{} `json:"reference_id"`
+		Alias
+	}{
+		ReferenceID: utils.NullStringOrValue(e.ReferenceID),
+		Alias:       (Alias)(e),
+	})
+}
+
+// CounterAccount returns the system account that takes the opposite side of
+// transfers made for reason.
+func CounterAccount(reason Reason) (string, bool) {
+	account, ok := counterAccounts[reason]
+	return account, ok
+}
+
+// UserAccount returns the ledger account name for a user.
+func UserAccount(userID int) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+// Post records a transfer of amount to the user's account (negative amounts
+// are debits) and applies it to users.balance within tx. It returns the
+// ledger transaction id.
+func Post(ctx context.Context, tx *sql.Tx, userID int, amount money.Amount, reason Reason, referenceID string) (int64, error) {
+	counter, ok := CounterAccount(reason)
+	if !ok {
+		return 0, fmt.Errorf("unknown ledger reason %q", reason)
+	}
+
+	var ref sql.NullString
+	if referenceID != "" {
+		ref = sql.NullString{String: referenceID, Valid: true}
+	}
+
+	var transactionID int64
+	err := tx.QueryRowContext(ctx, "SELECT nextval('balance_ledger_transaction_seq')").Scan(&transactionID)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `
+		INSERT INTO balance_ledger (transaction_id, account, user_id, amount, reason, reference_id)
+		VALUES ($1, $2, $3, $4, $5, $6), ($1, $7, NULL, $8, $5, $6)
+	`
+	_, err = tx.ExecContext(ctx, query, transactionID, UserAccount(userID), userID, amount, reason, ref, counter, -amount)
+	if err != nil {
+		return 0, err
+	}
+
+	query = `
+		UPDATE users SET
+		balance = balance + $1
+		WHERE id=$2
+	`
+	result, err := tx.ExecContext(ctx, query, amount, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rowsAffected != 1 {
+		return 0, fmt.Errorf("user %d not found", userID)
+	}
+
+	return transactionID, nil
+}
+
+// Entries returns the entries on the user's account, newest first.
+func Entries(ctx context.Context, conn *sql.DB, userID int, limit int) ([]Entry, error) {
+	query := `
+		SELECT id, transaction_id, account, amount, reason, reference_id, created_at
+		FROM balance_ledger
+		WHERE user_id = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`
+	rows, err := conn.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var entry Entry
+		err := rows.Scan(
+			&entry.ID,
+			&entry.TransactionID,
+			&entry.Account,
+			&entry.Amount,
+			&entry.Reason,
+			&entry.ReferenceID,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// Reconcile returns the stored users.balance together with the balance
+// derived from the ledger so callers can detect drift.
+func Reconcile(ctx context.Context, conn *sql.DB, userID int) (balance money.Amount, ledgerBalance money.Amount, err error) {
+	query := `
+		SELECT u.balance, COALESCE(SUM(l.amount), 0)
+		FROM users u
+		LEFT JOIN balance_ledger l ON l.user_id = u.id
+		WHERE u.id = $1
+		GROUP BY u.id, u.balance
+	`
+	err = conn.QueryRowContext(ctx, query, userID).Scan(&balance, &ledgerBalance)
+	return balance, ledgerBalance, err
+}
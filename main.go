@@ -1,32 +1,116 @@
 package main
 
 import (
+	"hvmnd/api/auth"
+	"hvmnd/api/billing"
+	"hvmnd/api/currency"
 	"hvmnd/api/db"
+	"hvmnd/api/expiry"
 	"hvmnd/api/handlers"
+	"hvmnd/api/heartbeat"
+	"hvmnd/api/idempotency"
+	"hvmnd/api/providers"
+	"hvmnd/api/requestlog"
+	"hvmnd/api/secrets"
+	"hvmnd/api/store"
+	"hvmnd/api/store/postgres"
+	"hvmnd/api/utils"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"time"
 )
 
 func main() {
+	// Logs are JSON lines; the standard log package writes through the same
+	// handler
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
+	if err := currency.Init(os.Getenv("BASE_CURRENCY")); err != nil {
+		log.Fatal(err)
+	}
+
 	db.InitDB()
-	http.HandleFunc("GET /api/v1/ping", handlers.Ping)
-	http.HandleFunc("GET /api/v1/users", handlers.GetUsers)
-	http.HandleFunc("GET /api/v1/users/{id}", handlers.GetUsers)
-	http.HandleFunc("POST /api/v1/users", handlers.CreateOrUpdateUser)
-
-	http.HandleFunc("GET /api/v1/nodes", handlers.GetNodes)
-	http.HandleFunc("GET /api/v1/nodes/{id}", handlers.GetNodes)
-	http.HandleFunc("PATCH /api/v1/nodes", handlers.UpdateNode)
-
-	http.HandleFunc("GET /api/v1/payments", handlers.GetPayments)
-	http.HandleFunc("GET /api/v1/payments/{id}", handlers.GetPayments)
-	http.HandleFunc("POST /api/v1/payments", handlers.CreatePaymentTicket)
-	http.HandleFunc("PATCH /api/v1/payments/complete/{id}", handlers.CompletePayment)
-	http.HandleFunc("PATCH /api/v1/payments/cancel/{id}", handlers.CancelPayment)
-
-	http.HandleFunc("POST /api/v1/quiz/save-hash", handlers.SaveHashMapping)
-	http.HandleFunc("GET /api/v1/quiz/get-question-answer", handlers.GetQuestionAnswerByHash)
-	http.HandleFunc("POST /api/v1/quiz/save-answer", handlers.SaveUserAnswer)
-
-	log.Fatal(http.ListenAndServe(":9876", nil))
+	stores := postgres.New(db.PostgresEngine)
+	authenticator := auth.New(stores, os.Getenv("ADMIN_API_KEY"))
+	if botToken := os.Getenv("TELEGRAM_BOT_TOKEN"); botToken != "" {
+		initDataMaxAge, err := utils.DurationFromEnv("TELEGRAM_INIT_DATA_MAX_AGE", auth.DefaultInitDataMaxAge)
+		if err != nil {
+			log.Fatal(err)
+		}
+		authenticator.EnableTelegram(botToken, initDataMaxAge)
+	}
+
+	if err := secrets.Init(os.Getenv("NODE_SECRETS_KEY")); err != nil {
+		log.Fatal(err)
+	}
+	migrated, err := db.EncryptNodePasswords()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if migrated > 0 {
+		log.Printf("Encrypted %d plaintext AnyDesk passwords", migrated)
+	}
+
+	billingTick, err := utils.DurationFromEnv("BILLING_TICK", billing.DefaultTick)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go billing.NewWorker(stores, billingTick).Run(nil)
+
+	heartbeatTimeout, err := utils.DurationFromEnv("HEARTBEAT_TIMEOUT", heartbeat.DefaultTimeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go heartbeat.NewMonitor(stores, heartbeatTimeout).Run(nil)
+
+	ticketTTL, err := utils.DurationFromEnv("PAYMENT_TICKET_TTL", expiry.DefaultTTL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go expiry.NewSweeper(stores, ticketTTL).Run(nil)
+
+	idempotencyTTL, err := utils.DurationFromEnv("IDEMPOTENCY_TTL", idempotency.DefaultTTL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	idem := idempotency.New(stores, idempotencyTTL)
+	go idem.Run(time.Hour, nil)
+
+	// The fake provider is for local development only
+	var paymentProviders []providers.PaymentProvider
+	if secret := os.Getenv("FAKE_PAYMENT_PROVIDER_SECRET"); secret != "" {
+		log.Printf("Accepting webhooks from the fake payment provider")
+		paymentProviders = append(paymentProviders, providers.NewFake(secret))
+	}
+
+	refundPolicy := store.RefundPolicy(os.Getenv("REFUND_BALANCE_POLICY"))
+	if refundPolicy == "" {
+		refundPolicy = store.DefaultRefundPolicy
+	}
+	if !refundPolicy.Valid() {
+		log.Fatalf("REFUND_BALANCE_POLICY must be reject, allow_negative or clamp, got %q", refundPolicy)
+	}
+
+	h := handlers.New(handlers.Stores{
+		Users:    stores,
+		Nodes:    stores,
+		Payments: stores,
+		Rates:    stores,
+		Quiz:     stores,
+		Keys:     stores,
+		Audit:    stores,
+	}, handlers.Options{
+		Providers:    providers.NewRegistry(paymentProviders...),
+		RefundPolicy: refundPolicy,
+	})
+	registerRoutes(http.DefaultServeMux, h, authenticator, idem)
+
+	log.Fatal(http.ListenAndServe(":9876", requestlog.New(slog.Default()).Wrap(http.DefaultServeMux)))
 }
@@ -3,6 +3,7 @@ package models
 import (
 	"database/sql"
 	"encoding/json"
+	"hvmnd/api/money"
 	"hvmnd/api/utils"
 	"time"
 )
@@ -11,10 +12,10 @@ type Node struct {
 	ID                         int            `json:"id"`
 	OldID                      sql.NullInt32  `json:"old_id"`
 	AnyDeskAddress             string         `json:"any_desk_address"`
-	AnyDeskPassword            string         `json:"any_desk_password"`
-	Status                     string         `json:"status"`
+	AnyDeskPassword            string         `json:"-"` // Encrypted at rest, see NodeCredentials
+	Status                     NodeStatus     `json:"status"`
 	Software                   sql.NullString `json:"software"`
-	Price                      float64        `json:"price"`
+	Price                      money.Amount   `json:"price"` // Per hour, in the base currency
 	Renter                     sql.NullInt16  `json:"renter"`
 	RentStartTime              sql.NullTime   `json:"rent_start_time"`
 	LastBalanceUpdateTimestamp sql.NullTime   `json:"last_balance_update_timestamp"`
@@ -23,21 +24,43 @@ type Node struct {
 	OtherSpecs                 sql.NullString `json:"other_specs"`
 	Licenses                   sql.NullString `json:"licenses"`
 	MachineID                  sql.NullString `json:"machine_id"`
+	GPUModel                   sql.NullString `json:"gpu_model"`
+	VRAMGB                     sql.NullInt32  `json:"vram_gb"`
+	CPUCores                   sql.NullInt32  `json:"cpu_cores"`
+	RAMGB                      sql.NullInt32  `json:"ram_gb"`
+	StorageGB                  sql.NullInt32  `json:"storage_gb"`
+	SoftwareCatalog            []NodeSoftware `json:"software_catalog"`
+	LastHeartbeatAt            sql.NullTime   `json:"last_heartbeat_at"`
+	BillingPausedAt            sql.NullTime   `json:"billing_paused_at"`
 }
 
 func (n Node) MarshalJSON() ([]byte, error) {
 	type Alias Node
+
+	catalog := n.SoftwareCatalog
+	if catalog == nil {
+		catalog = []NodeSoftware{}
+	}
+
 	return json.Marshal(&struct {
-		OldID                      interface{} `json:"old_id"`
-		Software                   interface{} `json:"software"`
-		Renter                     interface{} `json:"renter"`
-		RentStartTime              interface{} `json:"rent_start_time"`
-		LastBalanceUpdateTimestamp interface{} `json:"last_balance_update_timestamp"`
-		CPU                        interface{} `json:"cpu"`
-		GPU                        interface{} `json:"gpu"`
-		OtherSpecs                 interface{} `json:"other_specs"`
-		Licenses                   interface{} `json:"licenses"`
-		MachineID                  interface{} `json:"machine_id"`
+		OldID                      interface{}    `json:"old_id"`
+		Software                   interface{}    `json:"software"`
+		Renter                     interface{}    `json:"renter"`
+		RentStartTime              interface{}    `json:"rent_start_time"`
+		LastBalanceUpdateTimestamp interface{}    `json:"last_balance_update_timestamp"`
+		CPU                        interface{}    `json:"cpu"`
+		GPU                        interface{}    `json:"gpu"`
+		OtherSpecs                 interface{}    `json:"other_specs"`
+		Licenses                   interface{}    `json:"licenses"`
+		MachineID                  interface{}    `json:"machine_id"`
+		GPUModel                   interface{}    `json:"gpu_model"`
+		VRAMGB                     interface{}    `json:"vram_gb"`
+		CPUCores                   interface{}    `json:"cpu_cores"`
+		RAMGB                      interface{}    `json:"ram_gb"`
+		StorageGB                  interface{}    `json:"storage_gb"`
+		SoftwareCatalog            []NodeSoftware `json:"software_catalog"`
+		LastHeartbeatAt            interface{}    `json:"last_heartbeat_at"`
+		BillingPausedAt            interface{}    `json:"billing_paused_at"`
 		Alias
 	}{
 		OldID:                      utils.NullInt32OrValue(n.OldID),
@@ -50,24 +73,72 @@ func (n Node) MarshalJSON() ([]byte, error) {
 		OtherSpecs:                 utils.NullStringOrValue(n.OtherSpecs),
 		Licenses:                   utils.NullStringOrValue(n.Licenses),
 		MachineID:                  utils.NullStringOrValue(n.MachineID),
+		GPUModel:                   utils.NullStringOrValue(n.GPUModel),
+		VRAMGB:                     utils.NullInt32OrValue(n.VRAMGB),
+		CPUCores:                   utils.NullInt32OrValue(n.CPUCores),
+		RAMGB:                      utils.NullInt32OrValue(n.RAMGB),
+		StorageGB:                  utils.NullInt32OrValue(n.StorageGB),
+		SoftwareCatalog:            catalog,
+		LastHeartbeatAt:            utils.NullTimeOrValue(n.LastHeartbeatAt),
+		BillingPausedAt:            utils.NullTimeOrValue(n.BillingPausedAt),
 		Alias:                      (Alias)(n),
 	})
 }
 
+// NodeCredentials carries the decrypted AnyDesk login of a node. It is only
+// returned to the node's current renter or an admin.
+type NodeCredentials struct {
+	NodeID          int    `json:"node_id"`
+	AnyDeskAddress  string `json:"any_desk_address"`
+	AnyDeskPassword string `json:"any_desk_password"`
+}
+
 type NodeInput struct {
-	ID                         *int16     `json:"id,omitempty"`
-	OldID                      *int16     `json:"old_id,omitempty"`
-	AnyDeskAddress             *string    `json:"any_desk_address,omitempty"`
-	AnyDeskPassword            *string    `json:"any_desk_password,omitempty"`
-	Status                     *string    `json:"status,omitempty"`
-	Software                   *string    `json:"software,omitempty"`
-	Price                      *float64   `json:"price,omitempty"`
-	Renter                     *int16     `json:"renter,omitempty"`
-	RentStartTime              *time.Time `json:"rent_start_time,omitempty"`
-	LastBalanceUpdateTimestamp *time.Time `json:"last_balance_update_timestamp,omitempty"`
-	CPU                        *string    `json:"cpu,omitempty"`
-	GPU                        *string    `json:"gpu,omitempty"`
-	OtherSpecs                 *string    `json:"other_specs,omitempty"`
-	Licenses                   *string    `json:"licenses,omitempty"`
-	MachineID                  *string    `json:"machine_id,omitempty"`
+	ID                         *int16          `json:"id,omitempty"`
+	OldID                      *int16          `json:"old_id,omitempty"`
+	AnyDeskAddress             *string         `json:"any_desk_address,omitempty"`
+	AnyDeskPassword            *string         `json:"any_desk_password,omitempty"`
+	Status                     *NodeStatus     `json:"status,omitempty"`
+	Software                   *string         `json:"software,omitempty"`
+	Price                      *money.Amount   `json:"price,omitempty"`
+	Renter                     *int16          `json:"renter,omitempty"`
+	RentStartTime              *time.Time      `json:"rent_start_time,omitempty"`
+	LastBalanceUpdateTimestamp *time.Time      `json:"last_balance_update_timestamp,omitempty"`
+	CPU                        *string         `json:"cpu,omitempty"`
+	GPU                        *string         `json:"gpu,omitempty"`
+	OtherSpecs                 *string         `json:"other_specs,omitempty"`
+	Licenses                   *string         `json:"licenses,omitempty"`
+	MachineID                  *string         `json:"machine_id,omitempty"`
+	GPUModel                   *string         `json:"gpu_model,omitempty"`
+	VRAMGB                     *int32          `json:"vram_gb,omitempty"`
+	CPUCores                   *int32          `json:"cpu_cores,omitempty"`
+	RAMGB                      *int32          `json:"ram_gb,omitempty"`
+	StorageGB                  *int32          `json:"storage_gb,omitempty"`
+	SoftwareCatalog            *[]NodeSoftware `json:"software_catalog,omitempty"`
+}
+
+// SoftwareKind tells installed software apart from licenses a node holds.
+type SoftwareKind string
+
+const (
+	SoftwareKindSoftware SoftwareKind = "software"
+	SoftwareKindLicense  SoftwareKind = "license"
+)
+
+// NodeSoftware is one entry of a node's software catalog.
+type NodeSoftware struct {
+	Name string       `json:"name"`
+	Kind SoftwareKind `json:"kind"`
+}
+
+// Heartbeat is the liveness report the agent on a node sends periodically.
+// The load metrics are optional.
+type Heartbeat struct {
+	MachineID      string    `json:"machine_id"`
+	UptimeSeconds  int64     `json:"uptime_seconds"`
+	AnyDeskAddress string    `json:"any_desk_address"`
+	CPUPercent     *float64  `json:"cpu_percent,omitempty"`
+	MemoryPercent  *float64  `json:"memory_percent,omitempty"`
+	GPUPercent     *float64  `json:"gpu_percent,omitempty"`
+	ReceivedAt     time.Time `json:"received_at"`
 }
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// NodeStatus is the lifecycle state of a node.
+type NodeStatus string
+
+const (
+	NodeStatusAvailable      NodeStatus = "available"
+	NodeStatusRented         NodeStatus = "rented"
+	NodeStatusMaintenance    NodeStatus = "maintenance"
+	NodeStatusOffline        NodeStatus = "offline"
+	NodeStatusDecommissioned NodeStatus = "decommissioned"
+)
+
+// NodeStatuses lists every valid status.
+var NodeStatuses = []NodeStatus{
+	NodeStatusAvailable,
+	NodeStatusRented,
+	NodeStatusMaintenance,
+	NodeStatusOffline,
+	NodeStatusDecommissioned,
+}
+
+// nodeStatusTransitions is the graph of allowed status changes. A node only
+// becomes rented through the rent endpoint and only leaves rented by being
+// released, which closes its rental; no status update may move it out of
+// rented. Decommissioned is final.
+var nodeStatusTransitions = map[NodeStatus][]NodeStatus{
+	NodeStatusAvailable:      {NodeStatusRented, NodeStatusMaintenance, NodeStatusOffline, NodeStatusDecommissioned},
+	NodeStatusRented:         {},
+	NodeStatusMaintenance:    {NodeStatusAvailable, NodeStatusOffline, NodeStatusDecommissioned},
+	NodeStatusOffline:        {NodeStatusAvailable, NodeStatusMaintenance, NodeStatusDecommissioned},
+	NodeStatusDecommissioned: {},
+}
+
+// Valid reports whether s is a known status.
+func (s NodeStatus) Valid() bool {
+	_, ok := nodeStatusTransitions[s]
+	return ok
+}
+
+// Transitions returns the statuses a node in status s may move to.
+func (s NodeStatus) Transitions() []NodeStatus {
+	return nodeStatusTransitions[s]
+}
+
+// CanTransitionTo reports whether a node may move from s to next. Staying in
+// the same status is always allowed.
+func (s NodeStatus) CanTransitionTo(next NodeStatus) bool {
+	if s == next {
+		return true
+	}
+	for _, allowed := range nodeStatusTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionError is returned when a write would move a node along an edge
+// that is not in the transition graph.
+type TransitionError struct {
+	NodeID  int          `json:"node_id"`
+	From    NodeStatus   `json:"from"`
+	To      NodeStatus   `json:"to"`
+	Allowed []NodeStatus `json:"allowed"`
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("node %d cannot change status from %q to %q", e.NodeID, e.From, e.To)
+}
+
+// CheckNodeTransition returns a *TransitionError if a node may not move from
+// one status to the other.
+func CheckNodeTransition(nodeID int, from, to NodeStatus) error {
+	if from.CanTransitionTo(to) {
+		return nil
+	}
+	allowed := from.Transitions()
+	if allowed == nil {
+		allowed = []NodeStatus{}
+	}
+	return &TransitionError{NodeID: nodeID, From: from, To: to, Allowed: allowed}
+}
+
+// NodeStatusChange is one row of a node's status history.
+type NodeStatusChange struct {
+	ID         int64      `json:"id"`
+	NodeID     int        `json:"node_id"`
+	FromStatus NodeStatus `json:"from_status,omitempty"`
+	ToStatus   NodeStatus `json:"to_status"`
+	ChangedBy  string     `json:"changed_by"`
+	ChangedAt  time.Time  `json:"changed_at"`
+}
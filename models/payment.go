@@ -1,13 +1,111 @@
 package models
 
 import (
+	"database/sql"
+	"encoding/json"
+	"hvmnd/api/money"
+	"hvmnd/api/utils"
 	"time"
 )
 
+// Payment is a top-up ticket. Amount and RefundedAmount are in the ticket's
+// Currency; ExchangeRate, the base currency value of one unit of it, is set
+// when the ticket is completed.
 type Payment struct {
-	ID       int       `json:"id"`
-	UserID   int       `json:"user_id"`
-	Amount   float64   `json:"amount"`
-	Status   string    `json:"status"`
-	Datetime time.Time `json:"datetime"`
+	ID             int                  `json:"id"`
+	UserID         int                  `json:"user_id"`
+	Amount         money.Amount         `json:"amount"`
+	Currency       string               `json:"currency"`
+	ExchangeRate   sql.Null[money.Rate] `json:"-"`
+	RefundedAmount money.Amount         `json:"refunded_amount"`
+	Status         string               `json:"status"`
+	Datetime       time.Time            `json:"datetime"`
+}
+
+func (p Payment) MarshalJSON() ([]byte, error) {
+	type Alias Payment
+	return json.Marshal(&struct {
+		ExchangeRate interface{} `json:"exchange_rate"`
+		Alias
+	}{
+		ExchangeRate: utils.NullOrValue(p.ExchangeRate),
+		Alias:        (Alias)(p),
+	})
+}
+
+// ToBase converts an amount in the ticket's currency to the base currency.
+// Tickets completed before currencies were introduced have no rate and are in
+// the base currency already.
+func (p Payment) ToBase(amount money.Amount) money.Amount {
+	if !p.ExchangeRate.Valid {
+		return amount
+	}
+	return amount.MulRate(p.ExchangeRate.V)
+}
+
+// FromBase converts a base currency amount to the ticket's currency. It
+// rounds toward zero, so the result is never worth more than amount.
+func (p Payment) FromBase(amount money.Amount) money.Amount {
+	if !p.ExchangeRate.Valid {
+		return amount
+	}
+	return amount.DivRateDown(p.ExchangeRate.V)
+}
+
+// Refundable is the part of a paid ticket that has not been refunded yet.
+func (p Payment) Refundable() money.Amount {
+	if p.Status != "paid" {
+		return 0
+	}
+	return p.Amount - p.RefundedAmount
+}
+
+// ExchangeRate is the base currency value of one unit of Currency from
+// EffectiveFrom until the next rate of the currency takes effect.
+type ExchangeRate struct {
+	Currency      string     `json:"currency"`
+	Rate          money.Rate `json:"rate"`
+	EffectiveFrom time.Time  `json:"effective_from"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// Refund returns part of a paid ticket to the payer.
+type Refund struct {
+	ID        int          `json:"id"`
+	PaymentID int          `json:"payment_id"`
+	Amount    money.Amount `json:"amount"`
+	Reason    string       `json:"reason"`
+	CreatedBy string       `json:"created_by"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// PaymentEvent is a webhook event received from a payment provider, kept
+// with its raw payload. Result records what processing it did and is unset
+// until it has been processed.
+type PaymentEvent struct {
+	ID          int64          `json:"id"`
+	Provider    string         `json:"provider"`
+	EventID     string         `json:"event_id"`
+	EventType   string         `json:"event_type"`
+	PaymentID   int            `json:"payment_id"`
+	Amount      money.Amount   `json:"amount"`
+	Payload     []byte         `json:"-"`
+	Result      sql.NullString `json:"-"`
+	ReceivedAt  time.Time      `json:"received_at"`
+	ProcessedAt sql.NullTime   `json:"-"`
+}
+
+func (e PaymentEvent) MarshalJSON() ([]byte, error) {
+	type Alias PaymentEvent
+	return json.Marshal(&struct {
+		Payload     string      `json:"payload"`
+		Result      interface{} `json:"result"`
+		ProcessedAt interface{} `json:"processed_at"`
+		Alias
+	}{
+		Payload:     string(e.Payload),
+		Result:      utils.NullStringOrValue(e.Result),
+		ProcessedAt: utils.NullTimeOrValue(e.ProcessedAt),
+		Alias:       (Alias)(e),
+	})
 }
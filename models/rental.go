@@ -0,0 +1,33 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"hvmnd/api/money"
+	"hvmnd/api/utils"
+	"time"
+)
+
+type Rental struct {
+	ID           int            `json:"id"`
+	NodeID       int            `json:"node_id"`
+	UserID       int            `json:"user_id"`
+	StartedAt    time.Time      `json:"started_at"`
+	EndedAt      sql.NullTime   `json:"-"`
+	PricePerHour money.Amount   `json:"price_per_hour"`
+	TotalCharged money.Amount   `json:"total_charged"`
+	EndReason    sql.NullString `json:"-"`
+}
+
+func (r Rental) MarshalJSON() ([]byte, error) {
+	type Alias Rental
+	return json.Marshal(&struct {
+		EndedAt   interface{} `json:"ended_at"`
+		EndReason interface{} `json:"end_reason"`
+		Alias
+	}{
+		EndedAt:   utils.NullTimeOrValue(r.EndedAt),
+		EndReason: utils.NullStringOrValue(r.EndReason),
+		Alias:     (Alias)(r),
+	})
+}
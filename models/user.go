@@ -3,19 +3,37 @@ package models
 import (
 	"database/sql"
 	"encoding/json"
+	"hvmnd/api/money"
 	"hvmnd/api/utils"
+	"time"
 )
 
 type User struct {
 	ID           int            `json:"id"`
 	TelegramID   int            `json:"telegram_id"`
-	TotalSpent   float64        `json:"total_spent"`
-	Balance      float64        `json:"balance"`
+	TotalSpent   money.Amount   `json:"total_spent"`
+	Balance      money.Amount   `json:"balance"` // In the base currency
 	FirstName    sql.NullString `json:"-"`
 	LastName     sql.NullString `json:"-"`
 	Username     sql.NullString `json:"-"`
 	LanguageCode sql.NullString `json:"-"`
 	Banned       sql.NullBool   `json:"-"`
+	BanReason    sql.NullString `json:"-"`
+	BannedUntil  sql.NullTime   `json:"-"` // NULL for a permanent ban
+}
+
+// BanActive reports whether a user with the given ban columns is banned at
+// now. A temporary ban lapses on its own once banned_until has passed.
+func BanActive(banned sql.NullBool, until sql.NullTime, now time.Time) bool {
+	if !banned.Valid || !banned.Bool {
+		return false
+	}
+	return !until.Valid || now.Before(until.Time)
+}
+
+// BannedAt reports whether the user is banned at now.
+func (u User) BannedAt(now time.Time) bool {
+	return BanActive(u.Banned, u.BannedUntil, now)
 }
 
 func (u User) MarshalJSON() ([]byte, error) {
@@ -26,6 +44,8 @@ func (u User) MarshalJSON() ([]byte, error) {
 		Username     interface{} `json:"username"`
 		LanguageCode interface{} `json:"language_code"`
 		Banned       interface{} `json:"banned"`
+		BanReason    interface{} `json:"ban_reason"`
+		BannedUntil  interface{} `json:"banned_until"`
 		Alias
 	}{
 		FirstName:    utils.NullStringOrValue(u.FirstName),
@@ -33,17 +53,31 @@ func (u User) MarshalJSON() ([]byte, error) {
 		Username:     utils.NullStringOrValue(u.Username),
 		LanguageCode: utils.NullStringOrValue(u.LanguageCode),
 		Banned:       utils.NullBoolOrValue(u.Banned),
+		BanReason:    utils.NullStringOrValue(u.BanReason),
+		BannedUntil:  utils.NullTimeOrValue(u.BannedUntil),
 		Alias:        (Alias)(u),
 	})
 }
 
+// UserInput is the profile a user is created or updated with. Absent fields
+// are left untouched. The balance and total spent are changed through an
+// Adjustment instead, and bans through BanUser and UnbanUser.
 type UserInput struct {
-	TelegramID   int      `json:"telegram_id"`
-	TotalSpent   *float64 `json:"total_spent,omitempty"` // Use pointer to detect if the field is present
-	Balance      *float64 `json:"balance,omitempty"`     // Same here
-	FirstName    *string  `json:"first_name,omitempty"`
-	LastName     *string  `json:"last_name,omitempty"`
-	Username     *string  `json:"username,omitempty"`
-	LanguageCode *string  `json:"language_code,omitempty"`
-	Banned       *bool    `json:"banned,omitempty"`
+	TelegramID   int     `json:"telegram_id"`
+	FirstName    *string `json:"first_name,omitempty"`
+	LastName     *string `json:"last_name,omitempty"`
+	Username     *string `json:"username,omitempty"`
+	LanguageCode *string `json:"language_code,omitempty"`
+}
+
+// Adjustment is a manual change to a user's balance and total spent. Both
+// are deltas; the balance delta is posted to the ledger.
+type Adjustment struct {
+	ID              int          `json:"id"`
+	UserID          int          `json:"user_id"`
+	BalanceDelta    money.Amount `json:"balance_delta"`
+	TotalSpentDelta money.Amount `json:"total_spent_delta"`
+	Reason          string       `json:"reason"`
+	CreatedBy       string       `json:"created_by"`
+	CreatedAt       time.Time    `json:"created_at"`
 }
@@ -0,0 +1,204 @@
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Scale is the number of minor units in one unit of a currency. Amounts are
+// kept to two decimal places whatever their currency.
+const Scale = 100
+
+// decimals is the number of decimal places Scale gives.
+const decimals = 2
+
+var ErrInvalid = errors.New("money: invalid amount")
+
+// Amount is a sum of money counted in minor units, so that adding and
+// comparing amounts is exact. It is written to JSON as a decimal number and
+// to SQL as a NUMERIC literal.
+type Amount int64
+
+// FromMinor returns the amount of the given number of minor units.
+func FromMinor(units int64) Amount {
+	return Amount(units)
+}
+
+// Parse reads a decimal string such as "12", "-0.5" or "12.34". Digits
+// beyond the second decimal place are only accepted when they are zeros.
+func Parse(s string) (Amount, error) {
+	units, err := parseDecimal(s, decimals)
+	return Amount(units), err
+}
+
+// parseDecimal reads a decimal string with an optional sign as a count of
+// units of 10^-places.
+func parseDecimal(s string, places int) (int64, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	if negative || strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+
+	whole, fraction, _ := strings.Cut(s, ".")
+	if whole == "" && fraction == "" {
+		return 0, ErrInvalid
+	}
+	if whole == "" {
+		whole = "0"
+	}
+
+	fraction = strings.TrimRight(fraction, "0")
+	if len(fraction) > places {
+		return 0, fmt.Errorf("%w: %q has more than %d decimal places", ErrInvalid, s, places)
+	}
+	fraction += strings.Repeat("0", places-len(fraction))
+
+	if !digits(whole) || !digits(fraction) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalid, s)
+	}
+
+	units, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q is out of range", ErrInvalid, s)
+	}
+	if negative {
+		units = -units
+	}
+	return units, nil
+}
+
+func digits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// MustParse is Parse for constants; it panics on an invalid amount.
+func MustParse(s string) Amount {
+	a, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// Minor returns the number of minor units in a.
+func (a Amount) Minor() int64 {
+	return int64(a)
+}
+
+// String formats a with exactly two decimal places.
+func (a Amount) String() string {
+	units := int64(a)
+	sign := ""
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, units/Scale, units%Scale)
+}
+
+// MulRate converts a at rate, rounding half away from zero to a minor unit.
+func (a Amount) MulRate(rate Rate) Amount {
+	return Amount(mulDiv(int64(a), int64(rate), RateScale))
+}
+
+// DivRate is the inverse of MulRate.
+func (a Amount) DivRate(rate Rate) Amount {
+	return Amount(mulDiv(int64(a), RateScale, int64(rate)))
+}
+
+// DivRateDown is DivRate rounded toward zero, so the result converted back
+// at rate is never worth more than a.
+func (a Amount) DivRateDown(rate Rate) Amount {
+	product := new(big.Int).Mul(big.NewInt(int64(a)), big.NewInt(RateScale))
+	return Amount(product.Quo(product, big.NewInt(int64(rate))).Int64())
+}
+
+// MulDiv returns a * num / den rounded half away from zero.
+func (a Amount) MulDiv(num, den int64) Amount {
+	return Amount(mulDiv(int64(a), num, den))
+}
+
+// mulDiv returns n * num / den rounded half away from zero. The product is
+// computed without overflow.
+func mulDiv(n, num, den int64) int64 {
+	product := new(big.Int).Mul(big.NewInt(n), big.NewInt(num))
+	divisor := big.NewInt(den)
+	quotient, remainder := new(big.Int).QuoRem(product, divisor, new(big.Int))
+
+	// Round away from zero when the remainder is at least half the divisor
+	if remainder.Abs(remainder).Lsh(remainder, 1).CmpAbs(divisor) >= 0 {
+		if product.Sign()*divisor.Sign() < 0 {
+			quotient.Sub(quotient, big.NewInt(1))
+		} else {
+			quotient.Add(quotient, big.NewInt(1))
+		}
+	}
+	return quotient.Int64()
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a string holding one.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	// JSON numbers may use an exponent, which Parse does not read
+	if strings.ContainsAny(s, "eE") {
+		return fmt.Errorf("%w: %s", ErrInvalid, s)
+	}
+
+	parsed, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// Value passes a to the database as a decimal literal.
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+// Scan reads a NUMERIC, integer or floating point column.
+func (a *Amount) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		parsed, err := Parse(string(v))
+		if err != nil {
+			return err
+		}
+		*a = parsed
+	case string:
+		parsed, err := Parse(v)
+		if err != nil {
+			return err
+		}
+		*a = parsed
+	case int64:
+		*a = Amount(v * Scale)
+	case float64:
+		*a = Amount(math.Round(v * Scale))
+	default:
+		return fmt.Errorf("money: cannot scan %T into Amount", src)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package money
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	valid := map[string]Amount{
+		"12":      1200,
+		"-0.5":    -50,
+		"+12.34":  1234,
+		".5":      50,
+		"1.2300":  123,
+		" 7.01 ":  701,
+		"-100.00": -10000,
+	}
+	for s, want := range valid {
+		if got, err := Parse(s); err != nil || got != want {
+			t.Errorf("Parse(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+
+	for _, s := range []string{"", "-", ".", "-+5", "+-5", "--5", "1.234", "1e3", "1,5", "12.3.4", "99999999999999999999"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestRateConversionIsExact(t *testing.T) {
+	rate, err := ParseRate("0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate.String() != "0.1" {
+		t.Fatalf("want 0.1, got %s", rate)
+	}
+
+	// 0.1 has no exact binary representation, so float conversion drifts
+	if got := MustParse("123456789.15").MulRate(rate); got != MustParse("12345678.92") {
+		t.Fatalf("want 12345678.92, got %v", got)
+	}
+	if got := MustParse("10.05").DivRate(rate); got != MustParse("100.50") {
+		t.Fatalf("want 100.50, got %v", got)
+	}
+
+	// Large rates do not overflow the intermediate product
+	large, err := ParseRate("15800")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := MustParse("1000000").MulRate(large); got != MustParse("15800000000") {
+		t.Fatalf("want 15800000000.00, got %v", got)
+	}
+}
+
+func TestDivRateDownIsNeverWorthMore(t *testing.T) {
+	rate, err := ParseRate("3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, base := range []string{"0.05", "0.02", "1", "100.01"} {
+		amount := MustParse(base)
+		down := amount.DivRateDown(rate)
+		if down.MulRate(rate) > amount {
+			t.Errorf("%s: %v is worth %v", base, down, down.MulRate(rate))
+		}
+	}
+	if got := MustParse("0.05").DivRateDown(rate); got != MustParse("0.01") {
+		t.Fatalf("want 0.01, got %v", got)
+	}
+}
+
+func TestMulDivRoundsHalfAwayFromZero(t *testing.T) {
+	tests := []struct {
+		amount   Amount
+		num, den int64
+		want     Amount
+	}{
+		{5, 1, 2, 3},
+		{-5, 1, 2, -3},
+		{5, -1, 2, -3},
+		{4, 1, 3, 1},
+		{math.MaxInt64, 3, 3, math.MaxInt64},
+	}
+	for _, tt := range tests {
+		if got := tt.amount.MulDiv(tt.num, tt.den); got != tt.want {
+			t.Errorf("%d * %d / %d = %d, want %d", tt.amount, tt.num, tt.den, got, tt.want)
+		}
+	}
+}
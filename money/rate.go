@@ -0,0 +1,101 @@
+package money
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// RateScale is the number of units in a rate of one. Rates are kept to ten
+// decimal places, which is what NUMERIC(18, 10) columns hold.
+const RateScale = 10_000_000_000
+
+// rateDecimals is the number of decimal places RateScale gives.
+const rateDecimals = 10
+
+// UnitRate is a rate of one, the rate of the base currency.
+const UnitRate Rate = RateScale
+
+// Rate is an exchange rate counted in units of 10^-10, so that converting an
+// amount at it is exact. Like Amount it is written to JSON as a decimal
+// number and to SQL as a NUMERIC literal.
+type Rate int64
+
+// ParseRate reads a decimal string such as "1", "0.92" or "0.0000393".
+func ParseRate(s string) (Rate, error) {
+	units, err := parseDecimal(s, rateDecimals)
+	return Rate(units), err
+}
+
+// String formats r without trailing zeros, keeping at least one decimal
+// place.
+func (r Rate) String() string {
+	units := int64(r)
+	sign := ""
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+	fraction := strings.TrimRight(fmt.Sprintf("%010d", units%RateScale), "0")
+	if fraction == "" {
+		fraction = "0"
+	}
+	return fmt.Sprintf("%s%d.%s", sign, units/RateScale, fraction)
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a string holding one.
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	if strings.ContainsAny(s, "eE") {
+		return fmt.Errorf("%w: %s", ErrInvalid, s)
+	}
+
+	parsed, err := ParseRate(s)
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+// Value passes r to the database as a decimal literal.
+func (r Rate) Value() (driver.Value, error) {
+	return r.String(), nil
+}
+
+// Scan reads a NUMERIC, integer or floating point column.
+func (r *Rate) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		parsed, err := ParseRate(string(v))
+		if err != nil {
+			return err
+		}
+		*r = parsed
+	case string:
+		parsed, err := ParseRate(v)
+		if err != nil {
+			return err
+		}
+		*r = parsed
+	case int64:
+		*r = Rate(v * RateScale)
+	case float64:
+		*r = Rate(math.Round(v * RateScale))
+	default:
+		return fmt.Errorf("money: cannot scan %T into Rate", src)
+	}
+	return nil
+}
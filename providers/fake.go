@@ -0,0 +1,72 @@
+package providers
+
+import (
+	"encoding/json"
+	"hvmnd/api/currency"
+	"hvmnd/api/money"
+	"net/http"
+)
+
+// FakeSignatureHeader carries the signature of a fake provider webhook.
+const FakeSignatureHeader = "X-Fake-Signature"
+
+// Fake is a local payment provider for development and tests. It signs its
+// webhooks with a shared secret and needs no network access.
+//
+// Its payload is {"id": "evt_1", "type": "payment.succeeded", "payment_id": 1,
+// "amount": 100, "currency": "USD"}. payment.succeeded completes the ticket,
+// payment.failed and payment.cancelled cancel it, and other types are
+// ignored.
+type Fake struct {
+	Secret []byte
+}
+
+func NewFake(secret string) *Fake {
+	return &Fake{Secret: []byte(secret)}
+}
+
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+func (f *Fake) ParseWebhook(header http.Header, body []byte) (Event, error) {
+	if !VerifySignature(f.Secret, body, header.Get(FakeSignatureHeader)) {
+		return Event{}, ErrInvalidSignature
+	}
+
+	var payload struct {
+		ID        string       `json:"id"`
+		Type      string       `json:"type"`
+		PaymentID int          `json:"payment_id"`
+		Amount    money.Amount `json:"amount"`
+		Currency  string       `json:"currency"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return Event{}, ErrMalformedEvent
+	}
+	if payload.ID == "" || payload.Type == "" || payload.PaymentID <= 0 {
+		return Event{}, ErrMalformedEvent
+	}
+
+	event := Event{
+		ID:        payload.ID,
+		Type:      payload.Type,
+		Action:    ActionIgnore,
+		PaymentID: payload.PaymentID,
+		Amount:    payload.Amount,
+		Currency:  currency.Normalize(payload.Currency),
+	}
+	switch payload.Type {
+	case "payment.succeeded":
+		event.Action = ActionComplete
+	case "payment.failed", "payment.cancelled":
+		event.Action = ActionCancel
+	}
+	return event, nil
+}
+
+// Sign returns the signature header value for body, for use by tests that
+// deliver fake webhooks.
+func (f *Fake) Sign(body []byte) string {
+	return Sign(f.Secret, body)
+}
@@ -0,0 +1,87 @@
+package providers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hvmnd/api/money"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrInvalidSignature = errors.New("providers: invalid webhook signature")
+	ErrMalformedEvent   = errors.New("providers: malformed webhook event")
+)
+
+// Action is what a webhook event asks to be done with a payment ticket.
+type Action string
+
+const (
+	// ActionComplete marks the ticket paid, as CompletePayment does.
+	ActionComplete Action = "complete"
+	// ActionCancel cancels the ticket unless it has been paid.
+	ActionCancel Action = "cancel"
+	// ActionIgnore acknowledges an event that does not affect the ticket.
+	ActionIgnore Action = "ignore"
+)
+
+// Event is a verified webhook event translated to our payment semantics.
+type Event struct {
+	ID        string // Provider-assigned id, unique per provider
+	Type      string // Provider-specific event type
+	Action    Action
+	PaymentID int
+	Amount    money.Amount // Amount the provider reports, 0 if it does not
+	Currency  string       // Currency of Amount, empty if the provider does not say
+}
+
+// PaymentProvider turns the webhook requests of one payment provider into
+// events.
+type PaymentProvider interface {
+	// Name is the {provider} path segment of the provider's webhook URL.
+	Name() string
+	// ParseWebhook verifies the signature of a webhook request and decodes
+	// the event it carries. It returns ErrInvalidSignature when the request
+	// was not signed by the provider and ErrMalformedEvent when the payload
+	// cannot be understood.
+	ParseWebhook(header http.Header, body []byte) (Event, error)
+}
+
+// Registry holds the providers the API accepts webhooks from, by name.
+type Registry map[string]PaymentProvider
+
+func NewRegistry(providers ...PaymentProvider) Registry {
+	registry := Registry{}
+	for _, provider := range providers {
+		registry[provider.Name()] = provider
+	}
+	return registry
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of payload under secret.
+func Sign(secret, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature reports whether signature is the HMAC-SHA256 of payload
+// under secret. The signature is hex encoded and may carry a "sha256="
+// prefix.
+func VerifySignature(secret, payload []byte, signature string) bool {
+	if len(secret) == 0 {
+		return false
+	}
+
+	signature = strings.TrimPrefix(strings.TrimSpace(signature), "sha256=")
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"hvmnd/api/currency"
+	"hvmnd/api/models"
+	"hvmnd/api/money"
+	"hvmnd/api/store"
+	"net/http"
+	"testing"
+)
+
+// foreignCurrency returns a currency other than the base one.
+func foreignCurrency() string {
+	if currency.Base() == "EUR" {
+		return "USD"
+	}
+	return "EUR"
+}
+
+// paidTicket opens a ticket for amount in code and completes it.
+func (s *testServer) paidTicket(userID int, amount, code string) int {
+	s.t.Helper()
+
+	resp := s.asAdmin("POST", "/api/v1/payments", fmt.Sprintf(`{"user_id": %d, "amount": %s, "currency": %q}`, userID, amount, code))
+	expectStatus(s.t, resp, http.StatusCreated)
+	var ticket struct {
+		ID int `json:"payment_ticket_id"`
+	}
+	resp.decode(s.t, &ticket)
+	expectStatus(s.t, s.asAdmin("PATCH", fmt.Sprintf("/api/v1/payments/complete/%d", ticket.ID), ""), http.StatusOK)
+	return ticket.ID
+}
+
+// refund asks for amount of the ticket back and returns the response.
+func (s *testServer) refund(ticketID int, amount string) response {
+	s.t.Helper()
+	return s.asAdmin("POST", fmt.Sprintf("/api/v1/payments/%d/refunds", ticketID), fmt.Sprintf(`{"amount": %s, "reason": "test"}`, amount))
+}
+
+// spend leaves the user with their balance lowered by amount.
+func (s *testServer) spend(userID int, amount string) {
+	s.t.Helper()
+	body := fmt.Sprintf(`{"balance_delta": -%s, "reason": "test"}`, amount)
+	expectStatus(s.t, s.asAdmin("POST", fmt.Sprintf("/api/v1/users/%d/adjustments", userID), body), http.StatusCreated)
+}
+
+func expectBalance(t *testing.T, s *testServer, userID int, want string) {
+	t.Helper()
+	balance, reconciled := s.ledger(userID)
+	if balance != money.MustParse(want) || !reconciled {
+		t.Fatalf("want a reconciled balance of %s, got %v (reconciled %v)", want, balance, reconciled)
+	}
+}
+
+func TestRefundsAddUpToTheTicket(t *testing.T) {
+	s := newTestServer(t)
+	userID := s.createUser(42)
+	ticketID := s.paidTicket(userID, "100", currency.Base())
+
+	expectStatus(t, s.refund(ticketID, "30"), http.StatusCreated)
+	expectStatus(t, s.refund(ticketID, "70.01"), http.StatusUnprocessableEntity)
+	expectStatus(t, s.refund(ticketID, "70"), http.StatusCreated)
+	expectStatus(t, s.refund(ticketID, "0.01"), http.StatusUnprocessableEntity)
+	expectBalance(t, s, userID, "0")
+
+	resp := s.asAdmin("GET", fmt.Sprintf("/api/v1/payments/%d/refunds", ticketID), "")
+	expectStatus(t, resp, http.StatusOK)
+	var refunds []models.Refund
+	resp.decode(t, &refunds)
+	if len(refunds) != 2 || refunds[0].Amount+refunds[1].Amount != money.MustParse("100") {
+		t.Fatalf("want two refunds of 100 in all, got %+v", refunds)
+	}
+}
+
+func TestCancellingAfterARefundTakesBackTheRest(t *testing.T) {
+	s := newTestServer(t)
+	userID := s.createUser(42)
+	ticketID := s.paidTicket(userID, "100", currency.Base())
+
+	expectStatus(t, s.refund(ticketID, "40"), http.StatusCreated)
+	expectStatus(t, s.asAdmin("PATCH", fmt.Sprintf("/api/v1/payments/cancel/%d", ticketID), ""), http.StatusOK)
+	expectBalance(t, s, userID, "0")
+}
+
+func TestOnlyPaidTicketsAreRefunded(t *testing.T) {
+	s := newTestServer(t)
+	userID := s.createUser(42)
+	s.fund(userID, "100")
+
+	unpaid := s.openTicket(userID, "50")
+	expectStatus(t, s.refund(unpaid, "10"), http.StatusConflict)
+
+	cancelled := s.paidTicket(userID, "50", currency.Base())
+	expectStatus(t, s.asAdmin("PATCH", fmt.Sprintf("/api/v1/payments/cancel/%d", cancelled), ""), http.StatusOK)
+	expectStatus(t, s.refund(cancelled, "10"), http.StatusConflict)
+
+	expectStatus(t, s.refund(999, "10"), http.StatusNotFound)
+	expectBalance(t, s, userID, "100")
+}
+
+func TestRefundPolicies(t *testing.T) {
+	tests := []struct {
+		policy  store.RefundPolicy
+		status  int
+		refund  string
+		balance string
+	}{
+		{store.RefundPolicyReject, http.StatusConflict, "", "20"},
+		{store.RefundPolicyClamp, http.StatusCreated, "20", "0"},
+		{store.RefundPolicyAllowNegative, http.StatusCreated, "50", "-30"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			s := newTestServerWithPolicy(t, tt.policy)
+			userID := s.createUser(42)
+			ticketID := s.paidTicket(userID, "100", currency.Base())
+			s.spend(userID, "80")
+
+			resp := s.refund(ticketID, "50")
+			expectStatus(t, resp, tt.status)
+			if tt.refund != "" {
+				var refund models.Refund
+				resp.decode(t, &refund)
+				if refund.Amount != money.MustParse(tt.refund) {
+					t.Fatalf("want %s refunded, got %v", tt.refund, refund.Amount)
+				}
+			}
+			expectBalance(t, s, userID, tt.balance)
+		})
+	}
+}
+
+func TestForeignRefundsUseTheTicketsRate(t *testing.T) {
+	s := newTestServer(t)
+	userID := s.createUser(42)
+	other := foreignCurrency()
+
+	setRate := func(rate string) {
+		body := fmt.Sprintf(`{"currency": %q, "rate": %s}`, other, rate)
+		expectStatus(t, s.asAdmin("POST", "/api/v1/exchange-rates", body), http.StatusCreated)
+	}
+	setRate("0.1")
+	ticketID := s.paidTicket(userID, "100", other)
+	expectBalance(t, s, userID, "10")
+
+	// The rate in effect now does not matter, the ticket keeps its own
+	setRate("0.5")
+	expectStatus(t, s.refund(ticketID, "50"), http.StatusCreated)
+	expectBalance(t, s, userID, "5")
+}
+
+func TestClampedRefundsAreNeverWorthMoreThanTheDebit(t *testing.T) {
+	s := newTestServerWithPolicy(t, store.RefundPolicyClamp)
+	userID := s.createUser(42)
+	other := foreignCurrency()
+
+	// One unit of the ticket's currency is worth 3 in the base currency
+	expectStatus(t, s.asAdmin("POST", "/api/v1/exchange-rates", fmt.Sprintf(`{"currency": %q, "rate": 3}`, other)), http.StatusCreated)
+	ticketID := s.paidTicket(userID, "10", other)
+	s.spend(userID, "29.95")
+
+	// 0.05 buys back 0.0166..., which is 0.01 of the ticket worth 0.03
+	resp := s.refund(ticketID, "10")
+	expectStatus(t, resp, http.StatusCreated)
+	var refund models.Refund
+	resp.decode(t, &refund)
+	if refund.Amount != money.MustParse("0.01") {
+		t.Fatalf("want 0.01 refunded, got %v", refund.Amount)
+	}
+	expectBalance(t, s, userID, "0.02")
+}
@@ -0,0 +1,154 @@
+package requestlog
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Header carries the request id from the client, if it has one, and back in
+// the response.
+const Header = "X-Request-ID"
+
+// maxIDLength bounds the request ids accepted from clients.
+const maxIDLength = 128
+
+type contextKey struct{}
+
+// request is what the access log learns about a request while it is served.
+type request struct {
+	id string
+
+	mu     sync.Mutex
+	caller string
+}
+
+// ID returns the id of the request ctx belongs to, or "" outside of a logged
+// request.
+func ID(ctx context.Context) string {
+	if req, ok := ctx.Value(contextKey{}).(*request); ok {
+		return req.id
+	}
+	return ""
+}
+
+// SetCaller names the caller of the request in its access log line. Outside
+// of a logged request it does nothing.
+func SetCaller(ctx context.Context, caller string) {
+	if req, ok := ctx.Value(contextKey{}).(*request); ok {
+		req.mu.Lock()
+		req.caller = caller
+		req.mu.Unlock()
+	}
+}
+
+// Logger returns the default logger with the request id of ctx attached.
+func Logger(ctx context.Context) *slog.Logger {
+	if id := ID(ctx); id != "" {
+		return slog.Default().With("request_id", id)
+	}
+	return slog.Default()
+}
+
+// Middleware writes one access log line per request, to the default logger
+// when Logger is nil.
+type Middleware struct {
+	Logger *slog.Logger
+}
+
+func New(logger *slog.Logger) *Middleware {
+	return &Middleware{Logger: logger}
+}
+
+// validID reports whether a client supplied id is short and plain enough to
+// be echoed back and logged.
+func validID(id string) bool {
+	if id == "" || len(id) > maxIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Wrap logs the method, path, status, latency and caller of every request to
+// next. The X-Request-ID of the request is kept if it is valid and a new one
+// is assigned otherwise; either way it is set on the response before next
+// runs, and is available to next through ID and Logger.
+func (m *Middleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(Header)
+		if !validID(id) {
+			id = newID()
+		}
+		w.Header().Set(Header, id)
+
+		req := &request{id: id, caller: "anonymous"}
+		recorder := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), contextKey{}, req)))
+
+		req.mu.Lock()
+		caller := req.caller
+		req.mu.Unlock()
+
+		logger := m.Logger
+		if logger == nil {
+			logger = slog.Default()
+		}
+		level := slog.LevelInfo
+		if recorder.statusCode >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logger.LogAttrs(r.Context(), level, "request",
+			slog.String("request_id", id),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", recorder.statusCode),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", recorder.bytes),
+			slog.String("caller", caller),
+		)
+	})
+}
+
+// statusRecorder passes a response through while remembering its status and
+// size.
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	bytes       int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(statusCode int) {
+	if !r.wroteHeader {
+		r.statusCode = statusCode
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
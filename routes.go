@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"hvmnd/api/audit"
+	"hvmnd/api/auth"
+	"hvmnd/api/handlers"
+	"hvmnd/api/idempotency"
+	"hvmnd/api/requestlog"
+	"hvmnd/api/store"
+	"net/http"
+	"strings"
+)
+
+type route struct {
+	pattern string
+	handler http.HandlerFunc
+	roles   []auth.Role // Roles allowed besides admin; nil means public
+}
+
+var (
+	public      []auth.Role
+	adminOnly   = []auth.Role{auth.RoleAdmin}
+	readers     = []auth.Role{auth.RoleBot, auth.RoleReadonly}
+	bot         = []auth.Role{auth.RoleBot}
+	paymentsOps = []auth.Role{auth.RoleBot, auth.RolePaymentProvider}
+	nodeAgents  = []auth.Role{auth.RoleNodeAgent}
+
+	// Telegram users are limited to their own records by the handlers
+	endUsers       = []auth.Role{auth.RoleTelegramUser}
+	botOrEndUsers  = []auth.Role{auth.RoleBot, auth.RoleTelegramUser}
+	readersOrUsers = []auth.Role{auth.RoleBot, auth.RoleReadonly, auth.RoleTelegramUser}
+)
+
+// routes is the permission table for every endpoint the API serves.
+func routes(h *handlers.Handler) []route {
+	return []route{
+		{"GET /api/v1/ping", h.Ping, public},
+
+		{"GET /api/v1/users", h.GetUsers, readersOrUsers},
+		{"GET /api/v1/users/{id}", h.GetUsers, readersOrUsers},
+		{"POST /api/v1/users", h.CreateOrUpdateUser, botOrEndUsers},
+		{"GET /api/v1/users/{id}/ledger", h.GetUserLedger, readersOrUsers},
+		{"POST /api/v1/users/{id}/adjustments", h.AdjustUser, adminOnly},
+
+		{"GET /api/v1/nodes", h.GetNodes, readers},
+		{"GET /api/v1/nodes/{id}", h.GetNodes, readers},
+		{"GET /api/v1/nodes/{id}/credentials", h.GetNodeCredentials, endUsers},
+		{"GET /api/v1/nodes/{id}/status-history", h.GetNodeStatusHistory, readers},
+		{"POST /api/v1/nodes", h.CreateNode, adminOnly},
+		{"PATCH /api/v1/nodes", h.UpdateNode, bot},
+		{"DELETE /api/v1/nodes/{id}", h.DecommissionNode, adminOnly},
+		{"POST /api/v1/nodes/heartbeat", h.NodeHeartbeat, nodeAgents},
+		{"POST /api/v1/nodes/{id}/rent", h.RentNode, botOrEndUsers},
+		{"POST /api/v1/nodes/{id}/release", h.ReleaseNode, botOrEndUsers},
+
+		{"GET /api/v1/rentals", h.GetRentals, readersOrUsers},
+
+		{"GET /api/v1/payments", h.GetPayments, append([]auth.Role{auth.RolePaymentProvider}, readersOrUsers...)},
+		{"GET /api/v1/payments/{id}", h.GetPayments, append([]auth.Role{auth.RolePaymentProvider}, readersOrUsers...)},
+		{"POST /api/v1/payments", h.CreatePaymentTicket, botOrEndUsers},
+		{"PATCH /api/v1/payments/complete/{id}", h.CompletePayment, paymentsOps},
+		{"PATCH /api/v1/payments/cancel/{id}", h.CancelPayment, paymentsOps},
+		{"POST /api/v1/payments/webhook/{provider}", h.PaymentWebhook, public}, // Authenticated by the provider's signature
+		{"GET /api/v1/payments/{id}/refunds", h.GetRefunds, append([]auth.Role{auth.RolePaymentProvider}, readersOrUsers...)},
+		// POST /api/v1/payments/{id}/refunds would conflict with the webhook
+		// route on /payments/webhook/refunds, so it is matched through a
+		// wildcard that the webhook route is more specific than.
+		{"POST /api/v1/payments/{id}/{collection}", only("collection", "refunds", h.RefundPayment), paymentsOps},
+
+		{"GET /api/v1/exchange-rates", h.GetExchangeRates, append([]auth.Role{auth.RolePaymentProvider}, readers...)},
+		{"POST /api/v1/exchange-rates", h.SetExchangeRate, adminOnly},
+
+		{"POST /api/v1/quiz/save-hash", h.SaveHashMapping, bot},
+		{"GET /api/v1/quiz/get-question-answer", h.GetQuestionAnswerByHash, readers},
+		{"POST /api/v1/quiz/save-answer", h.SaveUserAnswer, botOrEndUsers},
+
+		{"POST /api/v1/admin/users/{id}/ban", h.BanUser, adminOnly},
+		{"DELETE /api/v1/admin/users/{id}/ban", h.UnbanUser, adminOnly},
+
+		{"GET /api/v1/audit", h.GetAudit, adminOnly},
+
+		{"GET /api/v1/admin/api-keys", h.GetAPIKeys, adminOnly},
+		{"POST /api/v1/admin/api-keys", h.IssueAPIKey, adminOnly},
+		{"DELETE /api/v1/admin/api-keys/{id}", h.RevokeAPIKey, adminOnly},
+	}
+}
+
+// unaudited are the mutating routes left out of the audit log. Heartbeats
+// are telemetry; the status changes they cause are in the node status
+// history.
+var unaudited = map[string]bool{
+	"POST /api/v1/nodes/heartbeat": true,
+}
+
+// unreplayable are the POST and PATCH routes that ignore the Idempotency-Key
+// header. Their responses carry secrets that must not be stored.
+var unreplayable = map[string]bool{
+	"POST /api/v1/admin/api-keys": true,
+}
+
+// registerRoutes wraps every route with its authorization check and mounts it
+// on mux. The changes mutating routes make are audited, and POST and PATCH
+// routes also honor the Idempotency-Key header.
+func registerRoutes(mux *http.ServeMux, h *handlers.Handler, authenticator *auth.Authenticator, idem *idempotency.Middleware) {
+	for _, route := range routes(h) {
+		handler := route.handler
+		method, _, _ := strings.Cut(route.pattern, " ")
+		if method != http.MethodGet && !unaudited[route.pattern] {
+			handler = audit.Wrap(handler)
+		}
+		if (method == http.MethodPost || method == http.MethodPatch) && !unreplayable[route.pattern] {
+			handler = idem.Wrap(principalName)(handler)
+		}
+		mux.HandleFunc(route.pattern, authenticator.Require(route.roles...)(withActor(handler)))
+	}
+}
+
+// only serves next when the path wildcard name equals value and answers 404
+// otherwise.
+func only(name, value string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue(name) != value {
+			handlers.NotFound(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// principalName identifies the API key or Telegram user that made a request,
+// or "anonymous" on public routes.
+func principalName(r *http.Request) string {
+	if principal, ok := auth.FromContext(r.Context()); ok {
+		if principal.Role == auth.RoleTelegramUser {
+			return principal.Name
+		}
+		return fmt.Sprintf("api-key:%d:%s", principal.KeyID, principal.Name)
+	}
+	return "anonymous"
+}
+
+// withActor attributes the changes a request makes, and its access log line,
+// to the API key that made it.
+func withActor(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := auth.FromContext(r.Context()); ok {
+			requestlog.SetCaller(r.Context(), principalName(r))
+			r = r.WithContext(store.WithActor(r.Context(), principalName(r)))
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,594 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hvmnd/api/auth"
+	"hvmnd/api/currency"
+	"hvmnd/api/expiry"
+	"hvmnd/api/models"
+	"hvmnd/api/money"
+	"hvmnd/api/store"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPaymentCompletionCreditsOnce(t *testing.T) {
+	s := newTestServer(t)
+	userID := s.createUser(42)
+
+	resp := s.asAdmin("POST", "/api/v1/payments", fmt.Sprintf(`{"user_id": %d, "amount": 100}`, userID))
+	expectStatus(t, resp, http.StatusCreated)
+	var ticket struct {
+		ID int `json:"payment_ticket_id"`
+	}
+	resp.decode(t, &ticket)
+
+	path := fmt.Sprintf("/api/v1/payments/complete/%d", ticket.ID)
+	expectStatus(t, s.asAdmin("PATCH", path, ""), http.StatusOK)
+	s.asAdmin("PATCH", path, "")
+
+	balance, reconciled := s.ledger(userID)
+	if balance != money.MustParse("100") || !reconciled {
+		t.Fatalf("want a reconciled balance of 100, got %v (reconciled %v)", balance, reconciled)
+	}
+}
+
+func TestTheSweeperExpiresOnlyStaleUnpaidTickets(t *testing.T) {
+	s := newTestServer(t)
+	userID := s.createUser(42)
+	now := time.Now()
+
+	open := func(createdAt time.Time) int {
+		t.Helper()
+		id, err := s.store.CreatePayment(context.Background(), userID, money.MustParse("10"), currency.Base(), createdAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	stale := open(now.Add(-2 * time.Hour))
+	paid := open(now.Add(-2 * time.Hour))
+	expectStatus(t, s.asAdmin("PATCH", fmt.Sprintf("/api/v1/payments/complete/%d", paid), ""), http.StatusOK)
+	recent := open(now.Add(-30 * time.Minute))
+
+	expiry.NewSweeper(s.store, time.Hour).RunOnce(now)
+
+	want := map[int]string{stale: "expired", paid: "paid", recent: "unpaid"}
+	for id, status := range want {
+		resp := s.asAdmin("GET", fmt.Sprintf("/api/v1/payments?id=%d", id), "")
+		expectStatus(t, resp, http.StatusOK)
+		var tickets []models.Payment
+		resp.decode(t, &tickets)
+		if len(tickets) != 1 || tickets[0].Status != status {
+			t.Errorf("ticket %d: want %s, got %+v", id, status, tickets)
+		}
+	}
+
+	// Only an admin may still complete the expired ticket, and only on purpose
+	botKey := s.issueKey(auth.RoleBot)
+	path := fmt.Sprintf("/api/v1/payments/complete/%d", stale)
+	expectStatus(t, s.asKey(botKey, "PATCH", path, ""), http.StatusConflict)
+	expectStatus(t, s.asKey(botKey, "PATCH", path+"?force=true", ""), http.StatusForbidden)
+	expectStatus(t, s.asAdmin("PATCH", path, ""), http.StatusConflict)
+	expectBalance(t, s, userID, "10")
+	expectStatus(t, s.asAdmin("PATCH", path+"?force=true", ""), http.StatusOK)
+	expectBalance(t, s, userID, "20")
+}
+
+func TestRentBillAndRelease(t *testing.T) {
+	s := newTestServer(t)
+	userID := s.createUser(42)
+	s.fund(userID, "100")
+	node := s.addNode("60", "secret")
+
+	rentPath := fmt.Sprintf("/api/v1/nodes/%d/rent", node.ID)
+	expectStatus(t, s.asAdmin("POST", rentPath, fmt.Sprintf(`{"user_id": %d}`, userID)), http.StatusOK)
+	expectStatus(t, s.asAdmin("POST", rentPath, fmt.Sprintf(`{"user_id": %d}`, userID)), http.StatusConflict)
+
+	// 90 minutes at 60 an hour
+	if _, err := s.store.BillNode(context.Background(), node.ID, time.Now().Add(90*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, s.asAdmin("POST", fmt.Sprintf("/api/v1/nodes/%d/release", node.ID), fmt.Sprintf(`{"user_id": %d}`, userID)), http.StatusOK)
+
+	balance, reconciled := s.ledger(userID)
+	if balance != money.MustParse("10") || !reconciled {
+		t.Fatalf("want a reconciled balance of 10, got %v (reconciled %v)", balance, reconciled)
+	}
+
+	resp := s.asAdmin("GET", fmt.Sprintf("/api/v1/rentals?user_id=%d", userID), "")
+	expectStatus(t, resp, http.StatusOK)
+	var rentals []models.Rental
+	resp.decode(t, &rentals)
+	if len(rentals) != 1 || rentals[0].TotalCharged != money.MustParse("90") {
+		t.Fatalf("want one rental charged 90, got %+v", rentals)
+	}
+}
+
+func TestNodeCredentialsOnlyForRenter(t *testing.T) {
+	s := newTestServer(t)
+	renterID := s.createUser(42)
+	s.createUser(7)
+	s.fund(renterID, "100")
+	node := s.addNode("10", "hunter2")
+	botKey := s.issueKey(auth.RoleBot)
+
+	expectStatus(t, s.asTelegram(42, "POST", fmt.Sprintf("/api/v1/nodes/%d/rent", node.ID), `{}`), http.StatusOK)
+
+	path := fmt.Sprintf("/api/v1/nodes/%d/credentials", node.ID)
+	resp := s.asTelegram(42, "GET", path, "")
+	expectStatus(t, resp, http.StatusOK)
+	var credentials models.NodeCredentials
+	resp.decode(t, &credentials)
+	if credentials.AnyDeskPassword != "hunter2" {
+		t.Fatalf("want the decrypted password, got %q", credentials.AnyDeskPassword)
+	}
+
+	expectStatus(t, s.asTelegram(7, "GET", path, ""), http.StatusForbidden)
+	expectStatus(t, s.asKey(botKey, "GET", fmt.Sprintf("%s?user_id=%d", path, renterID), ""), http.StatusForbidden)
+	expectStatus(t, s.asAdmin("GET", path, ""), http.StatusOK)
+}
+
+func TestTelegramUsersOnlySeeTheirOwnRecords(t *testing.T) {
+	s := newTestServer(t)
+	s.createUser(42)
+	otherID := s.createUser(7)
+
+	expectStatus(t, s.asTelegram(42, "GET", "/api/v1/users", ""), http.StatusOK)
+	expectStatus(t, s.asTelegram(42, "GET", "/api/v1/users?telegram_id=7", ""), http.StatusForbidden)
+	expectStatus(t, s.asTelegram(42, "GET", fmt.Sprintf("/api/v1/users/%d/ledger", otherID), ""), http.StatusForbidden)
+	expectStatus(t, s.asTelegram(42, "GET", "/api/v1/audit", ""), http.StatusForbidden)
+
+	expired := s.do("GET", "/api/v1/users", "", http.Header{"Authorization": {"tma " + signInitData(42, time.Now().Add(-48*time.Hour))}})
+	expectStatus(t, expired, http.StatusUnauthorized)
+}
+
+func TestBanReleasesRentedNodes(t *testing.T) {
+	s := newTestServer(t)
+	userID := s.createUser(42)
+	s.fund(userID, "100")
+	node := s.addNode("10", "secret")
+	expectStatus(t, s.asAdmin("POST", fmt.Sprintf("/api/v1/nodes/%d/rent", node.ID), fmt.Sprintf(`{"user_id": %d}`, userID)), http.StatusOK)
+
+	banPath := fmt.Sprintf("/api/v1/admin/users/%d/ban", userID)
+	expectStatus(t, s.asAdmin("POST", banPath, `{}`), http.StatusBadRequest)
+	resp := s.asAdmin("POST", banPath, `{"reason": "fraud"}`)
+	expectStatus(t, resp, http.StatusOK)
+	var banned struct {
+		ReleasedNodes []int `json:"released_nodes"`
+	}
+	resp.decode(t, &banned)
+	if len(banned.ReleasedNodes) != 1 || banned.ReleasedNodes[0] != node.ID {
+		t.Fatalf("want node %d released, got %v", node.ID, banned.ReleasedNodes)
+	}
+
+	expectStatus(t, s.asTelegram(42, "POST", "/api/v1/payments", `{"amount": 5}`), http.StatusForbidden)
+	expectStatus(t, s.asAdmin("DELETE", banPath, ""), http.StatusOK)
+	expectStatus(t, s.asTelegram(42, "POST", "/api/v1/payments", `{"amount": 5}`), http.StatusCreated)
+}
+
+func TestAdjustmentsChangeTheBalance(t *testing.T) {
+	s := newTestServer(t)
+	userID := s.createUser(42)
+	path := fmt.Sprintf("/api/v1/users/%d/adjustments", userID)
+
+	expectStatus(t, s.asAdmin("POST", "/api/v1/users", `{"telegram_id": 42, "balance": 1000}`), http.StatusBadRequest)
+	expectStatus(t, s.asAdmin("POST", path, `{"balance_delta": 10}`), http.StatusBadRequest)
+	expectStatus(t, s.asTelegram(42, "POST", path, `{"balance_delta": 10, "reason": "x"}`), http.StatusForbidden)
+	expectStatus(t, s.asAdmin("POST", path, `{"balance_delta": 10.5, "reason": "goodwill"}`), http.StatusCreated)
+
+	balance, reconciled := s.ledger(userID)
+	if balance != money.MustParse("10.50") || !reconciled {
+		t.Fatalf("want a reconciled balance of 10.50, got %v (reconciled %v)", balance, reconciled)
+	}
+}
+
+func TestRequestIDs(t *testing.T) {
+	s := newTestServer(t)
+
+	resp := s.do("GET", "/api/v1/users", "", http.Header{"Authorization": {"Bearer " + testAdminKey}, "X-Request-Id": {"abc-123"}})
+	if resp.header.Get("X-Request-ID") != "abc-123" || resp.RequestID != "abc-123" {
+		t.Fatalf("want the request id propagated, got header %q and body %q", resp.header.Get("X-Request-ID"), resp.RequestID)
+	}
+
+	resp = s.do("GET", "/api/v1/users", "", http.Header{"X-Request-Id": {"not valid!"}})
+	expectStatus(t, resp, http.StatusUnauthorized)
+	if id := resp.header.Get("X-Request-ID"); id == "" || id == "not valid!" || resp.RequestID != id {
+		t.Fatalf("want a fresh request id, got header %q and body %q", id, resp.RequestID)
+	}
+}
+
+func TestErrorsAreJSONWithTheRequestID(t *testing.T) {
+	s := newTestServer(t)
+	botKey := s.issueKey(auth.RoleBot)
+
+	tests := []struct {
+		key, method, path, body string
+		status                  int
+	}{
+		{testAdminKey, "POST", "/api/v1/users", `{`, http.StatusBadRequest},
+		{testAdminKey, "POST", "/api/v1/nodes", `{`, http.StatusBadRequest},
+		{botKey, "PATCH", "/api/v1/nodes", `{"price": 5}`, http.StatusBadRequest},
+		{testAdminKey, "POST", "/api/v1/exchange-rates", `[]`, http.StatusBadRequest},
+		{botKey, "POST", "/api/v1/quiz/save-hash", `{`, http.StatusBadRequest},
+		{testAdminKey, "GET", "/api/v1/quiz/get-question-answer", "", http.StatusBadRequest},
+		{testAdminKey, "GET", "/api/v1/quiz/get-question-answer?hash=missing", "", http.StatusNotFound},
+		{testAdminKey, "POST", "/api/v1/payments/1/other", `{}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		// do fails the test on a body that is not JSON
+		resp := s.asKey(tt.key, tt.method, tt.path, tt.body)
+		expectStatus(t, resp, tt.status)
+		if resp.Success || resp.Error == "" || resp.RequestID == "" || resp.RequestID != resp.header.Get("X-Request-ID") {
+			t.Errorf("%s %s: want an error with the request id, got %+v", tt.method, tt.path, resp)
+		}
+	}
+}
+
+func TestListsAreUnpaginatedWithoutALimit(t *testing.T) {
+	s := newTestServer(t)
+	for telegramID := 1; telegramID <= store.DefaultPageLimit+1; telegramID++ {
+		if _, err := s.store.UpsertUser(context.Background(), models.UserInput{TelegramID: telegramID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var users []models.User
+	resp := s.asAdmin("GET", "/api/v1/users", "")
+	expectStatus(t, resp, http.StatusOK)
+	resp.decode(t, &users)
+	if len(users) != store.DefaultPageLimit+1 {
+		t.Fatalf("want every user, got %d", len(users))
+	}
+
+	resp = s.asAdmin("GET", "/api/v1/users?limit=2&sort=balance", "")
+	expectStatus(t, resp, http.StatusOK)
+	resp.decode(t, &users)
+	if len(users) != 2 || resp.NextCursor == "" {
+		t.Fatalf("want 2 users and a next cursor, got %d and %q", len(users), resp.NextCursor)
+	}
+
+	bad := store.Cursor{Sort: "balance", Value: "abc", ID: 1}.Encode()
+	expectStatus(t, s.asAdmin("GET", "/api/v1/users?sort=balance&cursor="+bad, ""), http.StatusBadRequest)
+	expectStatus(t, s.asAdmin("GET", "/api/v1/users?cursor=%25%25", ""), http.StatusBadRequest)
+}
+
+func TestNodesAreFilteredBySpecs(t *testing.T) {
+	s := newTestServer(t)
+	spec := func(gpu string, vram, cores, ram, storage int32, price string, catalog ...models.NodeSoftware) int {
+		return s.store.AddNode(models.Node{
+			AnyDeskAddress:  "anydesk-" + gpu,
+			Price:           money.MustParse(price),
+			GPUModel:        sql.NullString{String: gpu, Valid: true},
+			VRAMGB:          sql.NullInt32{Int32: vram, Valid: true},
+			CPUCores:        sql.NullInt32{Int32: cores, Valid: true},
+			RAMGB:           sql.NullInt32{Int32: ram, Valid: true},
+			StorageGB:       sql.NullInt32{Int32: storage, Valid: true},
+			SoftwareCatalog: catalog,
+		}).ID
+	}
+	blender := models.NodeSoftware{Name: "Blender", Kind: models.SoftwareKindSoftware}
+	windows := models.NodeSoftware{Name: "Windows Pro", Kind: models.SoftwareKindLicense}
+	big := spec("NVIDIA RTX 4090", 24, 16, 64, 2000, "30", blender, windows)
+	small := spec("NVIDIA GTX 1660", 6, 4, 16, 500, "5", blender)
+
+	list := func(query string) []int {
+		t.Helper()
+		resp := s.asAdmin("GET", "/api/v1/nodes?sort=id&"+query, "")
+		if resp.status == http.StatusNotFound {
+			return nil
+		}
+		expectStatus(t, resp, http.StatusOK)
+		var nodes []struct {
+			ID int `json:"id"`
+		}
+		resp.decode(t, &nodes)
+		var ids []int
+		for _, node := range nodes {
+			ids = append(ids, node.ID)
+		}
+		return ids
+	}
+
+	tests := map[string][]int{
+		"gpu_model=rtx":                  {big},
+		"gpu_model=nvidia":               {big, small},
+		"min_vram=8":                     {big},
+		"min_cpu_cores=4":                {big, small},
+		"min_ram=32":                     {big},
+		"min_storage=1000":               {big},
+		"max_price=10":                   {small},
+		"software=blender":               {big, small},
+		"software=blender,windows%20pro": {big},
+		"software=blender&software=windows%20pro": {big},
+		"min_vram=8&max_price=10":                 nil,
+	}
+	for query, want := range tests {
+		if got := list(query); !slices.Equal(got, want) {
+			t.Errorf("%s: want %v, got %v", query, want, got)
+		}
+	}
+
+	for _, query := range []string{"min_vram=-1", "min_ram=lots", "max_price=0", "max_price=cheap", "status=broken"} {
+		expectStatus(t, s.asAdmin("GET", "/api/v1/nodes?"+query, ""), http.StatusBadRequest)
+	}
+}
+
+func TestMachineIDsAreUniqueAmongNodesInService(t *testing.T) {
+	s := newTestServer(t)
+	register := func(address, machineID string) response {
+		body := fmt.Sprintf(`{"any_desk_address": %q, "any_desk_password": "secret", "price": 10, "machine_id": %q}`, address, machineID)
+		return s.asAdmin("POST", "/api/v1/nodes", body)
+	}
+
+	resp := register("111", "machine-a")
+	expectStatus(t, resp, http.StatusCreated)
+	var first struct{ ID int }
+	resp.decode(t, &first)
+	resp = register("222", "machine-b")
+	expectStatus(t, resp, http.StatusCreated)
+	var second struct{ ID int }
+	resp.decode(t, &second)
+
+	expectStatus(t, register("333", "machine-a"), http.StatusConflict)
+	expectStatus(t, s.asAdmin("PATCH", "/api/v1/nodes", fmt.Sprintf(`{"id": %d, "machine_id": "machine-a"}`, second.ID)), http.StatusConflict)
+
+	expectStatus(t, s.asAdmin("DELETE", fmt.Sprintf("/api/v1/nodes/%d", first.ID), ""), http.StatusOK)
+	expectStatus(t, register("333", "machine-a"), http.StatusCreated)
+}
+
+func TestAgentKeysOnlyReportTheirOwnMachine(t *testing.T) {
+	s := newTestServer(t)
+	register := func(address, machineID string) {
+		body := fmt.Sprintf(`{"any_desk_address": %q, "any_desk_password": "secret", "price": 10, "machine_id": %q}`, address, machineID)
+		expectStatus(t, s.asAdmin("POST", "/api/v1/nodes", body), http.StatusCreated)
+	}
+	register("111", "machine-a")
+	register("222", "machine-b")
+
+	expectStatus(t, s.asAdmin("POST", "/api/v1/admin/api-keys", `{"name": "agent", "role": "node-agent"}`), http.StatusBadRequest)
+	expectStatus(t, s.asAdmin("POST", "/api/v1/admin/api-keys", `{"name": "bot", "role": "bot", "machine_id": "machine-a"}`), http.StatusBadRequest)
+	agentKey := s.issueAgentKey("machine-a")
+
+	heartbeat := func(machineID, address string) string {
+		return fmt.Sprintf(`{"machine_id": %q, "any_desk_address": %q, "uptime_seconds": 60}`, machineID, address)
+	}
+	expectStatus(t, s.asKey(agentKey, "POST", "/api/v1/nodes/heartbeat", heartbeat("machine-a", "111")), http.StatusOK)
+	expectStatus(t, s.asKey(agentKey, "POST", "/api/v1/nodes/heartbeat", heartbeat("machine-b", "999")), http.StatusForbidden)
+
+	resp := s.asAdmin("GET", "/api/v1/nodes", "")
+	expectStatus(t, resp, http.StatusOK)
+	var nodes []struct {
+		AnyDeskAddress string `json:"any_desk_address"`
+	}
+	resp.decode(t, &nodes)
+	for _, node := range nodes {
+		if node.AnyDeskAddress == "999" {
+			t.Fatal("want the other machine's AnyDesk address left alone")
+		}
+	}
+}
+
+func TestRetriesAreReplayed(t *testing.T) {
+	s := newTestServer(t)
+	userID := s.createUser(42)
+	body := fmt.Sprintf(`{"user_id": %d, "amount": 100}`, userID)
+	header := http.Header{"Authorization": {"Bearer " + testAdminKey}, "Idempotency-Key": {"ticket-1"}}
+
+	first := s.do("POST", "/api/v1/payments", body, header)
+	expectStatus(t, first, http.StatusCreated)
+	if first.header.Get("Idempotent-Replayed") != "" {
+		t.Fatal("want the first response served fresh")
+	}
+
+	retry := s.do("POST", "/api/v1/payments", body, header)
+	expectStatus(t, retry, http.StatusCreated)
+	if retry.header.Get("Idempotent-Replayed") != "true" || string(retry.Data) != string(first.Data) {
+		t.Fatalf("want the stored response replayed, got %s (replayed %q)", retry.Data, retry.header.Get("Idempotent-Replayed"))
+	}
+
+	resp := s.asAdmin("GET", fmt.Sprintf("/api/v1/payments?user_id=%d", userID), "")
+	expectStatus(t, resp, http.StatusOK)
+	var tickets []models.Payment
+	resp.decode(t, &tickets)
+	if len(tickets) != 1 {
+		t.Fatalf("want one ticket opened, got %d", len(tickets))
+	}
+}
+
+func TestKeysAreNotReusedForOtherRequests(t *testing.T) {
+	s := newTestServer(t)
+	userID := s.createUser(42)
+	header := http.Header{"Authorization": {"Bearer " + testAdminKey}, "Idempotency-Key": {"ticket-1"}}
+
+	expectStatus(t, s.do("POST", "/api/v1/payments", fmt.Sprintf(`{"user_id": %d, "amount": 100}`, userID), header), http.StatusCreated)
+	expectStatus(t, s.do("POST", "/api/v1/payments", fmt.Sprintf(`{"user_id": %d, "amount": 200}`, userID), header), http.StatusUnprocessableEntity)
+
+	// Keys belong to the caller, so another key may use the same one
+	header.Set("Authorization", "Bearer "+s.issueKey(auth.RoleBot))
+	expectStatus(t, s.do("POST", "/api/v1/payments", fmt.Sprintf(`{"user_id": %d, "amount": 200}`, userID), header), http.StatusCreated)
+}
+
+func TestIssuedKeysAreNeverReplayed(t *testing.T) {
+	s := newTestServer(t)
+	header := http.Header{"Authorization": {"Bearer " + testAdminKey}, "Idempotency-Key": {"issue-1"}}
+
+	issue := func() string {
+		resp := s.do("POST", "/api/v1/admin/api-keys", `{"name": "bot", "role": "bot"}`, header)
+		expectStatus(t, resp, http.StatusCreated)
+		var issued struct {
+			Key string `json:"key"`
+		}
+		resp.decode(t, &issued)
+		return issued.Key
+	}
+	if first, second := issue(), issue(); first == second {
+		t.Fatal("want a new key for every request, got the stored response replayed")
+	}
+}
+
+func TestForeignTicketsAreConvertedExactly(t *testing.T) {
+	s := newTestServer(t)
+	userID := s.createUser(42)
+
+	other := "EUR"
+	if currency.Base() == other {
+		other = "USD"
+	}
+	expectStatus(t, s.asAdmin("POST", "/api/v1/exchange-rates", fmt.Sprintf(`{"currency": %q, "rate": 0.1}`, other)), http.StatusCreated)
+
+	resp := s.asAdmin("POST", "/api/v1/payments", fmt.Sprintf(`{"user_id": %d, "amount": 123456789.15, "currency": %q}`, userID, other))
+	expectStatus(t, resp, http.StatusCreated)
+	var ticket struct {
+		ID int `json:"payment_ticket_id"`
+	}
+	resp.decode(t, &ticket)
+	expectStatus(t, s.asAdmin("PATCH", fmt.Sprintf("/api/v1/payments/complete/%d", ticket.ID), ""), http.StatusOK)
+
+	balance, reconciled := s.ledger(userID)
+	if balance != money.MustParse("12345678.92") || !reconciled {
+		t.Fatalf("want a reconciled balance of 12345678.92, got %v (reconciled %v)", balance, reconciled)
+	}
+}
+
+func TestBansOnlyGoThroughTheBanEndpoints(t *testing.T) {
+	s := newTestServer(t)
+	userID := s.createUser(42)
+	botKey := s.issueKey(auth.RoleBot)
+
+	for _, body := range []string{`{"telegram_id": 42, "banned": true}`, `{"telegram_id": 42, "banned": false}`, `{"telegram_id": 42, "ban_reason": "x"}`} {
+		expectStatus(t, s.asKey(botKey, "POST", "/api/v1/users", body), http.StatusBadRequest)
+		expectStatus(t, s.asAdmin("POST", "/api/v1/users", body), http.StatusBadRequest)
+	}
+	expectStatus(t, s.asTelegram(42, "POST", "/api/v1/users", `{"banned": false}`), http.StatusBadRequest)
+
+	expectStatus(t, s.asAdmin("POST", fmt.Sprintf("/api/v1/admin/users/%d/ban", userID), `{"reason": "fraud"}`), http.StatusOK)
+	resp := s.asKey(botKey, "POST", "/api/v1/users", `{"telegram_id": 42, "username": "renamed"}`)
+	expectStatus(t, resp, http.StatusOK)
+	var user struct {
+		Banned    bool   `json:"banned"`
+		BanReason string `json:"ban_reason"`
+	}
+	resp.decode(t, &user)
+	if !user.Banned || user.BanReason != "fraud" {
+		t.Fatalf("want the ban and its reason kept, got %+v", user)
+	}
+}
+
+func TestBansAuditEveryRowTheyChange(t *testing.T) {
+	s := newTestServer(t)
+	userID := s.createUser(42)
+	s.fund(userID, "100")
+	node := s.addNode("10", "secret")
+	expectStatus(t, s.asAdmin("POST", fmt.Sprintf("/api/v1/nodes/%d/rent", node.ID), fmt.Sprintf(`{"user_id": %d}`, userID)), http.StatusOK)
+
+	banned := func() []models.AuditEntry {
+		resp := s.asAdmin("GET", "/api/v1/audit?limit=100&route="+url.QueryEscape("POST /api/v1/admin/users/{id}/ban"), "")
+		expectStatus(t, resp, http.StatusOK)
+		var entries []models.AuditEntry
+		resp.decode(t, &entries)
+		return entries
+	}
+
+	expectStatus(t, s.asAdmin("POST", "/api/v1/admin/users/999/ban", `{"reason": "fraud"}`), http.StatusNotFound)
+	expectStatus(t, s.asAdmin("POST", fmt.Sprintf("/api/v1/admin/users/%d/ban", userID), `{"reason": "fraud"}`), http.StatusOK)
+
+	changed := map[string]models.AuditEntry{}
+	for _, entry := range banned() {
+		if !strings.HasPrefix(entry.Actor, "api-key:") {
+			t.Errorf("want the admin key as the actor, got %q", entry.Actor)
+		}
+		changed[entry.EntityType+" "+entry.EntityID] = entry
+	}
+	user, ok := changed[fmt.Sprintf("user %d", userID)]
+	if !ok || !strings.Contains(string(user.After), `"banned":true`) || !strings.Contains(string(user.Before), `"banned":false`) {
+		t.Fatalf("want the ban recorded on the user, got %v", changed)
+	}
+	if _, ok := changed[fmt.Sprintf("node %d", node.ID)]; !ok {
+		t.Fatalf("want the released node recorded, got %v", changed)
+	}
+	if _, ok := changed["rental 1"]; !ok {
+		t.Fatalf("want the ended rental recorded, got %v", changed)
+	}
+}
+
+func TestRentedNodesOnlyLeaveThroughRelease(t *testing.T) {
+	s := newTestServer(t)
+	botKey := s.issueKey(auth.RoleBot)
+	userID := s.createUser(42)
+	s.fund(userID, "100")
+	node := s.addNode("10", "secret")
+	expectStatus(t, s.asAdmin("POST", fmt.Sprintf("/api/v1/nodes/%d/rent", node.ID), fmt.Sprintf(`{"user_id": %d}`, userID)), http.StatusOK)
+
+	for _, status := range []models.NodeStatus{models.NodeStatusAvailable, models.NodeStatusMaintenance, models.NodeStatusOffline} {
+		resp := s.asKey(botKey, "PATCH", "/api/v1/nodes", fmt.Sprintf(`{"id": %d, "status": %q}`, node.ID, status))
+		expectStatus(t, resp, http.StatusConflict)
+	}
+
+	resp := s.asAdmin("GET", fmt.Sprintf("/api/v1/nodes/%d", node.ID), "")
+	expectStatus(t, resp, http.StatusOK)
+	var nodes []struct {
+		Status models.NodeStatus `json:"status"`
+		Renter *int              `json:"renter"`
+	}
+	resp.decode(t, &nodes)
+	if len(nodes) != 1 || nodes[0].Status != models.NodeStatusRented || nodes[0].Renter == nil || *nodes[0].Renter != userID {
+		t.Fatalf("want the node still rented by %d, got %+v", userID, nodes)
+	}
+
+	expectStatus(t, s.asAdmin("POST", fmt.Sprintf("/api/v1/nodes/%d/rent", node.ID), `{"user_id": 999}`), http.StatusConflict)
+}
+
+func TestEveryStatusChangeIsInTheHistory(t *testing.T) {
+	s := newTestServer(t)
+	botKey := s.issueKey(auth.RoleBot)
+	userID := s.createUser(42)
+	s.fund(userID, "1000")
+
+	// move takes the node along one edge through the endpoint that owns it
+	move := func(nodeID int, from, to models.NodeStatus) {
+		t.Helper()
+		switch {
+		case to == models.NodeStatusRented:
+			expectStatus(t, s.asAdmin("POST", fmt.Sprintf("/api/v1/nodes/%d/rent", nodeID), fmt.Sprintf(`{"user_id": %d}`, userID)), http.StatusOK)
+		case from == models.NodeStatusRented:
+			expectStatus(t, s.asAdmin("POST", fmt.Sprintf("/api/v1/nodes/%d/release", nodeID), fmt.Sprintf(`{"user_id": %d}`, userID)), http.StatusOK)
+		case to == models.NodeStatusDecommissioned:
+			expectStatus(t, s.asAdmin("DELETE", fmt.Sprintf("/api/v1/nodes/%d", nodeID), ""), http.StatusOK)
+		default:
+			expectStatus(t, s.asKey(botKey, "PATCH", "/api/v1/nodes", fmt.Sprintf(`{"id": %d, "status": %q}`, nodeID, to)), http.StatusOK)
+		}
+	}
+
+	edges := [][2]models.NodeStatus{{models.NodeStatusRented, models.NodeStatusAvailable}}
+	for _, from := range models.NodeStatuses {
+		for _, to := range from.Transitions() {
+			edges = append(edges, [2]models.NodeStatus{from, to})
+		}
+	}
+	for _, edge := range edges {
+		from, to := edge[0], edge[1]
+		node := s.addNode("10", "secret")
+		if from != models.NodeStatusAvailable {
+			move(node.ID, models.NodeStatusAvailable, from)
+		}
+		move(node.ID, from, to)
+
+		resp := s.asAdmin("GET", fmt.Sprintf("/api/v1/nodes/%d/status-history", node.ID), "")
+		expectStatus(t, resp, http.StatusOK)
+		var changes []models.NodeStatusChange
+		resp.decode(t, &changes)
+		if len(changes) == 0 {
+			t.Fatalf("%s -> %s: want the change in the history, got none", from, to)
+		}
+		if last := changes[len(changes)-1]; last.FromStatus != from || last.ToStatus != to {
+			t.Errorf("%s -> %s: want it last in the history, got %+v", from, to, changes)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// prefix marks values produced by Encrypt so that legacy plaintext values can
+// be told apart during migration.
+const prefix = "enc:v1:"
+
+const keySize = 32
+
+var (
+	ErrNotInitialized = errors.New("secrets: encryption key is not configured")
+	ErrMalformed      = errors.New("secrets: malformed ciphertext")
+)
+
+var masterKey cipher.AEAD
+
+// Init configures the master key used to wrap data keys. The key must be a
+// base64 encoded 32 byte AES-256 key.
+func Init(encodedKey string) error {
+	if encodedKey == "" {
+		return ErrNotInitialized
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return fmt.Errorf("secrets: invalid key encoding: %w", err)
+	}
+	if len(key) != keySize {
+		return fmt.Errorf("secrets: key must be %d bytes, got %d", keySize, len(key))
+	}
+
+	masterKey, err = newGCM(key)
+	return err
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// IsEncrypted reports whether value was produced by Encrypt.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Encrypt seals plaintext with a fresh random data key and wraps that data key
+// with the master key. The result is self-contained and safe to store as text.
+func Encrypt(plaintext string) (string, error) {
+	if masterKey == nil {
+		return "", ErrNotInitialized
+	}
+
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	data, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	wrapped, err := seal(masterKey, dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(data, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + base64.StdEncoding.EncodeToString(append(wrapped, sealed...)), nil
+}
+
+// Decrypt reverses Encrypt.
+func Decrypt(value string) (string, error) {
+	if masterKey == nil {
+		return "", ErrNotInitialized
+	}
+	if !IsEncrypted(value) {
+		return "", ErrMalformed
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, prefix))
+	if err != nil {
+		return "", ErrMalformed
+	}
+
+	wrappedSize := masterKey.NonceSize() + keySize + masterKey.Overhead()
+	if len(raw) < wrappedSize {
+		return "", ErrMalformed
+	}
+
+	dataKey, err := open(masterKey, raw[:wrappedSize])
+	if err != nil {
+		return "", err
+	}
+	data, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := open(data, raw[wrappedSize:])
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// seal encrypts plaintext with a random nonce and returns nonce||ciphertext.
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open decrypts a nonce||ciphertext value produced by seal.
+func open(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	return plaintext, nil
+}
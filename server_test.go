@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hvmnd/api/auth"
+	"hvmnd/api/handlers"
+	"hvmnd/api/idempotency"
+	"hvmnd/api/models"
+	"hvmnd/api/money"
+	"hvmnd/api/providers"
+	"hvmnd/api/requestlog"
+	"hvmnd/api/secrets"
+	"hvmnd/api/store"
+	"hvmnd/api/store/memory"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testAdminKey = "test-admin-key"
+	testBotToken = "test-bot-token"
+)
+
+// testServer serves the full route table on the in-memory store.
+type testServer struct {
+	t     *testing.T
+	store *memory.Store
+	fake  *providers.Fake
+	url   string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	return newTestServerWithPolicy(t, "")
+}
+
+// newTestServerWithPolicy serves the route table with refunds and
+// cancellations under policy.
+func newTestServerWithPolicy(t *testing.T, policy store.RefundPolicy) *testServer {
+	t.Helper()
+
+	if err := secrets.Init("MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE="); err != nil {
+		t.Fatal(err)
+	}
+
+	stores := memory.New()
+	fake := providers.NewFake("test-webhook-secret")
+	h := handlers.New(handlers.Stores{
+		Users:    stores,
+		Nodes:    stores,
+		Payments: stores,
+		Rates:    stores,
+		Quiz:     stores,
+		Keys:     stores,
+		Audit:    stores,
+	}, handlers.Options{
+		Providers:    providers.NewRegistry(fake),
+		RefundPolicy: policy,
+	})
+
+	authenticator := auth.New(stores, testAdminKey)
+	authenticator.EnableTelegram(testBotToken, 0)
+
+	mux := http.NewServeMux()
+	registerRoutes(mux, h, authenticator, idempotency.New(stores, 0))
+	srv := httptest.NewServer(requestlog.New(slog.New(slog.NewJSONHandler(io.Discard, nil))).Wrap(mux))
+	t.Cleanup(srv.Close)
+
+	return &testServer{t: t, store: stores, fake: fake, url: srv.URL}
+}
+
+// response is a decoded APIResponse with its status and headers.
+type response struct {
+	status int
+	header http.Header
+
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Data       json.RawMessage `json:"data"`
+	Error      string          `json:"error"`
+	NextCursor string          `json:"next_cursor"`
+	RequestID  string          `json:"request_id"`
+}
+
+// decode unmarshals the response data into v.
+func (r response) decode(t *testing.T, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(r.Data, v); err != nil {
+		t.Fatalf("decoding %s: %v", r.Data, err)
+	}
+}
+
+// do sends a request with the given headers and decodes the response.
+func (s *testServer) do(method, path, body string, header http.Header) response {
+	s.t.Helper()
+
+	req, err := http.NewRequest(method, s.url+path, strings.NewReader(body))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	decoded := response{status: resp.StatusCode, header: resp.Header}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		s.t.Fatalf("%s %s answered %d with a body that is not JSON: %q", method, path, resp.StatusCode, raw)
+	}
+	return decoded
+}
+
+func (s *testServer) asKey(key, method, path, body string) response {
+	s.t.Helper()
+	return s.do(method, path, body, http.Header{"Authorization": {"Bearer " + key}})
+}
+
+func (s *testServer) asAdmin(method, path, body string) response {
+	s.t.Helper()
+	return s.asKey(testAdminKey, method, path, body)
+}
+
+// asTelegram sends a request with initData signed for the Telegram user.
+func (s *testServer) asTelegram(telegramID int, method, path, body string) response {
+	s.t.Helper()
+	return s.do(method, path, body, http.Header{"Authorization": {"tma " + signInitData(telegramID, time.Now())}})
+}
+
+// signInitData builds the initData Telegram would hand a Mini App.
+func signInitData(telegramID int, authDate time.Time) string {
+	values := url.Values{}
+	values.Set("auth_date", strconv.FormatInt(authDate.Unix(), 10))
+	values.Set("query_id", "test")
+	values.Set("user", fmt.Sprintf(`{"id":%d,"first_name":"Test"}`, telegramID))
+
+	dataCheck := "auth_date=" + values.Get("auth_date") + "\nquery_id=test\nuser=" + values.Get("user")
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(testBotToken))
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(dataCheck))
+	values.Set("hash", hex.EncodeToString(mac.Sum(nil)))
+
+	return values.Encode()
+}
+
+// issueKey issues an API key with role and returns its plaintext.
+func (s *testServer) issueKey(role auth.Role) string {
+	s.t.Helper()
+	return s.issueKeyWith(fmt.Sprintf(`{"name": "test", "role": %q}`, role))
+}
+
+// issueAgentKey issues a node-agent key bound to machineID.
+func (s *testServer) issueAgentKey(machineID string) string {
+	s.t.Helper()
+	return s.issueKeyWith(fmt.Sprintf(`{"name": "agent", "role": "node-agent", "machine_id": %q}`, machineID))
+}
+
+func (s *testServer) issueKeyWith(body string) string {
+	s.t.Helper()
+
+	resp := s.asAdmin("POST", "/api/v1/admin/api-keys", body)
+	expectStatus(s.t, resp, http.StatusCreated)
+	var issued struct {
+		Key string `json:"key"`
+	}
+	resp.decode(s.t, &issued)
+	return issued.Key
+}
+
+// createUser registers a Telegram user and returns their user id.
+func (s *testServer) createUser(telegramID int) int {
+	s.t.Helper()
+
+	resp := s.asAdmin("POST", "/api/v1/users", fmt.Sprintf(`{"telegram_id": %d}`, telegramID))
+	expectStatus(s.t, resp, http.StatusOK)
+	var user models.User
+	resp.decode(s.t, &user)
+	return user.ID
+}
+
+// openTicket opens an unpaid payment ticket in the base currency and returns
+// its id.
+func (s *testServer) openTicket(userID int, amount string) int {
+	s.t.Helper()
+
+	resp := s.asAdmin("POST", "/api/v1/payments", fmt.Sprintf(`{"user_id": %d, "amount": %s}`, userID, amount))
+	expectStatus(s.t, resp, http.StatusCreated)
+	var ticket struct {
+		ID int `json:"payment_ticket_id"`
+	}
+	resp.decode(s.t, &ticket)
+	return ticket.ID
+}
+
+// fund credits the user through a completed payment ticket.
+func (s *testServer) fund(userID int, amount string) {
+	s.t.Helper()
+
+	ticketID := s.openTicket(userID, amount)
+	expectStatus(s.t, s.asAdmin("PATCH", fmt.Sprintf("/api/v1/payments/complete/%d", ticketID), ""), http.StatusOK)
+}
+
+// webhook delivers a fake provider event signed with the provider's secret.
+func (s *testServer) webhook(body string) response {
+	s.t.Helper()
+	return s.do("POST", "/api/v1/payments/webhook/fake", body, http.Header{
+		providers.FakeSignatureHeader: {s.fake.Sign([]byte(body))},
+	})
+}
+
+// addNode seeds an available node with an encrypted AnyDesk password.
+func (s *testServer) addNode(price string, password string) models.Node {
+	s.t.Helper()
+
+	encrypted, err := secrets.Encrypt(password)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return s.store.AddNode(models.Node{
+		AnyDeskAddress:  "anydesk-" + strconv.Itoa(int(time.Now().UnixNano())),
+		AnyDeskPassword: encrypted,
+		Price:           money.MustParse(price),
+	})
+}
+
+// ledger returns the user's ledger summary.
+func (s *testServer) ledger(userID int) (balance money.Amount, reconciled bool) {
+	s.t.Helper()
+
+	resp := s.asAdmin("GET", fmt.Sprintf("/api/v1/users/%d/ledger", userID), "")
+	expectStatus(s.t, resp, http.StatusOK)
+	var summary struct {
+		Balance    money.Amount `json:"balance"`
+		Reconciled bool         `json:"reconciled"`
+	}
+	resp.decode(s.t, &summary)
+	return summary.Balance, summary.Reconciled
+}
+
+func expectStatus(t *testing.T, resp response, status int) {
+	t.Helper()
+	if resp.status != status {
+		t.Fatalf("want status %d, got %d: %s", status, resp.status, resp.Error)
+	}
+}
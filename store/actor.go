@@ -0,0 +1,39 @@
+package store
+
+import "context"
+
+// SystemActor is recorded for changes made outside of an API request, such as
+// by the billing worker or the heartbeat monitor.
+const SystemActor = "system"
+
+type actorKey struct{}
+
+// WithActor returns a context that attributes the changes made through it to
+// actor in the history the stores keep.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor set by WithActor, or SystemActor.
+func ActorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return SystemActor
+}
+
+type routeKey struct{}
+
+// WithRoute returns a context whose changes are audited as made through
+// route. The stores record every change made with a route in the audit log,
+// in the same transaction as the change.
+func WithRoute(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, routeKey{}, route)
+}
+
+// RouteFrom returns the route set by WithRoute, or "" for changes that are
+// not audited.
+func RouteFrom(ctx context.Context) string {
+	route, _ := ctx.Value(routeKey{}).(string)
+	return route
+}
@@ -0,0 +1,169 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"hvmnd/api/audit"
+	"hvmnd/api/models"
+	"hvmnd/api/store"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// auditKey identifies one stored row. entityID is what the audit log names
+// it by, which need not be unique; rates are logged by currency.
+type auditKey struct {
+	entityType string
+	entityID   string
+	row        string
+}
+
+// change locks the store for a method that writes to it and returns the
+// func that unlocks it. Like the audit triggers in Postgres, it appends an
+// audit entry for every row the method changed, as long as it runs for an
+// audited route.
+func (s *Store) change(ctx context.Context) func() {
+	s.mu.Lock()
+
+	route := store.RouteFrom(ctx)
+	if route == "" {
+		return s.mu.Unlock
+	}
+
+	before := s.rows()
+	return func() {
+		defer s.mu.Unlock()
+		s.recordChanges(ctx, route, before, s.rows())
+	}
+}
+
+// rows returns a copy of every audited row in the store.
+func (s *Store) rows() map[auditKey]interface{} {
+	rows := map[auditKey]interface{}{}
+	add := func(entityType string, id interface{}, row string, value interface{}) {
+		rows[auditKey{entityType: entityType, entityID: fmt.Sprint(id), row: row}] = value
+	}
+
+	for id, user := range s.users {
+		add("user", id, "", *user)
+	}
+	for id, node := range s.nodes {
+		add("node", id, "", *node)
+	}
+	for id, payment := range s.payments {
+		add("payment", id, "", *payment)
+	}
+	for id, rental := range s.rentals {
+		add("rental", id, "", *rental)
+	}
+	for id, key := range s.keys {
+		add("api_key", id, "", key.key)
+	}
+	for _, adjustment := range s.adjustments {
+		add("user_adjustment", adjustment.ID, "", adjustment)
+	}
+	for _, refund := range s.refunds {
+		add("payment_refund", refund.ID, "", refund)
+	}
+	for _, rate := range s.rates {
+		add("exchange_rate", rate.Currency, rate.EffectiveFrom.Format(time.RFC3339Nano), rate)
+	}
+	for hash, mapping := range s.quizHashes {
+		add("quiz_hash", hash, "", map[string]string{"hash": hash, "question": mapping[0], "answer": mapping[1]})
+	}
+	for key, answer := range s.quizAnswers {
+		add("quiz_answer", key.telegramID, key.question, map[string]interface{}{
+			"telegram_id": key.telegramID,
+			"question":    key.question,
+			"answer":      answer.answer,
+			"hash":        answer.hash,
+		})
+	}
+	return rows
+}
+
+// recordChanges appends an audit entry for each row that differs between
+// before and after.
+func (s *Store) recordChanges(ctx context.Context, route string, before, after map[auditKey]interface{}) {
+	keys := make([]auditKey, 0, len(after))
+	for key := range before {
+		keys = append(keys, key)
+	}
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.entityType != b.entityType {
+			return a.entityType < b.entityType
+		}
+		if a.entityID != b.entityID {
+			return lessID(a.entityID, b.entityID)
+		}
+		return a.row < b.row
+	})
+
+	now := time.Now()
+	for _, key := range keys {
+		oldValues, newValues, err := audit.Diff(before[key], after[key])
+		if err != nil {
+			panic(fmt.Sprintf("memory: diffing %s %s: %v", key.entityType, key.entityID, err))
+		}
+		if oldValues == nil && newValues == nil {
+			continue
+		}
+		s.audit = append(s.audit, models.AuditEntry{
+			ID:         s.nextID("audit_log"),
+			Actor:      store.ActorFrom(ctx),
+			Route:      route,
+			EntityType: key.entityType,
+			EntityID:   key.entityID,
+			Before:     oldValues,
+			After:      newValues,
+			CreatedAt:  now,
+		})
+	}
+}
+
+// lessID orders numeric ids by value and other ids as text.
+func lessID(a, b string) bool {
+	x, errX := strconv.Atoi(a)
+	y, errY := strconv.Atoi(b)
+	if errX == nil && errY == nil {
+		return x < y
+	}
+	return a < b
+}
+
+func (s *Store) ListAudit(ctx context.Context, filter store.AuditFilter, page store.Page) ([]models.AuditEntry, store.PageInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []models.AuditEntry
+	for _, entry := range s.audit {
+		if filter.Actor != "" && entry.Actor != filter.Actor {
+			continue
+		}
+		if filter.Route != "" && entry.Route != filter.Route {
+			continue
+		}
+		if filter.EntityType != "" && entry.EntityType != filter.EntityType {
+			continue
+		}
+		if filter.EntityID != "" && entry.EntityID != filter.EntityID {
+			continue
+		}
+		if !filter.From.IsZero() && entry.CreatedAt.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && entry.CreatedAt.After(filter.To) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return paginate(entries, page, store.AuditSortValue, func(e models.AuditEntry) int { return e.ID })
+}
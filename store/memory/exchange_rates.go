@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"context"
+	"hvmnd/api/currency"
+	"hvmnd/api/models"
+	"hvmnd/api/money"
+	"hvmnd/api/store"
+	"sort"
+	"time"
+)
+
+// exchangeRate looks up the rate of code in effect at the given time. The
+// caller must hold s.mu.
+func (s *Store) exchangeRate(code string, at time.Time) (models.ExchangeRate, error) {
+	if code == currency.Base() {
+		return models.ExchangeRate{Currency: code, Rate: money.UnitRate}, nil
+	}
+
+	var found *models.ExchangeRate
+	for i, rate := range s.rates {
+		if rate.Currency != code || rate.EffectiveFrom.After(at) {
+			continue
+		}
+		if found == nil || rate.EffectiveFrom.After(found.EffectiveFrom) {
+			found = &s.rates[i]
+		}
+	}
+	if found == nil {
+		return models.ExchangeRate{}, store.ErrNoExchangeRate
+	}
+	return *found, nil
+}
+
+func (s *Store) ExchangeRate(ctx context.Context, code string, at time.Time) (models.ExchangeRate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.exchangeRate(code, at)
+}
+
+func (s *Store) ListExchangeRates(ctx context.Context, code string) ([]models.ExchangeRate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rates []models.ExchangeRate
+	for _, rate := range s.rates {
+		if code == "" || rate.Currency == code {
+			rates = append(rates, rate)
+		}
+	}
+
+	sort.Slice(rates, func(i, j int) bool {
+		if !rates[i].EffectiveFrom.Equal(rates[j].EffectiveFrom) {
+			return rates[i].EffectiveFrom.After(rates[j].EffectiveFrom)
+		}
+		return rates[i].Currency < rates[j].Currency
+	})
+	return rates, nil
+}
+
+func (s *Store) SetExchangeRate(ctx context.Context, rate models.ExchangeRate) (models.ExchangeRate, error) {
+	defer s.change(ctx)()
+
+	for i, existing := range s.rates {
+		if existing.Currency == rate.Currency && existing.EffectiveFrom.Equal(rate.EffectiveFrom) {
+			s.rates[i] = rate
+			return rate, nil
+		}
+	}
+	s.rates = append(s.rates, rate)
+	return rate, nil
+}
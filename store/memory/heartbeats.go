@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"hvmnd/api/billing"
+	"hvmnd/api/models"
+	"hvmnd/api/store"
+	"time"
+)
+
+func (s *Store) RecordHeartbeat(ctx context.Context, heartbeat models.Heartbeat) (models.Node, error) {
+	defer s.change(ctx)()
+
+	var node *models.Node
+	for _, id := range sortedIDs(s.nodes) {
+		candidate := s.nodes[id]
+		if candidate.Status != models.NodeStatusDecommissioned && candidate.MachineID.Valid && candidate.MachineID.String == heartbeat.MachineID {
+			node = candidate
+			break
+		}
+	}
+	if node == nil {
+		return models.Node{}, store.ErrNotFound
+	}
+
+	if heartbeat.AnyDeskAddress != node.AnyDeskAddress {
+		if s.addressTaken(heartbeat.AnyDeskAddress, node.ID) {
+			return models.Node{}, store.ErrDuplicateAnyDeskAddress
+		}
+		node.AnyDeskAddress = heartbeat.AnyDeskAddress
+	}
+
+	node.LastHeartbeatAt = sql.NullTime{Time: heartbeat.ReceivedAt, Valid: true}
+	// Only a node the monitor took offline comes back by itself
+	if node.Status == models.NodeStatusOffline && s.lost[node.ID] {
+		s.setStatus(ctx, node, models.NodeStatusAvailable)
+	}
+
+	if node.BillingPausedAt.Valid {
+		node.LastBalanceUpdateTimestamp = billing.StateOf(*node).Resumed(heartbeat.ReceivedAt)
+		node.BillingPausedAt = sql.NullTime{}
+	}
+
+	s.beats[node.ID] = heartbeat
+	return *node, nil
+}
+
+func (s *Store) MarkOffline(ctx context.Context, cutoff time.Time) ([]int, error) {
+	defer s.change(ctx)()
+
+	var nodeIDs []int
+	for _, id := range sortedIDs(s.nodes) {
+		node := s.nodes[id]
+		if !node.LastHeartbeatAt.Valid || !node.LastHeartbeatAt.Time.Before(cutoff) {
+			continue
+		}
+
+		switch {
+		case node.Status == models.NodeStatusAvailable:
+			s.setStatus(ctx, node, models.NodeStatusOffline)
+			s.lost[node.ID] = true
+		case billing.StateOf(*node).Billable():
+			pausedAt := billing.PausedFrom(node.LastHeartbeatAt.Time, node.LastBalanceUpdateTimestamp)
+			node.BillingPausedAt = sql.NullTime{Time: pausedAt, Valid: true}
+		default:
+			continue
+		}
+		nodeIDs = append(nodeIDs, id)
+	}
+
+	return nodeIDs, nil
+}
@@ -0,0 +1,65 @@
+package memory
+
+import (
+	"context"
+	"hvmnd/api/idempotency"
+	"time"
+)
+
+type idempotencyKey struct {
+	scope string
+	key   string
+}
+
+type idempotencyRecord struct {
+	record    idempotency.Record
+	expiresAt time.Time
+}
+
+func (s *Store) ClaimIdempotencyKey(ctx context.Context, scope, key, fingerprint string, now, expiresAt time.Time) (idempotency.Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := idempotencyKey{scope: scope, key: key}
+	if existing, ok := s.idempotency[id]; ok && existing.expiresAt.After(now) {
+		return existing.record, false, nil
+	}
+
+	s.idempotency[id] = &idempotencyRecord{
+		record:    idempotency.Record{Fingerprint: fingerprint},
+		expiresAt: expiresAt,
+	}
+	return idempotency.Record{Fingerprint: fingerprint}, true, nil
+}
+
+func (s *Store) SaveIdempotentResponse(ctx context.Context, scope, key string, record idempotency.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.idempotency[idempotencyKey{scope: scope, key: key}]; ok {
+		existing.record = record
+	}
+	return nil
+}
+
+func (s *Store) ReleaseIdempotencyKey(ctx context.Context, scope, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.idempotency, idempotencyKey{scope: scope, key: key})
+	return nil
+}
+
+func (s *Store) PurgeIdempotencyKeys(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := 0
+	for id, record := range s.idempotency {
+		if !record.expiresAt.After(now) {
+			delete(s.idempotency, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
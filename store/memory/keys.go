@@ -0,0 +1,67 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"hvmnd/api/auth"
+	"time"
+)
+
+func (s *Store) CreateKey(ctx context.Context, name string, keyHash string, role auth.Role, machineID string) (auth.APIKey, error) {
+	defer s.change(ctx)()
+
+	key := &apiKey{
+		key: auth.APIKey{
+			ID:        s.nextID("api_keys"),
+			Name:      name,
+			Role:      role,
+			MachineID: sql.NullString{String: machineID, Valid: machineID != ""},
+			CreatedAt: time.Now(),
+		},
+		hash: keyHash,
+	}
+	s.keys[key.key.ID] = key
+
+	return key.key, nil
+}
+
+func (s *Store) RevokeKey(ctx context.Context, id int) error {
+	defer s.change(ctx)()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return auth.ErrKeyNotFound
+	}
+	if !key.key.RevokedAt.Valid {
+		key.key.RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+	return nil
+}
+
+func (s *Store) ListKeys(ctx context.Context) ([]auth.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []auth.APIKey
+	for _, id := range sortedIDs(s.keys) {
+		keys = append(keys, s.keys[id].key)
+	}
+	return keys, nil
+}
+
+func (s *Store) FindKey(ctx context.Context, keyHash string) (auth.Principal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range s.keys {
+		if key.hash == keyHash && !key.key.RevokedAt.Valid {
+			return auth.Principal{
+				KeyID:     key.key.ID,
+				Name:      key.key.Name,
+				Role:      key.key.Role,
+				MachineID: key.key.MachineID.String,
+			}, nil
+		}
+	}
+	return auth.Principal{}, auth.ErrKeyNotFound
+}
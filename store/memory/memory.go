@@ -0,0 +1,202 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hvmnd/api/auth"
+	"hvmnd/api/idempotency"
+	"hvmnd/api/ledger"
+	"hvmnd/api/models"
+	"hvmnd/api/money"
+	"hvmnd/api/store"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ledgerLine is a ledger entry together with the user whose account it is
+// on; system accounts have no user.
+type ledgerLine struct {
+	entry  ledger.Entry
+	userID int
+}
+
+type quizAnswerKey struct {
+	telegramID int
+	question   string
+}
+
+type quizAnswer struct {
+	answer string
+	hash   string
+}
+
+type apiKey struct {
+	key  auth.APIKey
+	hash string
+}
+
+// Store keeps all data in process memory behind a single mutex, which makes
+// every method atomic in the same way a Postgres transaction is.
+type Store struct {
+	mu sync.Mutex
+
+	users    map[int]*models.User
+	nodes    map[int]*models.Node
+	payments map[int]*models.Payment
+	rentals  map[int]*models.Rental
+	ledger   []ledgerLine
+	beats    map[int]models.Heartbeat // Latest heartbeat by node id
+	lost     map[int]bool             // Nodes the heartbeat monitor took offline
+	statuses []models.NodeStatusChange
+	keys     map[int]*apiKey
+
+	paymentEvents []*models.PaymentEvent
+	refunds       []models.Refund
+	adjustments   []models.Adjustment
+	rates         []models.ExchangeRate
+
+	idempotency map[idempotencyKey]*idempotencyRecord
+	audit       []models.AuditEntry
+
+	quizHashes  map[string][2]string
+	quizAnswers map[quizAnswerKey]quizAnswer
+
+	lastID map[string]int
+}
+
+var (
+	_ store.UserStore         = (*Store)(nil)
+	_ store.NodeStore         = (*Store)(nil)
+	_ store.PaymentStore      = (*Store)(nil)
+	_ store.ExchangeRateStore = (*Store)(nil)
+	_ store.QuizStore         = (*Store)(nil)
+	_ store.AuditStore        = (*Store)(nil)
+	_ auth.KeyStore           = (*Store)(nil)
+
+	_ idempotency.Store = (*Store)(nil)
+)
+
+func New() *Store {
+	return &Store{
+		users:       map[int]*models.User{},
+		nodes:       map[int]*models.Node{},
+		payments:    map[int]*models.Payment{},
+		rentals:     map[int]*models.Rental{},
+		beats:       map[int]models.Heartbeat{},
+		lost:        map[int]bool{},
+		keys:        map[int]*apiKey{},
+		idempotency: map[idempotencyKey]*idempotencyRecord{},
+		quizHashes:  map[string][2]string{},
+		quizAnswers: map[quizAnswerKey]quizAnswer{},
+		lastID:      map[string]int{},
+	}
+}
+
+// nextID emulates a SERIAL column for table.
+func (s *Store) nextID(table string) int {
+	s.lastID[table]++
+	return s.lastID[table]
+}
+
+// AddNode inserts a node as-is, assigning an id if it has none, and returns
+// the stored copy. Unlike CreateNode it does no validation, so callers can
+// seed nodes in any state.
+func (s *Store) AddNode(node models.Node) models.Node {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if node.ID == 0 {
+		node.ID = s.nextID("nodes")
+	} else if node.ID > s.lastID["nodes"] {
+		s.lastID["nodes"] = node.ID
+	}
+	status := node.Status
+	if status == "" {
+		status = models.NodeStatusAvailable
+	}
+	node.Status = ""
+	s.setStatus(context.Background(), &node, status)
+
+	s.nodes[node.ID] = &node
+	return node
+}
+
+// setStatus moves the node to status and appends the change to the status
+// history, as the nodes_status_history trigger does in Postgres. Callers
+// check the transition first.
+func (s *Store) setStatus(ctx context.Context, node *models.Node, status models.NodeStatus) {
+	if node.Status == status {
+		return
+	}
+	s.statuses = append(s.statuses, models.NodeStatusChange{
+		ID:         int64(s.nextID("node_status_history")),
+		NodeID:     node.ID,
+		FromStatus: node.Status,
+		ToStatus:   status,
+		ChangedBy:  store.ActorFrom(ctx),
+		ChangedAt:  time.Now(),
+	})
+	node.Status = status
+	delete(s.lost, node.ID)
+}
+
+// post mirrors ledger.Post: it records a balanced transfer between the user's
+// account and the reason's counter account and applies it to the balance.
+func (s *Store) post(userID int, amount money.Amount, reason ledger.Reason, referenceID string, now time.Time) error {
+	counter, ok := ledger.CounterAccount(reason)
+	if !ok {
+		return fmt.Errorf("unknown ledger reason %q", reason)
+	}
+
+	user, ok := s.users[userID]
+	if !ok {
+		return fmt.Errorf("user %d not found", userID)
+	}
+
+	var ref sql.NullString
+	if referenceID != "" {
+		ref = sql.NullString{String: referenceID, Valid: true}
+	}
+
+	transactionID := int64(s.nextID("balance_ledger_transaction"))
+	s.ledger = append(s.ledger,
+		ledgerLine{
+			userID: userID,
+			entry: ledger.Entry{
+				ID:            int64(s.nextID("balance_ledger")),
+				TransactionID: transactionID,
+				Account:       ledger.UserAccount(userID),
+				Amount:        amount,
+				Reason:        reason,
+				ReferenceID:   ref,
+				CreatedAt:     now,
+			},
+		},
+		ledgerLine{
+			entry: ledger.Entry{
+				ID:            int64(s.nextID("balance_ledger")),
+				TransactionID: transactionID,
+				Account:       counter,
+				Amount:        -amount,
+				Reason:        reason,
+				ReferenceID:   ref,
+				CreatedAt:     now,
+			},
+		},
+	)
+	user.Balance += amount
+
+	return nil
+}
+
+// sortedIDs returns the keys of m in ascending order.
+func sortedIDs[T any](m map[int]T) []int {
+	ids := make([]int, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
@@ -0,0 +1,599 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hvmnd/api/billing"
+	"hvmnd/api/ledger"
+	"hvmnd/api/models"
+	"hvmnd/api/money"
+	"hvmnd/api/store"
+	"sort"
+	"strings"
+	"time"
+)
+
+func (s *Store) ListNodes(ctx context.Context, filter store.NodeFilter, page store.Page) ([]models.Node, store.PageInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	software := make([]string, len(filter.Software))
+	for i, name := range filter.Software {
+		software[i] = strings.ToLower(name)
+	}
+	gpuModel := strings.ToLower(filter.GPUModel)
+
+	var nodes []models.Node
+	for _, id := range sortedIDs(s.nodes) {
+		node := s.nodes[id]
+		if filter.ID != 0 && node.ID != filter.ID {
+			continue
+		}
+		if filter.RenterNotNull && !node.Renter.Valid {
+			continue
+		}
+		if !filter.RenterNotNull && filter.Renter != 0 && (!node.Renter.Valid || int(node.Renter.Int16) != filter.Renter) {
+			continue
+		}
+		if filter.Status != "" && node.Status != filter.Status {
+			continue
+		}
+		if filter.Status == "" && node.Status == models.NodeStatusDecommissioned {
+			continue
+		}
+		if filter.AnyDeskAddress != "" && node.AnyDeskAddress != filter.AnyDeskAddress {
+			continue
+		}
+		if filter.OldID != 0 && (!node.OldID.Valid || int(node.OldID.Int32) != filter.OldID) {
+			continue
+		}
+		if !hasSoftware(node, software) {
+			continue
+		}
+		if gpuModel != "" && !strings.Contains(strings.ToLower(node.GPUModel.String), gpuModel) {
+			continue
+		}
+		if !atLeast(node.VRAMGB, filter.MinVRAMGB) ||
+			!atLeast(node.CPUCores, filter.MinCPUCores) ||
+			!atLeast(node.RAMGB, filter.MinRAMGB) ||
+			!atLeast(node.StorageGB, filter.MinStorageGB) {
+			continue
+		}
+		if filter.MaxPrice > 0 && node.Price > filter.MaxPrice {
+			continue
+		}
+		nodes = append(nodes, *node)
+	}
+
+	return paginate(nodes, page, store.NodeSortValue, func(n models.Node) int { return n.ID })
+}
+
+// hasSoftware reports whether the node has every lower-cased name, in its
+// catalog or its free-text software and licenses.
+func hasSoftware(node *models.Node, names []string) bool {
+	for _, name := range names {
+		found := strings.Contains(strings.ToLower(node.Software.String), name) ||
+			strings.Contains(strings.ToLower(node.Licenses.String), name)
+		for _, item := range node.SoftwareCatalog {
+			if strings.Contains(strings.ToLower(item.Name), name) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// atLeast reports whether value meets a minimum; a zero minimum is no filter
+// and a NULL value never meets a minimum.
+func atLeast(value sql.NullInt32, minimum int) bool {
+	return minimum <= 0 || (value.Valid && int(value.Int32) >= minimum)
+}
+
+// nodeByKey returns the node in service identified by key.
+func (s *Store) nodeByKey(key store.NodeKey) *models.Node {
+	for _, node := range s.nodes {
+		if node.Status == models.NodeStatusDecommissioned {
+			continue
+		}
+		switch {
+		case key.ID != nil:
+			if node.ID == *key.ID {
+				return node
+			}
+		case key.OldID != nil:
+			if node.OldID.Valid && int(node.OldID.Int32) == *key.OldID {
+				return node
+			}
+		case key.AnyDeskAddress != nil:
+			if node.AnyDeskAddress == *key.AnyDeskAddress {
+				return node
+			}
+		}
+	}
+	return nil
+}
+
+func nullInt32(column string, value interface{}) (sql.NullInt32, error) {
+	if value == nil {
+		return sql.NullInt32{}, nil
+	}
+	number, ok := value.(int32)
+	if !ok {
+		return sql.NullInt32{}, fmt.Errorf("column %q expects an integer", column)
+	}
+	return sql.NullInt32{Int32: number, Valid: true}, nil
+}
+
+// catalog copies items in the order Postgres returns them, without
+// duplicates.
+func catalog(items []models.NodeSoftware) []models.NodeSoftware {
+	seen := map[models.NodeSoftware]bool{}
+	var sorted []models.NodeSoftware
+	for _, item := range items {
+		if !seen[item] {
+			seen[item] = true
+			sorted = append(sorted, item)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Kind != sorted[j].Kind {
+			return sorted[i].Kind < sorted[j].Kind
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+// applyNodeChange sets one column on a copy of the node, mirroring the
+// columns the Postgres store allows to be updated.
+func applyNodeChange(node *models.Node, column string, value interface{}) error {
+	nullString := func() (sql.NullString, error) {
+		if value == nil {
+			return sql.NullString{}, nil
+		}
+		str, ok := value.(string)
+		if !ok {
+			return sql.NullString{}, fmt.Errorf("column %q expects a string", column)
+		}
+		return sql.NullString{String: str, Valid: true}, nil
+	}
+
+	var err error
+	switch column {
+	case "status":
+		status, ok := value.(models.NodeStatus)
+		if !ok {
+			return fmt.Errorf("column %q expects a node status", column)
+		}
+		node.Status = status
+	case "software":
+		node.Software, err = nullString()
+	case "price":
+		price, ok := value.(money.Amount)
+		if !ok {
+			return fmt.Errorf("column %q expects an amount of money", column)
+		}
+		node.Price = price
+	case "cpu":
+		node.CPU, err = nullString()
+	case "gpu":
+		node.GPU, err = nullString()
+	case "other_specs":
+		node.OtherSpecs, err = nullString()
+	case "licenses":
+		node.Licenses, err = nullString()
+	case "machine_id":
+		node.MachineID, err = nullString()
+	case "gpu_model":
+		node.GPUModel, err = nullString()
+	case "vram_gb":
+		node.VRAMGB, err = nullInt32(column, value)
+	case "cpu_cores":
+		node.CPUCores, err = nullInt32(column, value)
+	case "ram_gb":
+		node.RAMGB, err = nullInt32(column, value)
+	case "storage_gb":
+		node.StorageGB, err = nullInt32(column, value)
+	case "software_catalog":
+		if value == nil {
+			node.SoftwareCatalog = nil
+			break
+		}
+		items, ok := value.([]models.NodeSoftware)
+		if !ok {
+			return fmt.Errorf("column %q expects a software list", column)
+		}
+		node.SoftwareCatalog = catalog(items)
+	case "old_id":
+		if value == nil {
+			node.OldID = sql.NullInt32{}
+			break
+		}
+		oldID, ok := value.(int)
+		if !ok {
+			return fmt.Errorf("column %q expects an integer", column)
+		}
+		node.OldID = sql.NullInt32{Int32: int32(oldID), Valid: true}
+	case "any_desk_address":
+		address, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("column %q expects a string", column)
+		}
+		node.AnyDeskAddress = address
+	default:
+		return fmt.Errorf("column %q cannot be updated", column)
+	}
+	return err
+}
+
+// addressTaken reports whether a node other than id uses the AnyDesk address.
+// Like the Postgres unique constraint, decommissioned nodes count.
+func (s *Store) addressTaken(address string, id int) bool {
+	for _, node := range s.nodes {
+		if node.ID != id && node.AnyDeskAddress == address {
+			return true
+		}
+	}
+	return false
+}
+
+// machineIDTaken reports whether a node in service other than id uses the
+// machine id, as the partial unique index does in Postgres.
+func (s *Store) machineIDTaken(machineID sql.NullString, id int) bool {
+	if !machineID.Valid {
+		return false
+	}
+	for _, node := range s.nodes {
+		if node.ID != id && node.Status != models.NodeStatusDecommissioned && node.MachineID == machineID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) CreateNode(ctx context.Context, input models.NodeInput) (models.Node, error) {
+	defer s.change(ctx)()
+
+	if s.addressTaken(*input.AnyDeskAddress, 0) {
+		return models.Node{}, store.ErrDuplicateAnyDeskAddress
+	}
+	if s.machineIDTaken(sql.NullString{String: *input.MachineID, Valid: true}, 0) {
+		return models.Node{}, store.ErrDuplicateMachineID
+	}
+
+	optional := func(value *string) sql.NullString {
+		if value == nil {
+			return sql.NullString{}
+		}
+		return sql.NullString{String: *value, Valid: true}
+	}
+
+	node := &models.Node{
+		ID:              s.nextID("nodes"),
+		AnyDeskAddress:  *input.AnyDeskAddress,
+		AnyDeskPassword: *input.AnyDeskPassword,
+		Software:        optional(input.Software),
+		Price:           *input.Price,
+		CPU:             optional(input.CPU),
+		GPU:             optional(input.GPU),
+		OtherSpecs:      optional(input.OtherSpecs),
+		Licenses:        optional(input.Licenses),
+		MachineID:       optional(input.MachineID),
+		GPUModel:        optional(input.GPUModel),
+	}
+	for _, spec := range []struct {
+		dst   *sql.NullInt32
+		value *int32
+	}{
+		{&node.VRAMGB, input.VRAMGB},
+		{&node.CPUCores, input.CPUCores},
+		{&node.RAMGB, input.RAMGB},
+		{&node.StorageGB, input.StorageGB},
+	} {
+		if spec.value != nil {
+			*spec.dst = sql.NullInt32{Int32: *spec.value, Valid: true}
+		}
+	}
+	if input.SoftwareCatalog != nil {
+		node.SoftwareCatalog = catalog(*input.SoftwareCatalog)
+	}
+	if input.OldID != nil {
+		node.OldID = sql.NullInt32{Int32: int32(*input.OldID), Valid: true}
+	}
+	status := models.NodeStatusAvailable
+	if input.Status != nil {
+		status = *input.Status
+	}
+	s.setStatus(ctx, node, status)
+
+	s.nodes[node.ID] = node
+	return *node, nil
+}
+
+func (s *Store) UpdateNode(ctx context.Context, key store.NodeKey, changes map[string]interface{}) error {
+	defer s.change(ctx)()
+
+	node := s.nodeByKey(key)
+	if node == nil {
+		return store.ErrNotFound
+	}
+
+	// Apply to a copy so a bad column leaves the node untouched
+	updated := *node
+	for column, value := range changes {
+		if err := applyNodeChange(&updated, column, value); err != nil {
+			return err
+		}
+	}
+	if err := models.CheckNodeTransition(node.ID, node.Status, updated.Status); err != nil {
+		return err
+	}
+	if s.addressTaken(updated.AnyDeskAddress, updated.ID) {
+		return store.ErrDuplicateAnyDeskAddress
+	}
+	if updated.Status != models.NodeStatusDecommissioned && s.machineIDTaken(updated.MachineID, updated.ID) {
+		return store.ErrDuplicateMachineID
+	}
+
+	status := updated.Status
+	updated.Status = node.Status
+	*node = updated
+	s.setStatus(ctx, node, status)
+
+	return nil
+}
+
+func (s *Store) DecommissionNode(ctx context.Context, id int) error {
+	defer s.change(ctx)()
+
+	node, ok := s.nodes[id]
+	if !ok || node.Status == models.NodeStatusDecommissioned {
+		return store.ErrNotFound
+	}
+	if node.Status == models.NodeStatusRented {
+		return store.ErrNodeRented
+	}
+	if err := models.CheckNodeTransition(node.ID, node.Status, models.NodeStatusDecommissioned); err != nil {
+		return err
+	}
+
+	s.setStatus(ctx, node, models.NodeStatusDecommissioned)
+	return nil
+}
+
+func (s *Store) NodeStatusHistory(ctx context.Context, nodeID int) ([]models.NodeStatusChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.nodes[nodeID]; !ok {
+		return nil, store.ErrNotFound
+	}
+
+	var changes []models.NodeStatusChange
+	for _, change := range s.statuses {
+		if change.NodeID == nodeID {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+func (s *Store) NodeCredentials(ctx context.Context, id int) (models.NodeCredentials, *int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodes[id]
+	if !ok {
+		return models.NodeCredentials{}, nil, store.ErrNotFound
+	}
+
+	credentials := models.NodeCredentials{
+		NodeID:          node.ID,
+		AnyDeskAddress:  node.AnyDeskAddress,
+		AnyDeskPassword: node.AnyDeskPassword,
+	}
+	if !node.Renter.Valid {
+		return credentials, nil, nil
+	}
+	renter := int(node.Renter.Int16)
+	return credentials, &renter, nil
+}
+
+func (s *Store) RentNode(ctx context.Context, nodeID int, userID int, now time.Time) (int, error) {
+	defer s.change(ctx)()
+
+	node, ok := s.nodes[nodeID]
+	if !ok {
+		return 0, billing.ErrNodeNotFound
+	}
+	state := billing.StateOf(*node)
+	if err := state.CheckAvailable(); err != nil {
+		return 0, err
+	}
+
+	user, ok := s.users[userID]
+	if !ok {
+		return 0, billing.ErrUserNotFound
+	}
+	if err := state.CheckRenter(user.Balance, user.BannedAt(now)); err != nil {
+		return 0, err
+	}
+
+	s.setStatus(ctx, node, models.NodeStatusRented)
+	node.Renter = sql.NullInt16{Int16: int16(userID), Valid: true}
+	node.RentStartTime = sql.NullTime{Time: now, Valid: true}
+	node.LastBalanceUpdateTimestamp = sql.NullTime{Time: now, Valid: true}
+
+	rental := &models.Rental{
+		ID:           s.nextID("rentals"),
+		NodeID:       nodeID,
+		UserID:       userID,
+		StartedAt:    now,
+		PricePerHour: node.Price,
+	}
+	s.rentals[rental.ID] = rental
+
+	return rental.ID, nil
+}
+
+// openRental returns the node's open rental, backfilling one for nodes that
+// were rented without a rental record.
+func (s *Store) openRental(node *models.Node) *models.Rental {
+	for _, rental := range s.rentals {
+		if rental.NodeID == node.ID && !rental.EndedAt.Valid {
+			return rental
+		}
+	}
+
+	rental := &models.Rental{
+		ID:           s.nextID("rentals"),
+		NodeID:       node.ID,
+		UserID:       int(node.Renter.Int16),
+		StartedAt:    billing.StateOf(*node).RentalStart().Time,
+		PricePerHour: node.Price,
+	}
+	s.rentals[rental.ID] = rental
+	return rental
+}
+
+// charge applies the bill the billing rules compute for the node, as
+// billing.Release and billing.BillNode do in Postgres.
+func (s *Store) charge(node *models.Node, until time.Time, final bool) (money.Amount, error) {
+	bill, err := billing.StateOf(*node).Due(until, final)
+	if err != nil || bill.Minutes == 0 {
+		return 0, err
+	}
+
+	rental := s.openRental(node)
+	renter, ok := s.users[int(node.Renter.Int16)]
+	if !ok {
+		return 0, billing.ErrUserNotFound
+	}
+
+	amount := bill.Collect(renter.Balance)
+	if amount > 0 {
+		reference := fmt.Sprintf("rental:%d", rental.ID)
+		if err := s.post(renter.ID, -amount, ledger.ReasonRentalCharge, reference, until); err != nil {
+			return 0, err
+		}
+		rental.TotalCharged += amount
+		renter.TotalSpent += amount
+	}
+
+	node.LastBalanceUpdateTimestamp.Time = bill.BilledUntil
+	return amount, nil
+}
+
+func (s *Store) release(ctx context.Context, node *models.Node, userID int, now time.Time, reason billing.EndReason) (money.Amount, error) {
+	if err := billing.StateOf(*node).CheckRelease(userID); err != nil {
+		return 0, err
+	}
+
+	charged, err := s.charge(node, now, true)
+	if err != nil {
+		return 0, err
+	}
+
+	rental := s.openRental(node)
+	rental.EndedAt = sql.NullTime{Time: now, Valid: true}
+	rental.EndReason = sql.NullString{String: string(reason), Valid: true}
+
+	s.setStatus(ctx, node, models.NodeStatusAvailable)
+	node.Renter = sql.NullInt16{}
+	node.RentStartTime = sql.NullTime{}
+	node.LastBalanceUpdateTimestamp = sql.NullTime{}
+	node.BillingPausedAt = sql.NullTime{}
+
+	return charged, nil
+}
+
+func (s *Store) ReleaseNode(ctx context.Context, nodeID int, userID int, now time.Time, reason billing.EndReason) (money.Amount, error) {
+	defer s.change(ctx)()
+
+	node, ok := s.nodes[nodeID]
+	if !ok {
+		return 0, billing.ErrNodeNotFound
+	}
+
+	return s.release(ctx, node, userID, now, reason)
+}
+
+func (s *Store) ListRentals(ctx context.Context, filter store.RentalFilter) ([]models.Rental, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rentals []models.Rental
+	for _, rental := range s.rentals {
+		if filter.UserID != 0 && rental.UserID != filter.UserID {
+			continue
+		}
+		if filter.NodeID != 0 && rental.NodeID != filter.NodeID {
+			continue
+		}
+		if !filter.From.IsZero() && rental.EndedAt.Valid && rental.EndedAt.Time.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && rental.StartedAt.After(filter.To) {
+			continue
+		}
+		rentals = append(rentals, *rental)
+	}
+
+	sort.Slice(rentals, func(i, j int) bool {
+		if !rentals[i].StartedAt.Equal(rentals[j].StartedAt) {
+			return rentals[i].StartedAt.After(rentals[j].StartedAt)
+		}
+		return rentals[i].ID > rentals[j].ID
+	})
+	if filter.Limit > 0 && len(rentals) > filter.Limit {
+		rentals = rentals[:filter.Limit]
+	}
+
+	return rentals, nil
+}
+
+func (s *Store) RentedNodeIDs(ctx context.Context) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var nodeIDs []int
+	for _, id := range sortedIDs(s.nodes) {
+		if billing.StateOf(*s.nodes[id]).Billable() {
+			nodeIDs = append(nodeIDs, id)
+		}
+	}
+	return nodeIDs, nil
+}
+
+func (s *Store) BillNode(ctx context.Context, nodeID int, now time.Time) (bool, error) {
+	defer s.change(ctx)()
+
+	node, ok := s.nodes[nodeID]
+	if !ok {
+		return false, billing.ErrNodeNotFound
+	}
+	if !billing.StateOf(*node).Billable() {
+		return false, nil
+	}
+
+	if _, err := s.charge(node, now, false); err != nil {
+		return false, err
+	}
+
+	renter, ok := s.users[int(node.Renter.Int16)]
+	if !ok {
+		return false, billing.ErrUserNotFound
+	}
+	if !billing.Exhausted(renter.Balance) {
+		return false, nil
+	}
+
+	if _, err := s.release(ctx, node, 0, now, billing.EndReasonInsufficientBalance); err != nil {
+		return false, err
+	}
+	return true, nil
+}
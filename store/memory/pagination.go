@@ -0,0 +1,99 @@
+package memory
+
+import (
+	"bytes"
+	"encoding/json"
+	"hvmnd/api/store"
+	"sort"
+)
+
+// paginate orders rows like the Postgres keyset query, drops everything up to
+// the page cursor and trims the result to the page limit.
+func paginate[T any](rows []T, page store.Page, sortValue func(T, string) interface{}, id func(T) int) ([]T, store.PageInfo, error) {
+	info := store.PageInfo{Total: len(rows)}
+
+	var sortErr error
+	less := func(a, b T) bool {
+		if page.Sort != "id" {
+			// Both values come from rows, so compare them in the cursor
+			// representation used for ordering.
+			cmp, err := store.CompareSortValues(sortValue(a, page.Sort), jsonValue(sortValue(b, page.Sort)))
+			if err != nil {
+				sortErr = err
+			}
+			if cmp != 0 {
+				return (cmp < 0) != page.Desc
+			}
+		}
+		return (id(a) < id(b)) != page.Desc
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return less(rows[i], rows[j]) })
+	if sortErr != nil {
+		return nil, info, sortErr
+	}
+
+	start := 0
+	if page.After != nil {
+		start = len(rows)
+		for i, row := range rows {
+			after, err := isAfter(row, page, sortValue, id)
+			if err != nil {
+				return nil, info, err
+			}
+			if after {
+				start = i
+				break
+			}
+		}
+	}
+	rows = rows[start:]
+
+	if page.Overflows(len(rows)) {
+		rows = rows[:page.Limit]
+		last := rows[len(rows)-1]
+		info.NextCursor = page.Next(sortValue(last, page.Sort), id(last))
+	}
+
+	return rows, info, nil
+}
+
+// isAfter reports whether row comes after the page cursor in page order.
+func isAfter[T any](row T, page store.Page, sortValue func(T, string) interface{}, id func(T) int) (bool, error) {
+	cmp := 0
+	if page.Sort != "id" {
+		var err error
+		cmp, err = store.CompareSortValues(sortValue(row, page.Sort), page.After.Value)
+		if err != nil {
+			return false, err
+		}
+	}
+	if cmp == 0 {
+		switch {
+		case id(row) > page.After.ID:
+			cmp = 1
+		case id(row) < page.After.ID:
+			cmp = -1
+		}
+	}
+	if page.Desc {
+		return cmp < 0, nil
+	}
+	return cmp > 0, nil
+}
+
+// jsonValue converts a sort value to the form it takes inside a decoded
+// cursor.
+func jsonValue(value interface{}) interface{} {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil
+	}
+	return decoded
+}
@@ -0,0 +1,42 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"hvmnd/api/models"
+	"hvmnd/api/store"
+	"time"
+)
+
+func (s *Store) RecordPaymentEvent(ctx context.Context, event models.PaymentEvent) (models.PaymentEvent, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, stored := range s.paymentEvents {
+		if stored.Provider == event.Provider && stored.EventID == event.EventID {
+			return *stored, false, nil
+		}
+	}
+
+	event.ID = int64(s.nextID("payment_events"))
+	event.Payload = append([]byte(nil), event.Payload...)
+	event.Result = sql.NullString{}
+	event.ProcessedAt = sql.NullTime{}
+	s.paymentEvents = append(s.paymentEvents, &event)
+
+	return event, true, nil
+}
+
+func (s *Store) FinishPaymentEvent(ctx context.Context, id int64, result string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, event := range s.paymentEvents {
+		if event.ID == id {
+			event.Result = sql.NullString{String: result, Valid: true}
+			event.ProcessedAt = sql.NullTime{Time: now, Valid: true}
+			return nil
+		}
+	}
+	return store.ErrNotFound
+}
@@ -0,0 +1,209 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hvmnd/api/ledger"
+	"hvmnd/api/models"
+	"hvmnd/api/money"
+	"hvmnd/api/store"
+	"strconv"
+	"time"
+)
+
+func (s *Store) ListPayments(ctx context.Context, filter store.PaymentFilter, page store.Page) ([]models.Payment, store.PageInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var payments []models.Payment
+	for _, id := range sortedIDs(s.payments) {
+		payment := s.payments[id]
+		if filter.ID != 0 && payment.ID != filter.ID {
+			continue
+		}
+		if filter.UserID != 0 && payment.UserID != filter.UserID {
+			continue
+		}
+		if filter.Status != "" && payment.Status != filter.Status {
+			continue
+		}
+		payments = append(payments, *payment)
+	}
+
+	return paginate(payments, page, store.PaymentSortValue, func(p models.Payment) int { return p.ID })
+}
+
+func (s *Store) CreatePayment(ctx context.Context, userID int, amount money.Amount, paymentCurrency string, now time.Time) (int, error) {
+	defer s.change(ctx)()
+
+	if err := s.checkNotBanned(userID, now); err != nil {
+		return 0, err
+	}
+
+	payment := &models.Payment{
+		ID:       s.nextID("payments"),
+		UserID:   userID,
+		Amount:   amount,
+		Currency: paymentCurrency,
+		Status:   "unpaid",
+		Datetime: now,
+	}
+	s.payments[payment.ID] = payment
+
+	return payment.ID, nil
+}
+
+func (s *Store) CompletePayment(ctx context.Context, id int, force bool) (string, error) {
+	defer s.change(ctx)()
+
+	payment, ok := s.payments[id]
+	if !ok {
+		return "", store.ErrNotFound
+	}
+
+	previousStatus := payment.Status
+	if previousStatus != "unpaid" && !(force && previousStatus == "expired") {
+		return previousStatus, nil
+	}
+
+	// The ticket is credited at the rate in effect when it is completed
+	now := time.Now()
+	rate, err := s.exchangeRate(payment.Currency, now)
+	if err != nil {
+		return "", err
+	}
+	payment.ExchangeRate = sql.Null[money.Rate]{V: rate.Rate, Valid: true}
+
+	if err := s.post(payment.UserID, payment.ToBase(payment.Amount), ledger.ReasonPaymentCompleted, strconv.Itoa(id), now); err != nil {
+		payment.ExchangeRate = sql.Null[money.Rate]{}
+		return "", err
+	}
+	payment.Status = "paid"
+
+	return previousStatus, nil
+}
+
+func (s *Store) CancelPayment(ctx context.Context, id int, policy store.RefundPolicy) (string, error) {
+	defer s.change(ctx)()
+
+	payment, ok := s.payments[id]
+	if !ok {
+		return "", store.ErrNotFound
+	}
+
+	previousStatus := payment.Status
+	if previousStatus == "cancelled" {
+		return previousStatus, nil
+	}
+
+	// If the payment was "paid", take back what was not refunded yet
+	if previousStatus == "paid" {
+		amount, err := policy.Apply(payment.ToBase(payment.Refundable()), s.users[payment.UserID].Balance)
+		if err != nil {
+			return "", err
+		}
+		if amount > 0 {
+			if err := s.post(payment.UserID, -amount, ledger.ReasonPaymentCancelled, strconv.Itoa(id), time.Now()); err != nil {
+				return "", err
+			}
+		}
+	}
+	payment.Status = "cancelled"
+
+	return previousStatus, nil
+}
+
+func (s *Store) CancelUnpaidPayment(ctx context.Context, id int) (string, error) {
+	defer s.change(ctx)()
+
+	payment, ok := s.payments[id]
+	if !ok {
+		return "", store.ErrNotFound
+	}
+
+	previousStatus := payment.Status
+	if previousStatus == "unpaid" || previousStatus == "expired" {
+		payment.Status = "cancelled"
+	}
+	return previousStatus, nil
+}
+
+func (s *Store) ExpirePayments(ctx context.Context, cutoff time.Time) ([]int, error) {
+	defer s.change(ctx)()
+
+	var paymentIDs []int
+	for _, id := range sortedIDs(s.payments) {
+		payment := s.payments[id]
+		if payment.Status == "unpaid" && payment.Datetime.Before(cutoff) {
+			payment.Status = "expired"
+			paymentIDs = append(paymentIDs, id)
+		}
+	}
+	return paymentIDs, nil
+}
+
+func (s *Store) RefundPayment(ctx context.Context, paymentID int, amount money.Amount, reason string, policy store.RefundPolicy, now time.Time) (models.Refund, error) {
+	defer s.change(ctx)()
+
+	payment, ok := s.payments[paymentID]
+	if !ok {
+		return models.Refund{}, store.ErrNotFound
+	}
+
+	if payment.Status != "paid" {
+		return models.Refund{}, store.ErrPaymentNotRefundable
+	}
+	if amount > payment.Refundable() {
+		return models.Refund{}, store.ErrRefundTooLarge
+	}
+
+	// The balance is in the base currency and the refund in the ticket's
+	// currency, so the refund is converted before it is debited
+	debit, err := policy.Apply(payment.ToBase(amount), s.users[payment.UserID].Balance)
+	if err != nil {
+		return models.Refund{}, err
+	}
+	if debit < payment.ToBase(amount) {
+		// A clamped refund is what the debit buys back, and the debit is
+		// exactly what that refund is worth
+		amount = payment.FromBase(debit)
+		debit = payment.ToBase(amount)
+	}
+	if amount <= 0 || debit <= 0 {
+		return models.Refund{}, store.ErrInsufficientBalance
+	}
+
+	refund := models.Refund{
+		ID:        s.nextID("payment_refunds"),
+		PaymentID: paymentID,
+		Amount:    amount,
+		Reason:    reason,
+		CreatedBy: store.ActorFrom(ctx),
+		CreatedAt: now,
+	}
+	if err := s.post(payment.UserID, -debit, ledger.ReasonPaymentRefunded, fmt.Sprintf("refund:%d", refund.ID), now); err != nil {
+		return models.Refund{}, err
+	}
+	payment.RefundedAmount += amount
+	s.refunds = append(s.refunds, refund)
+
+	return refund, nil
+}
+
+func (s *Store) ListRefunds(ctx context.Context, paymentID int) ([]models.Refund, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.payments[paymentID]; !ok {
+		return nil, store.ErrNotFound
+	}
+
+	var refunds []models.Refund
+	for _, refund := range s.refunds {
+		if refund.PaymentID == paymentID {
+			refunds = append(refunds, refund)
+		}
+	}
+	return refunds, nil
+}
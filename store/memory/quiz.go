@@ -0,0 +1,42 @@
+package memory
+
+import (
+	"context"
+	"hvmnd/api/store"
+	"time"
+)
+
+func (s *Store) SaveHashMapping(ctx context.Context, hash, question, answer string) error {
+	defer s.change(ctx)()
+
+	// ON CONFLICT (hash) DO NOTHING
+	if _, ok := s.quizHashes[hash]; !ok {
+		s.quizHashes[hash] = [2]string{question, answer}
+	}
+	return nil
+}
+
+func (s *Store) GetQuestionAnswer(ctx context.Context, hash string) (string, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mapping, ok := s.quizHashes[hash]
+	if !ok {
+		return "", "", store.ErrNotFound
+	}
+	return mapping[0], mapping[1], nil
+}
+
+func (s *Store) SaveUserAnswer(ctx context.Context, telegramID int, question, answer, hash string) error {
+	defer s.change(ctx)()
+
+	// Answers may be saved before the user registers
+	if user := s.userByTelegramID(telegramID); user != nil {
+		if err := s.checkNotBanned(user.ID, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	s.quizAnswers[quizAnswerKey{telegramID: telegramID, question: question}] = quizAnswer{answer: answer, hash: hash}
+	return nil
+}
@@ -0,0 +1,176 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hvmnd/api/billing"
+	"hvmnd/api/ledger"
+	"hvmnd/api/models"
+	"hvmnd/api/store"
+	"sort"
+	"time"
+)
+
+func (s *Store) ListUsers(ctx context.Context, filter store.UserFilter, page store.Page) ([]models.User, store.PageInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var users []models.User
+	for _, id := range sortedIDs(s.users) {
+		user := s.users[id]
+		if filter.TelegramID != 0 && user.TelegramID != filter.TelegramID {
+			continue
+		}
+		if filter.Username != "" && (!user.Username.Valid || user.Username.String != filter.Username) {
+			continue
+		}
+		if filter.ID != 0 && user.ID != filter.ID {
+			continue
+		}
+		users = append(users, *user)
+	}
+
+	return paginate(users, page, store.UserSortValue, func(u models.User) int { return u.ID })
+}
+
+func (s *Store) userByTelegramID(telegramID int) *models.User {
+	for _, user := range s.users {
+		if user.TelegramID == telegramID {
+			return user
+		}
+	}
+	return nil
+}
+
+// setNullString overwrites dst when value is provided, like COALESCE in the
+// Postgres upsert.
+func setNullString(dst *sql.NullString, value *string) {
+	if value != nil {
+		*dst = sql.NullString{String: *value, Valid: true}
+	}
+}
+
+func (s *Store) UpsertUser(ctx context.Context, input models.UserInput) (models.User, error) {
+	defer s.change(ctx)()
+
+	user := s.userByTelegramID(input.TelegramID)
+	if user == nil {
+		user = &models.User{
+			ID:         s.nextID("users"),
+			TelegramID: input.TelegramID,
+			Banned:     sql.NullBool{Bool: false, Valid: true},
+		}
+		s.users[user.ID] = user
+	}
+
+	setNullString(&user.FirstName, input.FirstName)
+	setNullString(&user.LastName, input.LastName)
+	setNullString(&user.Username, input.Username)
+	setNullString(&user.LanguageCode, input.LanguageCode)
+	return *user, nil
+}
+
+func (s *Store) AdjustUser(ctx context.Context, adjustment models.Adjustment) (models.Adjustment, models.User, error) {
+	defer s.change(ctx)()
+
+	user, ok := s.users[adjustment.UserID]
+	if !ok {
+		return models.Adjustment{}, models.User{}, store.ErrNotFound
+	}
+
+	adjustment.ID = s.nextID("user_adjustments")
+	adjustment.CreatedBy = store.ActorFrom(ctx)
+
+	if adjustment.BalanceDelta != 0 {
+		reference := fmt.Sprintf("adjustment:%d", adjustment.ID)
+		if err := s.post(user.ID, adjustment.BalanceDelta, ledger.ReasonManualAdjustment, reference, adjustment.CreatedAt); err != nil {
+			return models.Adjustment{}, models.User{}, err
+		}
+	}
+	user.TotalSpent += adjustment.TotalSpentDelta
+	s.adjustments = append(s.adjustments, adjustment)
+
+	return adjustment, *user, nil
+}
+
+func (s *Store) Ledger(ctx context.Context, userID int, limit int) (store.LedgerSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return store.LedgerSummary{}, store.ErrNotFound
+	}
+
+	summary := store.LedgerSummary{Balance: user.Balance}
+	for _, line := range s.ledger {
+		if line.userID == userID {
+			summary.LedgerBalance += line.entry.Amount
+			summary.Entries = append(summary.Entries, line.entry)
+		}
+	}
+	summary.Reconciled = summary.Balance == summary.LedgerBalance
+
+	// Newest first, as in Postgres
+	sort.Slice(summary.Entries, func(i, j int) bool {
+		return summary.Entries[i].ID > summary.Entries[j].ID
+	})
+	if limit > 0 && len(summary.Entries) > limit {
+		summary.Entries = summary.Entries[:limit]
+	}
+
+	return summary, nil
+}
+
+// checkNotBanned mirrors the Postgres check on writes for a user.
+func (s *Store) checkNotBanned(userID int, now time.Time) error {
+	user, ok := s.users[userID]
+	if !ok {
+		return store.ErrNotFound
+	}
+	if user.BannedAt(now) {
+		return store.ErrUserBanned
+	}
+	return nil
+}
+
+func (s *Store) BanUser(ctx context.Context, userID int, reason string, until time.Time, now time.Time) (models.User, []int, error) {
+	defer s.change(ctx)()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return models.User{}, nil, store.ErrNotFound
+	}
+	user.Banned = sql.NullBool{Bool: true, Valid: true}
+	user.BanReason = sql.NullString{String: reason, Valid: true}
+	user.BannedUntil = sql.NullTime{Time: until, Valid: !until.IsZero()}
+
+	var released []int
+	for _, nodeID := range sortedIDs(s.nodes) {
+		node := s.nodes[nodeID]
+		if node.Status != models.NodeStatusRented || !node.Renter.Valid || int(node.Renter.Int16) != userID {
+			continue
+		}
+		if _, err := s.release(ctx, node, userID, now, billing.EndReasonBanned); err != nil {
+			return models.User{}, nil, fmt.Errorf("releasing node %d: %w", nodeID, err)
+		}
+		released = append(released, nodeID)
+	}
+
+	return *user, released, nil
+}
+
+func (s *Store) UnbanUser(ctx context.Context, userID int) (models.User, error) {
+	defer s.change(ctx)()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return models.User{}, store.ErrNotFound
+	}
+	user.Banned = sql.NullBool{Bool: false, Valid: true}
+	user.BanReason = sql.NullString{}
+	user.BannedUntil = sql.NullTime{}
+
+	return *user, nil
+}
@@ -0,0 +1,263 @@
+package store
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hvmnd/api/models"
+	"hvmnd/api/money"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPageLimit = 100
+	MaxPageLimit     = 1000
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Sortable columns per resource. Only NOT NULL columns are allowed so that
+// keyset pagination over (column, id) is well defined.
+var (
+	UserSortColumns    = []string{"id", "telegram_id", "balance", "total_spent"}
+	NodeSortColumns    = []string{"id", "price", "status"}
+	PaymentSortColumns = []string{"id", "datetime", "amount", "status"}
+	AuditSortColumns   = []string{"id", "created_at"}
+)
+
+// sortKinds holds a value of the type of every sortable column. Cursor
+// values are checked against it before they reach a query.
+var sortKinds = map[string]interface{}{
+	"id":          0,
+	"telegram_id": 0,
+	"balance":     money.Amount(0),
+	"total_spent": money.Amount(0),
+	"price":       money.Amount(0),
+	"amount":      money.Amount(0),
+	"status":      "",
+	"datetime":    time.Time{},
+	"created_at":  time.Time{},
+}
+
+// Page selects one page of a list ordered by Sort then id. A Limit of 0
+// selects every row.
+type Page struct {
+	Limit int
+	Sort  string
+	Desc  bool
+	After *Cursor
+}
+
+// PageInfo describes the page a list call returned.
+type PageInfo struct {
+	Total      int
+	NextCursor string
+}
+
+// Cursor is the position after the last row of a page. It is handed to
+// clients as an opaque token.
+type Cursor struct {
+	Sort  string      `json:"s"`
+	Desc  bool        `json:"d,omitempty"`
+	Value interface{} `json:"v"`
+	ID    int         `json:"id"`
+}
+
+func (c Cursor) Encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// DecodeCursor parses a token produced by Cursor.Encode.
+func DecodeCursor(token string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	// Keep numbers as json.Number so integer keys survive as exact literals
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var cursor Cursor
+	if err := decoder.Decode(&cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// NewPage validates the sort column against allowed and the cursor against
+// the requested ordering. Without a limit or a cursor every row is selected,
+// as before lists were paginated; a cursor alone gets DefaultPageLimit rows.
+func NewPage(limit int, sort string, order string, cursor string, allowed []string) (Page, error) {
+	page := Page{Limit: limit, Sort: sort}
+
+	if page.Limit < 0 {
+		return page, errors.New("limit must not be negative")
+	}
+	if page.Limit == 0 && cursor != "" {
+		page.Limit = DefaultPageLimit
+	}
+	if page.Limit > MaxPageLimit {
+		page.Limit = MaxPageLimit
+	}
+
+	if page.Sort == "" {
+		page.Sort = "id"
+	}
+	if !contains(allowed, page.Sort) {
+		return page, fmt.Errorf("sort must be one of %s", strings.Join(allowed, ", "))
+	}
+
+	switch order {
+	case "", "asc":
+	case "desc":
+		page.Desc = true
+	default:
+		return page, errors.New("order must be asc or desc")
+	}
+
+	if cursor != "" {
+		after, err := DecodeCursor(cursor)
+		if err != nil {
+			return page, err
+		}
+		if after.Sort != page.Sort || after.Desc != page.Desc {
+			return page, errors.New("cursor does not match the requested sort and order")
+		}
+		if _, err := CompareSortValues(sortKinds[page.Sort], after.Value); err != nil {
+			return page, ErrInvalidCursor
+		}
+		page.After = after
+	}
+
+	return page, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Overflows reports whether n rows are more than fit on the page, so that
+// another page follows.
+func (p Page) Overflows(n int) bool {
+	return p.Limit > 0 && n > p.Limit
+}
+
+// Next returns the cursor that continues after a row with the given sort
+// value and id.
+func (p Page) Next(value interface{}, id int) string {
+	return Cursor{Sort: p.Sort, Desc: p.Desc, Value: value, ID: id}.Encode()
+}
+
+func UserSortValue(user models.User, column string) interface{} {
+	switch column {
+	case "telegram_id":
+		return user.TelegramID
+	case "balance":
+		return user.Balance
+	case "total_spent":
+		return user.TotalSpent
+	}
+	return user.ID
+}
+
+func NodeSortValue(node models.Node, column string) interface{} {
+	switch column {
+	case "price":
+		return node.Price
+	case "status":
+		return node.Status
+	}
+	return node.ID
+}
+
+func PaymentSortValue(payment models.Payment, column string) interface{} {
+	switch column {
+	case "datetime":
+		return payment.Datetime
+	case "amount":
+		return payment.Amount
+	case "status":
+		return payment.Status
+	}
+	return payment.ID
+}
+
+func AuditSortValue(entry models.AuditEntry, column string) interface{} {
+	if column == "created_at" {
+		return entry.CreatedAt
+	}
+	return entry.ID
+}
+
+// CompareSortValues orders a row's sort value against a decoded cursor value,
+// in which numbers are json.Number and times are RFC 3339 strings.
+func CompareSortValues(row interface{}, cursor interface{}) (int, error) {
+	switch v := row.(type) {
+	case int:
+		c, err := cursorNumber(cursor)
+		if err != nil {
+			return 0, err
+		}
+		return compareFloat(float64(v), c), nil
+	case money.Amount:
+		number, ok := cursor.(json.Number)
+		if !ok {
+			return 0, ErrInvalidCursor
+		}
+		c, err := money.Parse(number.String())
+		if err != nil {
+			return 0, ErrInvalidCursor
+		}
+		return cmp.Compare(v, c), nil
+	case string:
+		c, ok := cursor.(string)
+		if !ok {
+			return 0, ErrInvalidCursor
+		}
+		return strings.Compare(v, c), nil
+	case time.Time:
+		s, ok := cursor.(string)
+		if !ok {
+			return 0, ErrInvalidCursor
+		}
+		c, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return 0, ErrInvalidCursor
+		}
+		return v.Compare(c), nil
+	}
+	return 0, fmt.Errorf("unsupported sort value %T", row)
+}
+
+func cursorNumber(value interface{}) (float64, error) {
+	number, ok := value.(json.Number)
+	if !ok {
+		return 0, ErrInvalidCursor
+	}
+	f, err := number.Float64()
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	return f, nil
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestEverySortColumnHasAKind(t *testing.T) {
+	for _, columns := range [][]string{UserSortColumns, NodeSortColumns, PaymentSortColumns, AuditSortColumns} {
+		for _, column := range columns {
+			if _, ok := sortKinds[column]; !ok {
+				t.Errorf("sort column %q has no kind", column)
+			}
+		}
+	}
+}
+
+func TestNewPageLimits(t *testing.T) {
+	page, err := NewPage(0, "", "", "", UserSortColumns)
+	if err != nil || page.Limit != 0 || page.Overflows(DefaultPageLimit+1) {
+		t.Fatalf("want every row without a limit or cursor, got %+v, %v", page, err)
+	}
+
+	cursor := Cursor{Sort: "id", Value: 5, ID: 5}.Encode()
+	page, err = NewPage(0, "", "", cursor, UserSortColumns)
+	if err != nil || page.Limit != DefaultPageLimit {
+		t.Fatalf("want the default limit with a cursor, got %+v, %v", page, err)
+	}
+
+	page, err = NewPage(MaxPageLimit+1, "", "", "", UserSortColumns)
+	if err != nil || page.Limit != MaxPageLimit {
+		t.Fatalf("want the limit capped, got %+v, %v", page, err)
+	}
+
+	if _, err := NewPage(-1, "", "", "", UserSortColumns); err == nil {
+		t.Fatal("want a negative limit rejected")
+	}
+}
+
+func TestNewPageRejectsMalformedCursors(t *testing.T) {
+	tests := map[string]string{
+		"not base64":    "%%%",
+		"not json":      base64.RawURLEncoding.EncodeToString([]byte("{")),
+		"text amount":   Cursor{Sort: "balance", Value: "abc", ID: 1}.Encode(),
+		"numeric time":  Cursor{Sort: "created_at", Value: 5, ID: 1}.Encode(),
+		"wrong sort":    Cursor{Sort: "telegram_id", Value: 5, ID: 1}.Encode(),
+		"fraction cent": Cursor{Sort: "balance", Value: 0.001, ID: 1}.Encode(),
+	}
+	for name, cursor := range tests {
+		if _, err := NewPage(10, "balance", "", cursor, UserSortColumns); err == nil {
+			t.Errorf("%s: want the cursor rejected", name)
+		}
+	}
+
+	valid := Cursor{Sort: "balance", Value: 12.5, ID: 1}.Encode()
+	if _, err := NewPage(10, "balance", "", valid, UserSortColumns); err != nil {
+		t.Fatalf("want a valid cursor accepted, got %v", err)
+	}
+}
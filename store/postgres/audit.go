@@ -0,0 +1,92 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"hvmnd/api/models"
+	"hvmnd/api/store"
+)
+
+func (s *Store) ListAudit(ctx context.Context, filter store.AuditFilter, page store.Page) ([]models.AuditEntry, store.PageInfo, error) {
+	var info store.PageInfo
+
+	conditions := ""
+	var args []interface{}
+	argIndex := 1
+
+	for _, condition := range []struct {
+		column string
+		value  string
+	}{
+		{"actor", filter.Actor},
+		{"route", filter.Route},
+		{"entity_type", filter.EntityType},
+		{"entity_id", filter.EntityID},
+	} {
+		if condition.value == "" {
+			continue
+		}
+		conditions += fmt.Sprintf(" AND %s = $%d", condition.column, argIndex)
+		args = append(args, condition.value)
+		argIndex++
+	}
+	if !filter.From.IsZero() {
+		conditions += fmt.Sprintf(" AND created_at >= $%d", argIndex)
+		args = append(args, filter.From)
+		argIndex++
+	}
+	if !filter.To.IsZero() {
+		conditions += fmt.Sprintf(" AND created_at <= $%d", argIndex)
+		args = append(args, filter.To)
+		argIndex++
+	}
+
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_log WHERE 1=1"+conditions, args...).Scan(&info.Total)
+	if err != nil {
+		return nil, info, err
+	}
+
+	keyset, suffix, pageArgs := pageClauses(page, argIndex)
+	query := `
+		SELECT id, actor, route, entity_type, entity_id, before, after, created_at
+		FROM audit_log WHERE 1=1
+	` + conditions + keyset + suffix
+
+	rows, err := s.db.QueryContext(ctx, query, append(args, pageArgs...)...)
+	if err != nil {
+		return nil, info, err
+	}
+	defer rows.Close()
+
+	var entries []models.AuditEntry
+	for rows.Next() {
+		var entry models.AuditEntry
+		var before, after []byte
+		err := rows.Scan(
+			&entry.ID,
+			&entry.Actor,
+			&entry.Route,
+			&entry.EntityType,
+			&entry.EntityID,
+			&before,
+			&after,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, info, err
+		}
+		entry.Before, entry.After = before, after
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, info, err
+	}
+
+	if page.Overflows(len(entries)) {
+		entries = entries[:page.Limit]
+		last := entries[len(entries)-1]
+		info.NextCursor = page.Next(store.AuditSortValue(last, page.Sort), last.ID)
+	}
+
+	return entries, info, nil
+}
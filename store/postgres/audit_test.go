@@ -0,0 +1,59 @@
+package postgres
+
+import (
+	"context"
+	"hvmnd/api/models"
+	"hvmnd/api/money"
+	"hvmnd/api/store"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditedChangesAreLoggedWithThem(t *testing.T) {
+	s := testStore(t)
+	user := testUser(t, s)
+	id := strconv.Itoa(user.ID)
+
+	audited := func() []models.AuditEntry {
+		t.Helper()
+		entries, _, err := s.ListAudit(context.Background(), store.AuditFilter{EntityType: "user", EntityID: id}, store.Page{Limit: 100, Sort: "id"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return entries
+	}
+	adjust := func(ctx context.Context) {
+		t.Helper()
+		_, _, err := s.AdjustUser(ctx, models.Adjustment{
+			UserID:       user.ID,
+			BalanceDelta: money.MustParse("5"),
+			Reason:       "test",
+			CreatedAt:    time.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Without a route the change is not an API mutation
+	adjust(context.Background())
+	if entries := audited(); len(entries) != 0 {
+		t.Fatalf("want nothing logged without a route, got %+v", entries)
+	}
+
+	route := "POST /api/v1/users/{id}/adjustments"
+	adjust(store.WithRoute(store.WithActor(context.Background(), "api-key:1:test"), route))
+	entries := audited()
+	if len(entries) != 1 {
+		t.Fatalf("want one entry for the user, got %+v", entries)
+	}
+	entry := entries[0]
+	if entry.Actor != "api-key:1:test" || entry.Route != route {
+		t.Fatalf("want the actor and route recorded, got %+v", entry)
+	}
+	if !strings.Contains(string(entry.Before), `"balance": 5.00`) || !strings.Contains(string(entry.After), `"balance": 10.00`) {
+		t.Fatalf("want only the balance change recorded, got %s -> %s", entry.Before, entry.After)
+	}
+}
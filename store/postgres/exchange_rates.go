@@ -0,0 +1,90 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"hvmnd/api/currency"
+	"hvmnd/api/models"
+	"hvmnd/api/money"
+	"hvmnd/api/store"
+	"time"
+)
+
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// exchangeRate looks up the rate of code in effect at the given time.
+func exchangeRate(ctx context.Context, db rowQueryer, code string, at time.Time) (models.ExchangeRate, error) {
+	if code == currency.Base() {
+		return models.ExchangeRate{Currency: code, Rate: money.UnitRate}, nil
+	}
+
+	query := `
+		SELECT currency, rate, effective_from, created_at
+		FROM exchange_rates
+		WHERE currency = $1 AND effective_from <= $2
+		ORDER BY effective_from DESC
+		LIMIT 1
+	`
+	var rate models.ExchangeRate
+	err := db.QueryRowContext(ctx, query, code, at).Scan(
+		&rate.Currency,
+		&rate.Rate,
+		&rate.EffectiveFrom,
+		&rate.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		err = store.ErrNoExchangeRate
+	}
+	return rate, err
+}
+
+func (s *Store) ExchangeRate(ctx context.Context, code string, at time.Time) (models.ExchangeRate, error) {
+	return exchangeRate(ctx, s.db, code, at)
+}
+
+func (s *Store) ListExchangeRates(ctx context.Context, code string) ([]models.ExchangeRate, error) {
+	query := `
+		SELECT currency, rate, effective_from, created_at
+		FROM exchange_rates
+		WHERE $1 = '' OR currency = $1
+		ORDER BY effective_from DESC, currency
+	`
+	rows, err := s.db.QueryContext(ctx, query, code)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rates []models.ExchangeRate
+	for rows.Next() {
+		var rate models.ExchangeRate
+		err := rows.Scan(
+			&rate.Currency,
+			&rate.Rate,
+			&rate.EffectiveFrom,
+			&rate.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+
+	return rates, rows.Err()
+}
+
+func (s *Store) SetExchangeRate(ctx context.Context, rate models.ExchangeRate) (models.ExchangeRate, error) {
+	query := `
+		INSERT INTO exchange_rates (currency, rate, effective_from, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (currency, effective_from) DO UPDATE
+		SET rate = EXCLUDED.rate, created_at = EXCLUDED.created_at
+	`
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, rate.Currency, rate.Rate, rate.EffectiveFrom, rate.CreatedAt)
+		return err
+	})
+	return rate, err
+}
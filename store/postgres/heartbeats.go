@@ -0,0 +1,171 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"hvmnd/api/billing"
+	"hvmnd/api/models"
+	"hvmnd/api/store"
+	"time"
+)
+
+func (s *Store) RecordHeartbeat(ctx context.Context, heartbeat models.Heartbeat) (models.Node, error) {
+	var node models.Node
+
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var nodeID int
+		var address string
+
+		query := `
+			SELECT id, any_desk_address
+			FROM nodes
+			WHERE machine_id = $1 AND status <> 'decommissioned'
+			ORDER BY id
+			LIMIT 1
+			FOR UPDATE
+		`
+		err := tx.QueryRowContext(ctx, query, heartbeat.MachineID).Scan(&nodeID, &address)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return store.ErrNotFound
+			}
+			return err
+		}
+
+		// The agent knows the AnyDesk id the machine currently has
+		if heartbeat.AnyDeskAddress != address {
+			_, err := tx.ExecContext(ctx, "UPDATE nodes SET any_desk_address = $1 WHERE id = $2", heartbeat.AnyDeskAddress, nodeID)
+			if err != nil {
+				return nodeWriteError(err)
+			}
+		}
+
+		// Only a node the monitor took offline comes back by itself
+		query = `
+			UPDATE nodes SET
+			last_heartbeat_at = $1,
+			status = CASE WHEN status = 'offline' AND heartbeat_lost THEN 'available' ELSE status END,
+			heartbeat_lost = FALSE
+			WHERE id = $2
+		`
+		if _, err := tx.ExecContext(ctx, query, heartbeat.ReceivedAt, nodeID); err != nil {
+			return err
+		}
+
+		if err := billing.Resume(ctx, tx, nodeID, heartbeat.ReceivedAt); err != nil {
+			return err
+		}
+
+		query = `
+			INSERT INTO node_heartbeats (
+				node_id, received_at, uptime_seconds, any_desk_address,
+				cpu_percent, memory_percent, gpu_percent
+			)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (node_id) DO UPDATE
+			SET
+				received_at = EXCLUDED.received_at,
+				uptime_seconds = EXCLUDED.uptime_seconds,
+				any_desk_address = EXCLUDED.any_desk_address,
+				cpu_percent = EXCLUDED.cpu_percent,
+				memory_percent = EXCLUDED.memory_percent,
+				gpu_percent = EXCLUDED.gpu_percent
+		`
+		_, err = tx.ExecContext(
+			ctx,
+			query,
+			nodeID,
+			heartbeat.ReceivedAt,
+			heartbeat.UptimeSeconds,
+			heartbeat.AnyDeskAddress,
+			heartbeat.CPUPercent,
+			heartbeat.MemoryPercent,
+			heartbeat.GPUPercent,
+		)
+		if err != nil {
+			return err
+		}
+
+		node, err = scanNode(tx.QueryRowContext(ctx, "SELECT"+nodeColumns+"FROM nodes WHERE id = $1", nodeID))
+		if err != nil {
+			return err
+		}
+
+		updated := []models.Node{node}
+		if err := loadSoftware(ctx, tx, updated); err != nil {
+			return err
+		}
+		node = updated[0]
+		return nil
+	})
+
+	return node, err
+}
+
+func (s *Store) MarkOffline(ctx context.Context, cutoff time.Time) ([]int, error) {
+	var nodeIDs []int
+
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		query := `
+			UPDATE nodes SET status = 'offline', heartbeat_lost = TRUE
+			WHERE status = 'available' AND last_heartbeat_at < $1
+			RETURNING id
+		`
+		rows, err := tx.QueryContext(ctx, query, cutoff)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			nodeIDs = append(nodeIDs, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		// Rented nodes keep their renter; only the billing stops
+		type quietNode struct {
+			id       int
+			lastSeen time.Time
+		}
+		var quiet []quietNode
+
+		query = `
+			SELECT id, last_heartbeat_at
+			FROM nodes
+			WHERE status = 'rented' AND billing_paused_at IS NULL AND last_heartbeat_at < $1
+		`
+		rows, err = tx.QueryContext(ctx, query, cutoff)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var node quietNode
+			if err := rows.Scan(&node.id, &node.lastSeen); err != nil {
+				rows.Close()
+				return err
+			}
+			quiet = append(quiet, node)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, node := range quiet {
+			if err := billing.Pause(ctx, tx, node.id, node.lastSeen); err != nil {
+				return err
+			}
+			nodeIDs = append(nodeIDs, node.id)
+		}
+
+		return nil
+	})
+
+	return nodeIDs, err
+}
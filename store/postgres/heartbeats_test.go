@@ -0,0 +1,61 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"hvmnd/api/models"
+	"hvmnd/api/money"
+	"hvmnd/api/store"
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestOnlyNodesTheMonitorTookOfflineComeBack(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+
+	machineID := fmt.Sprintf("test-machine-%d", rand.Int63())
+	price := money.MustParse("10")
+	password := "secret"
+	node, err := s.CreateNode(ctx, models.NodeInput{
+		AnyDeskAddress:  &machineID,
+		AnyDeskPassword: &password,
+		Price:           &price,
+		MachineID:       &machineID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	beat := func(at time.Time) models.Node {
+		t.Helper()
+		node, err := s.RecordHeartbeat(ctx, models.Heartbeat{MachineID: machineID, AnyDeskAddress: machineID, ReceivedAt: at})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return node
+	}
+
+	// Heartbeats from long ago keep the cutoff clear of other tests' nodes
+	lastSeen := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	beat(lastSeen)
+	marked, err := s.MarkOffline(ctx, lastSeen.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(marked, node.ID) {
+		t.Fatalf("want node %d marked offline, got %v", node.ID, marked)
+	}
+	if node := beat(lastSeen.Add(time.Minute)); node.Status != models.NodeStatusAvailable {
+		t.Fatalf("want the node back once it reports, got %q", node.Status)
+	}
+
+	changes := map[string]interface{}{"status": models.NodeStatusOffline}
+	if err := s.UpdateNode(ctx, store.NodeKey{ID: &node.ID}, changes); err != nil {
+		t.Fatal(err)
+	}
+	if node := beat(lastSeen.Add(2 * time.Minute)); node.Status != models.NodeStatusOffline {
+		t.Fatalf("want a node taken offline by hand to stay offline, got %q", node.Status)
+	}
+}
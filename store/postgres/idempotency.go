@@ -0,0 +1,92 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"hvmnd/api/idempotency"
+	"time"
+)
+
+func (s *Store) ClaimIdempotencyKey(ctx context.Context, scope, key, fingerprint string, now, expiresAt time.Time) (idempotency.Record, bool, error) {
+	// An expired record is taken over as if it did not exist
+	query := `
+		INSERT INTO idempotency_keys (scope, key, fingerprint, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (scope, key) DO UPDATE
+		SET
+			fingerprint = EXCLUDED.fingerprint,
+			status_code = NULL,
+			content_type = NULL,
+			body = NULL,
+			created_at = EXCLUDED.created_at,
+			expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= EXCLUDED.created_at
+	`
+	result, err := s.db.ExecContext(ctx, query, scope, key, fingerprint, now, expiresAt)
+	if err != nil {
+		return idempotency.Record{}, false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return idempotency.Record{}, false, err
+	}
+	if rowsAffected == 1 {
+		return idempotency.Record{Fingerprint: fingerprint}, true, nil
+	}
+
+	var record idempotency.Record
+	var statusCode sql.NullInt32
+	var contentType sql.NullString
+
+	query = `
+		SELECT fingerprint, status_code, content_type, body
+		FROM idempotency_keys
+		WHERE scope = $1 AND key = $2
+	`
+	err = s.db.QueryRowContext(ctx, query, scope, key).Scan(
+		&record.Fingerprint,
+		&statusCode,
+		&contentType,
+		&record.Body,
+	)
+	if err == sql.ErrNoRows {
+		// Released by the request that held it; report it as still in
+		// flight and let the client retry.
+		return idempotency.Record{Fingerprint: fingerprint}, false, nil
+	}
+	if err != nil {
+		return idempotency.Record{}, false, err
+	}
+
+	record.StatusCode = int(statusCode.Int32)
+	record.ContentType = contentType.String
+	return record, false, nil
+}
+
+func (s *Store) SaveIdempotentResponse(ctx context.Context, scope, key string, record idempotency.Record) error {
+	query := `
+		UPDATE idempotency_keys SET
+		status_code = $1,
+		content_type = $2,
+		body = $3
+		WHERE scope = $4 AND key = $5
+	`
+	_, err := s.db.ExecContext(ctx, query, record.StatusCode, record.ContentType, record.Body, scope, key)
+	return err
+}
+
+func (s *Store) ReleaseIdempotencyKey(ctx context.Context, scope, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2", scope, key)
+	return err
+}
+
+func (s *Store) PurgeIdempotencyKeys(ctx context.Context, now time.Time) (int, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= $1", now)
+	if err != nil {
+		return 0, err
+	}
+
+	purged, err := result.RowsAffected()
+	return int(purged), err
+}
@@ -0,0 +1,79 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"hvmnd/api/auth"
+)
+
+func (s *Store) CreateKey(ctx context.Context, name string, keyHash string, role auth.Role, machineID string) (auth.APIKey, error) {
+	query := `
+		INSERT INTO api_keys (name, key_hash, role, machine_id)
+		VALUES ($1, $2, $3, NULLIF($4, ''))
+		RETURNING id, name, role, machine_id, created_at, revoked_at
+	`
+	var key auth.APIKey
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		return tx.QueryRowContext(ctx, query, name, keyHash, role, machineID).Scan(
+			&key.ID,
+			&key.Name,
+			&key.Role,
+			&key.MachineID,
+			&key.CreatedAt,
+			&key.RevokedAt,
+		)
+	})
+	return key, err
+}
+
+func (s *Store) RevokeKey(ctx context.Context, id int) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, "UPDATE api_keys SET revoked_at = COALESCE(revoked_at, now()) WHERE id = $1", id)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return auth.ErrKeyNotFound
+		}
+
+		return nil
+	})
+}
+
+func (s *Store) ListKeys(ctx context.Context) ([]auth.APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, role, machine_id, created_at, revoked_at FROM api_keys ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []auth.APIKey
+	for rows.Next() {
+		var key auth.APIKey
+		if err := rows.Scan(&key.ID, &key.Name, &key.Role, &key.MachineID, &key.CreatedAt, &key.RevokedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (s *Store) FindKey(ctx context.Context, keyHash string) (auth.Principal, error) {
+	query := `
+		SELECT id, name, role, COALESCE(machine_id, '')
+		FROM api_keys
+		WHERE key_hash = $1 AND revoked_at IS NULL
+	`
+	var principal auth.Principal
+	err := s.db.QueryRowContext(ctx, query, keyHash).Scan(&principal.KeyID, &principal.Name, &principal.Role, &principal.MachineID)
+	if err == sql.ErrNoRows {
+		return auth.Principal{}, auth.ErrKeyNotFound
+	}
+	return principal, err
+}
@@ -0,0 +1,624 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hvmnd/api/billing"
+	"hvmnd/api/models"
+	"hvmnd/api/money"
+	"hvmnd/api/store"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// updatableNodeColumns lists the columns UpdateNode may write.
+var updatableNodeColumns = map[string]bool{
+	"status":           true,
+	"software":         true,
+	"price":            true,
+	"cpu":              true,
+	"gpu":              true,
+	"other_specs":      true,
+	"licenses":         true,
+	"machine_id":       true,
+	"old_id":           true,
+	"any_desk_address": true,
+	"gpu_model":        true,
+	"vram_gb":          true,
+	"cpu_cores":        true,
+	"ram_gb":           true,
+	"storage_gb":       true,
+}
+
+// nodeColumns is the column list scanNode expects.
+const nodeColumns = `
+	id, old_id, any_desk_address,
+	status, software,
+	price, renter, rent_start_time,
+	last_balance_update_timestamp,
+	cpu, gpu, other_specs, licenses,
+	machine_id, last_heartbeat_at, billing_paused_at,
+	gpu_model, vram_gb, cpu_cores, ram_gb, storage_gb
+`
+
+func scanNode(row interface{ Scan(...interface{}) error }) (models.Node, error) {
+	var node models.Node
+	err := row.Scan(
+		&node.ID,
+		&node.OldID,
+		&node.AnyDeskAddress,
+		&node.Status,
+		&node.Software,
+		&node.Price,
+		&node.Renter,
+		&node.RentStartTime,
+		&node.LastBalanceUpdateTimestamp,
+		&node.CPU,
+		&node.GPU,
+		&node.OtherSpecs,
+		&node.Licenses,
+		&node.MachineID,
+		&node.LastHeartbeatAt,
+		&node.BillingPausedAt,
+		&node.GPUModel,
+		&node.VRAMGB,
+		&node.CPUCores,
+		&node.RAMGB,
+		&node.StorageGB,
+	)
+	return node, err
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// loadSoftware fills in the software catalog of nodes.
+func loadSoftware(ctx context.Context, q queryer, nodes []models.Node) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	index := make(map[int]int, len(nodes))
+	ids := make([]int64, len(nodes))
+	for i, node := range nodes {
+		index[node.ID] = i
+		ids[i] = int64(node.ID)
+		nodes[i].SoftwareCatalog = []models.NodeSoftware{}
+	}
+
+	rows, err := q.QueryContext(
+		ctx,
+		"SELECT node_id, name, kind FROM node_software WHERE node_id = ANY($1) ORDER BY node_id, kind, name",
+		pq.Array(ids),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var nodeID int
+		var item models.NodeSoftware
+		if err := rows.Scan(&nodeID, &item.Name, &item.Kind); err != nil {
+			return err
+		}
+		i := index[nodeID]
+		nodes[i].SoftwareCatalog = append(nodes[i].SoftwareCatalog, item)
+	}
+
+	return rows.Err()
+}
+
+// replaceSoftware sets the software catalog of a node to items.
+func replaceSoftware(ctx context.Context, tx *sql.Tx, nodeID int, items []models.NodeSoftware) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM node_software WHERE node_id = $1", nodeID); err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		_, err := tx.ExecContext(
+			ctx,
+			"INSERT INTO node_software (node_id, name, kind) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+			nodeID,
+			item.Name,
+			item.Kind,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// nodeWriteError translates unique violations on the nodes table.
+func nodeWriteError(err error) error {
+	switch uniqueViolation(err) {
+	case "nodes_any_desk_address_key":
+		return store.ErrDuplicateAnyDeskAddress
+	case "nodes_machine_id_active_key":
+		return store.ErrDuplicateMachineID
+	}
+	return err
+}
+
+func (s *Store) ListNodes(ctx context.Context, filter store.NodeFilter, page store.Page) ([]models.Node, store.PageInfo, error) {
+	var info store.PageInfo
+
+	conditions := ""
+	var args []interface{}
+	argIndex := 1
+
+	if filter.ID != 0 {
+		conditions += fmt.Sprintf(" AND id = $%d", argIndex)
+		args = append(args, filter.ID)
+		argIndex++
+	}
+
+	if filter.RenterNotNull {
+		conditions += " AND renter IS NOT NULL"
+	} else if filter.Renter != 0 {
+		conditions += fmt.Sprintf(" AND renter = $%d", argIndex)
+		args = append(args, filter.Renter)
+		argIndex++
+	}
+
+	if filter.Status != "" {
+		conditions += fmt.Sprintf(" AND status = $%d", argIndex)
+		args = append(args, filter.Status)
+		argIndex++
+	} else {
+		conditions += " AND status <> 'decommissioned'"
+	}
+
+	if filter.AnyDeskAddress != "" {
+		conditions += fmt.Sprintf(" AND any_desk_address = $%d", argIndex)
+		args = append(args, filter.AnyDeskAddress)
+		argIndex++
+	}
+	if filter.OldID != 0 {
+		conditions += fmt.Sprintf(" AND old_id = $%d", argIndex)
+		args = append(args, filter.OldID)
+		argIndex++
+	}
+
+	for _, software := range filter.Software {
+		conditions += fmt.Sprintf(`
+			AND (
+				software ILIKE $%[1]d OR licenses ILIKE $%[1]d
+				OR EXISTS (SELECT 1 FROM node_software ns WHERE ns.node_id = nodes.id AND ns.name ILIKE $%[1]d)
+			)`, argIndex)
+		args = append(args, "%"+software+"%")
+		argIndex++
+	}
+
+	if filter.GPUModel != "" {
+		conditions += fmt.Sprintf(" AND gpu_model ILIKE $%d", argIndex)
+		args = append(args, "%"+filter.GPUModel+"%")
+		argIndex++
+	}
+
+	// Nodes without a structured value never match a minimum
+	minimums := []struct {
+		column string
+		value  int
+	}{
+		{"vram_gb", filter.MinVRAMGB},
+		{"cpu_cores", filter.MinCPUCores},
+		{"ram_gb", filter.MinRAMGB},
+		{"storage_gb", filter.MinStorageGB},
+	}
+	for _, minimum := range minimums {
+		if minimum.value > 0 {
+			conditions += fmt.Sprintf(" AND %s >= $%d", minimum.column, argIndex)
+			args = append(args, minimum.value)
+			argIndex++
+		}
+	}
+
+	if filter.MaxPrice > 0 {
+		conditions += fmt.Sprintf(" AND price <= $%d", argIndex)
+		args = append(args, filter.MaxPrice)
+		argIndex++
+	}
+
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM nodes WHERE 1=1"+conditions, args...).Scan(&info.Total)
+	if err != nil {
+		return nil, info, err
+	}
+
+	keyset, suffix, pageArgs := pageClauses(page, argIndex)
+	query := "SELECT" + nodeColumns + "FROM nodes WHERE 1=1" + conditions + keyset + suffix
+
+	rows, err := s.db.QueryContext(ctx, query, append(args, pageArgs...)...)
+	if err != nil {
+		return nil, info, err
+	}
+	defer rows.Close()
+
+	var nodes []models.Node
+	for rows.Next() {
+		node, err := scanNode(rows)
+		if err != nil {
+			return nil, info, err
+		}
+		nodes = append(nodes, node)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, info, err
+	}
+
+	if page.Overflows(len(nodes)) {
+		nodes = nodes[:page.Limit]
+		last := nodes[len(nodes)-1]
+		info.NextCursor = page.Next(store.NodeSortValue(last, page.Sort), last.ID)
+	}
+
+	if err := loadSoftware(ctx, s.db, nodes); err != nil {
+		return nil, info, err
+	}
+
+	return nodes, info, nil
+}
+
+func (s *Store) CreateNode(ctx context.Context, input models.NodeInput) (models.Node, error) {
+	var node models.Node
+
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO nodes (
+				old_id, any_desk_address, any_desk_password, status, software,
+				price, cpu, gpu, other_specs, licenses, machine_id,
+				gpu_model, vram_gb, cpu_cores, ram_gb, storage_gb
+			)
+			VALUES (
+				$1, $2, $3, COALESCE($4, 'available'), $5, $6, $7, $8, $9, $10, $11,
+				$12, $13, $14, $15, $16
+			)
+			RETURNING` + nodeColumns
+
+		var err error
+		node, err = scanNode(tx.QueryRowContext(
+			ctx,
+			query,
+			input.OldID,
+			*input.AnyDeskAddress,
+			*input.AnyDeskPassword,
+			input.Status,
+			input.Software,
+			*input.Price,
+			input.CPU,
+			input.GPU,
+			input.OtherSpecs,
+			input.Licenses,
+			*input.MachineID,
+			input.GPUModel,
+			input.VRAMGB,
+			input.CPUCores,
+			input.RAMGB,
+			input.StorageGB,
+		))
+		if err != nil {
+			return nodeWriteError(err)
+		}
+
+		node.SoftwareCatalog = []models.NodeSoftware{}
+		if input.SoftwareCatalog != nil {
+			if err := replaceSoftware(ctx, tx, node.ID, *input.SoftwareCatalog); err != nil {
+				return err
+			}
+			created := []models.Node{node}
+			if err := loadSoftware(ctx, tx, created); err != nil {
+				return err
+			}
+			node = created[0]
+		}
+		return nil
+	})
+
+	return node, err
+}
+
+// lockNodeByKey finds the node in service identified by key and holds a row
+// lock on it until tx ends.
+func lockNodeByKey(ctx context.Context, tx *sql.Tx, key store.NodeKey) (int, models.NodeStatus, error) {
+	query := "SELECT id, status FROM nodes WHERE status <> 'decommissioned' AND "
+	var arg interface{}
+
+	switch {
+	case key.ID != nil:
+		query += "id = $1"
+		arg = *key.ID
+	case key.OldID != nil:
+		query += "old_id = $1"
+		arg = *key.OldID
+	case key.AnyDeskAddress != nil:
+		query += "any_desk_address = $1"
+		arg = *key.AnyDeskAddress
+	default:
+		return 0, "", fmt.Errorf("no node key provided")
+	}
+
+	var id int
+	var status models.NodeStatus
+	err := tx.QueryRowContext(ctx, query+" FOR UPDATE", arg).Scan(&id, &status)
+	if err == sql.ErrNoRows {
+		return 0, "", store.ErrNotFound
+	}
+	return id, status, err
+}
+
+func (s *Store) UpdateNode(ctx context.Context, key store.NodeKey, changes map[string]interface{}) error {
+	// Sort the columns so the generated statement is stable
+	columns := make([]string, 0, len(changes))
+	for column := range changes {
+		if column == "software_catalog" {
+			continue
+		}
+		if !updatableNodeColumns[column] {
+			return fmt.Errorf("column %q cannot be updated", column)
+		}
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	sets := []string{}
+	args := []interface{}{}
+	argIndex := 1
+
+	for _, column := range columns {
+		value := changes[column]
+		if value == nil {
+			sets = append(sets, fmt.Sprintf("%s = NULL", column))
+			continue
+		}
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, argIndex))
+		args = append(args, value)
+		argIndex++
+	}
+	// A status set by hand is not undone by the next heartbeat
+	if _, ok := changes["status"]; ok {
+		sets = append(sets, "heartbeat_lost = FALSE")
+	}
+
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		id, status, err := lockNodeByKey(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		if next, ok := changes["status"].(models.NodeStatus); ok {
+			if err := models.CheckNodeTransition(id, status, next); err != nil {
+				return err
+			}
+		}
+
+		if len(sets) > 0 {
+			query := "UPDATE nodes SET " + strings.Join(sets, ", ") + fmt.Sprintf(" WHERE id = $%d", argIndex)
+			if _, err := tx.ExecContext(ctx, query, append(args, id)...); err != nil {
+				return nodeWriteError(err)
+			}
+		}
+
+		catalog, present := changes["software_catalog"]
+		if !present {
+			return nil
+		}
+		items, ok := catalog.([]models.NodeSoftware)
+		if catalog != nil && !ok {
+			return fmt.Errorf("software_catalog expects a software list")
+		}
+		return replaceSoftware(ctx, tx, id, items)
+	})
+}
+
+func (s *Store) DecommissionNode(ctx context.Context, id int) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		nodeID, status, err := lockNodeByKey(ctx, tx, store.NodeKey{ID: &id})
+		if err != nil {
+			return err
+		}
+		if status == models.NodeStatusRented {
+			return store.ErrNodeRented
+		}
+		if err := models.CheckNodeTransition(nodeID, status, models.NodeStatusDecommissioned); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE nodes SET status = 'decommissioned' WHERE id = $1", nodeID)
+		return err
+	})
+}
+
+func (s *Store) NodeStatusHistory(ctx context.Context, nodeID int) ([]models.NodeStatusChange, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM nodes WHERE id = $1)", nodeID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, store.ErrNotFound
+	}
+
+	query := `
+		SELECT id, node_id, COALESCE(from_status, ''), to_status, changed_by, changed_at
+		FROM node_status_history
+		WHERE node_id = $1
+		ORDER BY id
+	`
+	rows, err := s.db.QueryContext(ctx, query, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []models.NodeStatusChange
+	for rows.Next() {
+		var change models.NodeStatusChange
+		err := rows.Scan(
+			&change.ID,
+			&change.NodeID,
+			&change.FromStatus,
+			&change.ToStatus,
+			&change.ChangedBy,
+			&change.ChangedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, rows.Err()
+}
+
+func (s *Store) NodeCredentials(ctx context.Context, id int) (models.NodeCredentials, *int, error) {
+	var credentials models.NodeCredentials
+	var renter sql.NullInt16
+
+	query := `
+		SELECT id, any_desk_address, any_desk_password, renter
+		FROM nodes
+		WHERE id = $1
+	`
+	err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&credentials.NodeID,
+		&credentials.AnyDeskAddress,
+		&credentials.AnyDeskPassword,
+		&renter,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return credentials, nil, store.ErrNotFound
+		}
+		return credentials, nil, err
+	}
+
+	if !renter.Valid {
+		return credentials, nil, nil
+	}
+	renterID := int(renter.Int16)
+	return credentials, &renterID, nil
+}
+
+func (s *Store) RentNode(ctx context.Context, nodeID int, userID int, now time.Time) (int, error) {
+	var rentalID int
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		rentalID, err = billing.Rent(ctx, tx, nodeID, userID, now)
+		return err
+	})
+	return rentalID, err
+}
+
+func (s *Store) ReleaseNode(ctx context.Context, nodeID int, userID int, now time.Time, reason billing.EndReason) (money.Amount, error) {
+	var charged money.Amount
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		charged, err = billing.Release(ctx, tx, nodeID, userID, now, reason)
+		return err
+	})
+	return charged, err
+}
+
+func (s *Store) ListRentals(ctx context.Context, filter store.RentalFilter) ([]models.Rental, error) {
+	query := `
+		SELECT
+		id, node_id, user_id, started_at, ended_at,
+		price_per_hour, total_charged, end_reason
+		FROM rentals WHERE 1=1
+	`
+	var args []interface{}
+	argIndex := 1
+
+	if filter.UserID != 0 {
+		query += fmt.Sprintf(" AND user_id = $%d", argIndex)
+		args = append(args, filter.UserID)
+		argIndex++
+	}
+	if filter.NodeID != 0 {
+		query += fmt.Sprintf(" AND node_id = $%d", argIndex)
+		args = append(args, filter.NodeID)
+		argIndex++
+	}
+
+	// From/To select rentals that overlap the given time range
+	if !filter.From.IsZero() {
+		query += fmt.Sprintf(" AND (ended_at IS NULL OR ended_at >= $%d)", argIndex)
+		args = append(args, filter.From)
+		argIndex++
+	}
+	if !filter.To.IsZero() {
+		query += fmt.Sprintf(" AND started_at <= $%d", argIndex)
+		args = append(args, filter.To)
+		argIndex++
+	}
+
+	query += " ORDER BY started_at DESC, id DESC"
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT $%d", argIndex)
+		args = append(args, filter.Limit)
+		argIndex++
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rentals []models.Rental
+	for rows.Next() {
+		var rental models.Rental
+		err := rows.Scan(
+			&rental.ID,
+			&rental.NodeID,
+			&rental.UserID,
+			&rental.StartedAt,
+			&rental.EndedAt,
+			&rental.PricePerHour,
+			&rental.TotalCharged,
+			&rental.EndReason,
+		)
+		if err != nil {
+			return nil, err
+		}
+		rentals = append(rentals, rental)
+	}
+
+	return rentals, rows.Err()
+}
+
+func (s *Store) RentedNodeIDs(ctx context.Context) ([]int, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id FROM nodes WHERE status = 'rented' AND renter IS NOT NULL AND billing_paused_at IS NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nodeIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		nodeIDs = append(nodeIDs, id)
+	}
+
+	return nodeIDs, rows.Err()
+}
+
+func (s *Store) BillNode(ctx context.Context, nodeID int, now time.Time) (bool, error) {
+	var released bool
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		released, err = billing.BillNode(ctx, tx, nodeID, now)
+		return err
+	})
+	return released, err
+}
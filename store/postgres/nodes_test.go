@@ -0,0 +1,56 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"hvmnd/api/models"
+	"hvmnd/api/money"
+	"hvmnd/api/store"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentRegistrationsOfOneMachine(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+
+	machineID := fmt.Sprintf("test-machine-%d", rand.Int63())
+	price := money.MustParse("10")
+	password := "secret"
+	const callers = 8
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make([]error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			address := fmt.Sprintf("%s-%d", machineID, i)
+			<-start
+			_, errs[i] = s.CreateNode(ctx, models.NodeInput{
+				AnyDeskAddress:  &address,
+				AnyDeskPassword: &password,
+				Price:           &price,
+				MachineID:       &machineID,
+			})
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		switch err {
+		case nil:
+			created++
+		case store.ErrDuplicateMachineID:
+		default:
+			t.Fatal(err)
+		}
+	}
+	if created != 1 {
+		t.Fatalf("want exactly one node registered, got %d", created)
+	}
+}
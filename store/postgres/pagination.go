@@ -0,0 +1,43 @@
+package postgres
+
+import (
+	"fmt"
+	"hvmnd/api/store"
+)
+
+// pageClauses returns the keyset condition that skips rows up to the page
+// cursor and the ORDER BY/LIMIT suffix. page.Sort has already been checked
+// against the resource's whitelist, so it is safe to interpolate. One extra
+// row is requested to tell whether another page follows; an unlimited page
+// has no LIMIT.
+func pageClauses(page store.Page, argIndex int) (string, string, []interface{}) {
+	direction, comparison := "ASC", ">"
+	if page.Desc {
+		direction, comparison = "DESC", "<"
+	}
+
+	var where string
+	var args []interface{}
+	if page.After != nil {
+		if page.Sort == "id" {
+			where = fmt.Sprintf(" AND id %s $%d", comparison, argIndex)
+			args = append(args, page.After.ID)
+			argIndex++
+		} else {
+			where = fmt.Sprintf(" AND (%s, id) %s ($%d, $%d)", page.Sort, comparison, argIndex, argIndex+1)
+			args = append(args, page.After.Value, page.After.ID)
+			argIndex += 2
+		}
+	}
+
+	suffix := fmt.Sprintf(" ORDER BY %s %s", page.Sort, direction)
+	if page.Sort != "id" {
+		suffix += ", id " + direction
+	}
+	if page.Limit > 0 {
+		suffix += fmt.Sprintf(" LIMIT $%d", argIndex)
+		args = append(args, page.Limit+1)
+	}
+
+	return where, suffix, args
+}
@@ -0,0 +1,76 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"hvmnd/api/models"
+	"hvmnd/api/store"
+	"time"
+)
+
+func (s *Store) RecordPaymentEvent(ctx context.Context, event models.PaymentEvent) (models.PaymentEvent, bool, error) {
+	query := `
+		INSERT INTO payment_events (provider, event_id, event_type, payment_id, amount, payload, received_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (provider, event_id) DO NOTHING
+		RETURNING id
+	`
+	err := s.db.QueryRowContext(ctx, query,
+		event.Provider,
+		event.EventID,
+		event.EventType,
+		event.PaymentID,
+		event.Amount,
+		event.Payload,
+		event.ReceivedAt,
+	).Scan(&event.ID)
+	if err == nil {
+		return event, true, nil
+	}
+	if err != sql.ErrNoRows {
+		return models.PaymentEvent{}, false, err
+	}
+
+	// The event was delivered before
+	var stored models.PaymentEvent
+	query = `
+		SELECT id, provider, event_id, event_type, payment_id, amount, payload, result, received_at, processed_at
+		FROM payment_events
+		WHERE provider = $1 AND event_id = $2
+	`
+	err = s.db.QueryRowContext(ctx, query, event.Provider, event.EventID).Scan(
+		&stored.ID,
+		&stored.Provider,
+		&stored.EventID,
+		&stored.EventType,
+		&stored.PaymentID,
+		&stored.Amount,
+		&stored.Payload,
+		&stored.Result,
+		&stored.ReceivedAt,
+		&stored.ProcessedAt,
+	)
+	return stored, false, err
+}
+
+func (s *Store) FinishPaymentEvent(ctx context.Context, id int64, result string, now time.Time) error {
+	query := `
+		UPDATE payment_events SET
+		result = $1,
+		processed_at = $2
+		WHERE id = $3
+	`
+	res, err := s.db.ExecContext(ctx, query, result, now, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,405 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hvmnd/api/currency"
+	"hvmnd/api/ledger"
+	"hvmnd/api/models"
+	"hvmnd/api/money"
+	"hvmnd/api/store"
+	"strconv"
+	"time"
+)
+
+// errPaymentStatusChanged is returned when a conditional status transition
+// finds the payment ticket in a different status than expected.
+var errPaymentStatusChanged = errors.New("payment status changed concurrently")
+
+// paymentColumns is the column list scanPayment expects.
+const paymentColumns = `
+	id, user_id, amount, currency, exchange_rate, refunded_amount, status, datetime
+`
+
+func scanPayment(row interface{ Scan(...interface{}) error }) (models.Payment, error) {
+	var payment models.Payment
+	var paymentCurrency sql.NullString
+	err := row.Scan(
+		&payment.ID,
+		&payment.UserID,
+		&payment.Amount,
+		&paymentCurrency,
+		&payment.ExchangeRate,
+		&payment.RefundedAmount,
+		&payment.Status,
+		&payment.Datetime,
+	)
+
+	// Tickets from before currencies were introduced are in the base currency
+	payment.Currency = paymentCurrency.String
+	if !paymentCurrency.Valid {
+		payment.Currency = currency.Base()
+	}
+	return payment, err
+}
+
+func (s *Store) ListPayments(ctx context.Context, filter store.PaymentFilter, page store.Page) ([]models.Payment, store.PageInfo, error) {
+	var info store.PageInfo
+
+	conditions := ""
+	var args []interface{}
+	argIndex := 1
+
+	if filter.ID != 0 {
+		conditions += fmt.Sprintf(" AND id = $%d", argIndex)
+		args = append(args, filter.ID)
+		argIndex++
+	}
+	if filter.UserID != 0 {
+		conditions += fmt.Sprintf(" AND user_id = $%d", argIndex)
+		args = append(args, filter.UserID)
+		argIndex++
+	}
+	if filter.Status != "" {
+		conditions += fmt.Sprintf(" AND status = $%d", argIndex)
+		args = append(args, filter.Status)
+		argIndex++
+	}
+
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM payments WHERE 1=1"+conditions, args...).Scan(&info.Total)
+	if err != nil {
+		return nil, info, err
+	}
+
+	keyset, suffix, pageArgs := pageClauses(page, argIndex)
+	query := `
+		SELECT ` + paymentColumns + ` FROM payments WHERE 1=1
+	` + conditions + keyset + suffix
+
+	rows, err := s.db.QueryContext(ctx, query, append(args, pageArgs...)...)
+	if err != nil {
+		return nil, info, err
+	}
+	defer rows.Close()
+
+	var payments []models.Payment
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, info, err
+		}
+		payments = append(payments, payment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, info, err
+	}
+
+	if page.Overflows(len(payments)) {
+		payments = payments[:page.Limit]
+		last := payments[len(payments)-1]
+		info.NextCursor = page.Next(store.PaymentSortValue(last, page.Sort), last.ID)
+	}
+
+	return payments, info, nil
+}
+
+func (s *Store) CreatePayment(ctx context.Context, userID int, amount money.Amount, paymentCurrency string, now time.Time) (int, error) {
+	var paymentID int
+
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		if err := checkNotBanned(ctx, tx, userID, now); err != nil {
+			return err
+		}
+
+		query := `
+			INSERT INTO payments (user_id, amount, currency, status, datetime)
+			VALUES ($1, $2, $3, 'unpaid', $4) RETURNING id
+		`
+		return tx.QueryRowContext(ctx, query, userID, amount, paymentCurrency, now).Scan(&paymentID)
+	})
+
+	return paymentID, err
+}
+
+// lockPayment reads a payment ticket inside tx and holds a row lock on it
+// until the transaction ends, so concurrent status changes are serialized.
+func lockPayment(ctx context.Context, tx *sql.Tx, id int) (models.Payment, error) {
+	query := `
+		SELECT ` + paymentColumns + `
+		FROM payments
+		WHERE id=$1
+		FOR UPDATE
+	`
+	payment, err := scanPayment(tx.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		err = store.ErrNotFound
+	}
+	return payment, err
+}
+
+// lockBalance reads a user's balance inside tx and holds a row lock on the
+// user until the transaction ends.
+func lockBalance(ctx context.Context, tx *sql.Tx, userID int) (money.Amount, error) {
+	var balance money.Amount
+	err := tx.QueryRowContext(ctx, "SELECT balance FROM users WHERE id=$1 FOR UPDATE", userID).Scan(&balance)
+	return balance, err
+}
+
+// transitionPayment moves a payment from one status to another. It fails with
+// errPaymentStatusChanged if the ticket is no longer in the expected status.
+func transitionPayment(ctx context.Context, tx *sql.Tx, id int, from, to string) error {
+	query := `
+		UPDATE payments SET
+		status=$1
+		WHERE id=$2 AND status=$3
+	`
+	result, err := tx.ExecContext(ctx, query, to, id, from)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected != 1 {
+		return errPaymentStatusChanged
+	}
+
+	return nil
+}
+
+func (s *Store) CompletePayment(ctx context.Context, id int, force bool) (string, error) {
+	var previousStatus string
+
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		payment, err := lockPayment(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		previousStatus = payment.Status
+
+		// Only unpaid tickets can be completed, expired ones when forced
+		if payment.Status != "unpaid" && !(force && payment.Status == "expired") {
+			return nil
+		}
+
+		// The ticket is credited at the rate in effect when it is completed
+		rate, err := exchangeRate(ctx, tx, payment.Currency, time.Now())
+		if err != nil {
+			return err
+		}
+		payment.ExchangeRate = sql.Null[money.Rate]{V: rate.Rate, Valid: true}
+
+		if err := transitionPayment(ctx, tx, id, payment.Status, "paid"); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE payments SET exchange_rate=$1 WHERE id=$2", rate.Rate, id); err != nil {
+			return err
+		}
+
+		_, err = ledger.Post(ctx, tx, payment.UserID, payment.ToBase(payment.Amount), ledger.ReasonPaymentCompleted, strconv.Itoa(id))
+		return err
+	})
+
+	return previousStatus, err
+}
+
+func (s *Store) CancelPayment(ctx context.Context, id int, policy store.RefundPolicy) (string, error) {
+	var previousStatus string
+
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		payment, err := lockPayment(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		previousStatus = payment.Status
+
+		if payment.Status == "cancelled" {
+			return nil
+		}
+
+		// If the payment was "paid", take back what was not refunded yet
+		var amount money.Amount
+		if payment.Status == "paid" {
+			balance, err := lockBalance(ctx, tx, payment.UserID)
+			if err != nil {
+				return err
+			}
+			amount, err = policy.Apply(payment.ToBase(payment.Refundable()), balance)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := transitionPayment(ctx, tx, id, payment.Status, "cancelled"); err != nil {
+			return err
+		}
+
+		if amount > 0 {
+			_, err = ledger.Post(ctx, tx, payment.UserID, -amount, ledger.ReasonPaymentCancelled, strconv.Itoa(id))
+			return err
+		}
+
+		return nil
+	})
+
+	return previousStatus, err
+}
+
+func (s *Store) CancelUnpaidPayment(ctx context.Context, id int) (string, error) {
+	var previousStatus string
+
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		payment, err := lockPayment(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		previousStatus = payment.Status
+
+		if payment.Status != "unpaid" && payment.Status != "expired" {
+			return nil
+		}
+		return transitionPayment(ctx, tx, id, payment.Status, "cancelled")
+	})
+
+	return previousStatus, err
+}
+
+func (s *Store) ExpirePayments(ctx context.Context, cutoff time.Time) ([]int, error) {
+	query := `
+		UPDATE payments SET
+		status='expired'
+		WHERE status='unpaid' AND datetime < $1
+		RETURNING id
+	`
+	rows, err := s.db.QueryContext(ctx, query, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paymentIDs []int
+	for rows.Next() {
+		var paymentID int
+		if err := rows.Scan(&paymentID); err != nil {
+			return nil, err
+		}
+		paymentIDs = append(paymentIDs, paymentID)
+	}
+	return paymentIDs, rows.Err()
+}
+
+func (s *Store) RefundPayment(ctx context.Context, paymentID int, amount money.Amount, reason string, policy store.RefundPolicy, now time.Time) (models.Refund, error) {
+	refund := models.Refund{
+		PaymentID: paymentID,
+		Reason:    reason,
+		CreatedBy: store.ActorFrom(ctx),
+		CreatedAt: now,
+	}
+
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		payment, err := lockPayment(ctx, tx, paymentID)
+		if err != nil {
+			return err
+		}
+
+		if payment.Status != "paid" {
+			return store.ErrPaymentNotRefundable
+		}
+		if amount > payment.Refundable() {
+			return store.ErrRefundTooLarge
+		}
+
+		balance, err := lockBalance(ctx, tx, payment.UserID)
+		if err != nil {
+			return err
+		}
+		// The balance is in the base currency and the refund in the ticket's
+		// currency, so the refund is converted before it is debited
+		debit, err := policy.Apply(payment.ToBase(amount), balance)
+		if err != nil {
+			return err
+		}
+		refund.Amount = amount
+		if debit < payment.ToBase(amount) {
+			// A clamped refund is what the debit buys back, and the debit
+			// is exactly what that refund is worth
+			refund.Amount = payment.FromBase(debit)
+			debit = payment.ToBase(refund.Amount)
+		}
+		if refund.Amount <= 0 || debit <= 0 {
+			return store.ErrInsufficientBalance
+		}
+
+		query := `
+			INSERT INTO payment_refunds (payment_id, amount, reason, created_by, created_at)
+			VALUES ($1, $2, $3, $4, $5) RETURNING id
+		`
+		err = tx.QueryRowContext(ctx, query, paymentID, refund.Amount, reason, refund.CreatedBy, now).Scan(&refund.ID)
+		if err != nil {
+			return err
+		}
+
+		query = `
+			UPDATE payments SET
+			refunded_amount = refunded_amount + $1
+			WHERE id=$2
+		`
+		if _, err := tx.ExecContext(ctx, query, refund.Amount, paymentID); err != nil {
+			return err
+		}
+
+		_, err = ledger.Post(ctx, tx, payment.UserID, -debit, ledger.ReasonPaymentRefunded, fmt.Sprintf("refund:%d", refund.ID))
+		return err
+	})
+	if err != nil {
+		return models.Refund{}, err
+	}
+
+	return refund, nil
+}
+
+func (s *Store) ListRefunds(ctx context.Context, paymentID int) ([]models.Refund, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM payments WHERE id = $1)", paymentID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, store.ErrNotFound
+	}
+
+	query := `
+		SELECT id, payment_id, amount, reason, created_by, created_at
+		FROM payment_refunds
+		WHERE payment_id = $1
+		ORDER BY id
+	`
+	rows, err := s.db.QueryContext(ctx, query, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refunds []models.Refund
+	for rows.Next() {
+		var refund models.Refund
+		err := rows.Scan(
+			&refund.ID,
+			&refund.PaymentID,
+			&refund.Amount,
+			&refund.Reason,
+			&refund.CreatedBy,
+			&refund.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, refund)
+	}
+
+	return refunds, rows.Err()
+}
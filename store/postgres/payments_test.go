@@ -0,0 +1,173 @@
+package postgres
+
+import (
+	"context"
+	"hvmnd/api/currency"
+	"hvmnd/api/ledger"
+	"hvmnd/api/money"
+	"hvmnd/api/store"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// race calls fn from n goroutines released at the same moment and returns
+// the statuses they reported.
+func race(t *testing.T, n int, fn func() (string, error)) []string {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	statuses := make([]string, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			statuses[i], errs[i] = fn()
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return statuses
+}
+
+// countStatuses tallies the previous statuses returned by racing callers.
+func countStatuses(statuses []string) map[string]int {
+	counts := map[string]int{}
+	for _, status := range statuses {
+		counts[status]++
+	}
+	return counts
+}
+
+func TestConcurrentCompleteAndCancel(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	user := testUser(t, s)
+
+	id, err := s.CreatePayment(ctx, user.ID, money.MustParse("25"), currency.Base(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reference := strconv.Itoa(id)
+	const callers = 16
+
+	completed := countStatuses(race(t, callers, func() (string, error) {
+		return s.CompletePayment(ctx, id, false)
+	}))
+	if completed["unpaid"] != 1 || completed["paid"] != callers-1 {
+		t.Fatalf("complete: want one caller to see unpaid and the rest paid, got %v", completed)
+	}
+	if n := ledgerCount(t, s, user.ID, string(ledger.ReasonPaymentCompleted), reference); n != 1 {
+		t.Fatalf("complete: want 1 ledger credit, got %d", n)
+	}
+
+	cancelled := countStatuses(race(t, callers, func() (string, error) {
+		return s.CancelPayment(ctx, id, store.RefundPolicyAllowNegative)
+	}))
+	if cancelled["paid"] != 1 || cancelled["cancelled"] != callers-1 {
+		t.Fatalf("cancel: want one caller to see paid and the rest cancelled, got %v", cancelled)
+	}
+	if n := ledgerCount(t, s, user.ID, string(ledger.ReasonPaymentCancelled), reference); n != 1 {
+		t.Fatalf("cancel: want 1 ledger debit, got %d", n)
+	}
+
+	users, _, err := s.ListUsers(ctx, store.UserFilter{ID: user.ID}, store.Page{Limit: 1, Sort: "id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Balance != 0 {
+		t.Fatalf("want the balance back at 0, got %v", users)
+	}
+}
+
+func TestCancellingUnpaidTicketsNeverTakesBackACompletion(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	user := testUser(t, s)
+
+	id, err := s.CreatePayment(ctx, user.ID, money.MustParse("25"), currency.Base(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reference := strconv.Itoa(id)
+
+	// Half the callers complete the ticket and the other half cancel it
+	// while it is unpaid, as a succeeded and a failed webhook would
+	var calls atomic.Int32
+	race(t, 16, func() (string, error) {
+		if calls.Add(1)%2 == 0 {
+			return s.CompletePayment(ctx, id, false)
+		}
+		return s.CancelUnpaidPayment(ctx, id)
+	})
+
+	payments, _, err := s.ListPayments(ctx, store.PaymentFilter{ID: id}, store.Page{Limit: 1, Sort: "id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	credits := ledgerCount(t, s, user.ID, string(ledger.ReasonPaymentCompleted), reference)
+	debits := ledgerCount(t, s, user.ID, string(ledger.ReasonPaymentCancelled), reference)
+	switch payments[0].Status {
+	case "paid":
+		if credits != 1 || debits != 0 {
+			t.Fatalf("paid: want 1 credit and no debit, got %d and %d", credits, debits)
+		}
+	case "cancelled":
+		if credits != 0 || debits != 0 {
+			t.Fatalf("cancelled: want no ledger rows, got %d credits and %d debits", credits, debits)
+		}
+	default:
+		t.Fatalf("want the ticket paid or cancelled, got %q", payments[0].Status)
+	}
+}
+
+func TestExpirePaymentsLeavesPaidAndRecentTickets(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	user := testUser(t, s)
+
+	// Tickets from long ago keep the cutoff clear of other tests' tickets
+	createdAt := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	open := func(at time.Time) int {
+		t.Helper()
+		id, err := s.CreatePayment(ctx, user.ID, money.MustParse("10"), currency.Base(), at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	stale := open(createdAt)
+	paid := open(createdAt)
+	if _, err := s.CompletePayment(ctx, paid, false); err != nil {
+		t.Fatal(err)
+	}
+	recent := open(createdAt.Add(2 * time.Hour))
+
+	expired, err := s.ExpirePayments(ctx, createdAt.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(expired, stale) || slices.Contains(expired, paid) || slices.Contains(expired, recent) {
+		t.Fatalf("want only ticket %d expired, got %v", stale, expired)
+	}
+
+	// An expired ticket is only completed when forced
+	if status, err := s.CompletePayment(ctx, stale, false); err != nil || status != "expired" {
+		t.Fatalf("want the expired ticket left alone, got %q, %v", status, err)
+	}
+	if n := ledgerCount(t, s, user.ID, string(ledger.ReasonPaymentCompleted), strconv.Itoa(stale)); n != 0 {
+		t.Fatalf("want nothing credited, got %d ledger rows", n)
+	}
+}
@@ -0,0 +1,67 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"hvmnd/api/auth"
+	"hvmnd/api/idempotency"
+	"hvmnd/api/store"
+
+	"github.com/lib/pq"
+)
+
+// Store implements the store interfaces on a Postgres connection pool.
+type Store struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// withTx runs fn inside a single transaction. The transaction is committed if
+// fn returns nil and rolled back otherwise. The actor and route from ctx are
+// exposed to triggers as the hvmnd.actor and hvmnd.route settings for the
+// duration of the transaction; the audit triggers log changes made with a
+// route. Every write that may be audited goes through withTx.
+func (s *Store) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "SELECT set_config('hvmnd.actor', $1, true), set_config('hvmnd.route', $2, true)"
+	if _, err := tx.ExecContext(ctx, query, store.ActorFrom(ctx), store.RouteFrom(ctx)); err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// uniqueViolation returns the name of the unique constraint err violated, or
+// "" if err is not a unique violation.
+func uniqueViolation(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return pqErr.Constraint
+	}
+	return ""
+}
+
+var (
+	_ store.UserStore         = (*Store)(nil)
+	_ store.NodeStore         = (*Store)(nil)
+	_ store.PaymentStore      = (*Store)(nil)
+	_ store.ExchangeRateStore = (*Store)(nil)
+	_ store.QuizStore         = (*Store)(nil)
+	_ store.AuditStore        = (*Store)(nil)
+	_ auth.KeyStore           = (*Store)(nil)
+
+	_ idempotency.Store = (*Store)(nil)
+)
@@ -0,0 +1,58 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"hvmnd/api/db"
+	"hvmnd/api/models"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// testStore connects to the database in TEST_POSTGRES_URL and migrates it.
+// Tests that need Postgres are skipped when it is not set. Every test works
+// on rows it creates, so the database can be shared between runs.
+func testStore(t *testing.T) *Store {
+	t.Helper()
+
+	url := os.Getenv("TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("TEST_POSTGRES_URL is not set")
+	}
+
+	conn, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if _, err := db.MigrateUp(conn); err != nil {
+		t.Fatal(err)
+	}
+	return New(conn)
+}
+
+// testUser creates a user with a random Telegram id.
+func testUser(t *testing.T, s *Store) models.User {
+	t.Helper()
+
+	user, err := s.UpsertUser(context.Background(), models.UserInput{TelegramID: 1_000_000 + rand.Intn(1_000_000_000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// ledgerCount counts the ledger rows posted to a user for reason and
+// reference.
+func ledgerCount(t *testing.T, s *Store, userID int, reason, reference string) int {
+	t.Helper()
+
+	var count int
+	query := "SELECT COUNT(*) FROM balance_ledger WHERE user_id = $1 AND reason = $2 AND reference_id = $3"
+	if err := s.db.QueryRow(query, userID, reason, reference).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
@@ -0,0 +1,59 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"hvmnd/api/store"
+	"time"
+)
+
+func (s *Store) SaveHashMapping(ctx context.Context, hash, question, answer string) error {
+	query := `
+		INSERT INTO quiz_hash_map (hash, question, answer)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (hash) DO NOTHING;
+	`
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, hash, question, answer)
+		return err
+	})
+}
+
+func (s *Store) GetQuestionAnswer(ctx context.Context, hash string) (string, string, error) {
+	query := `
+		SELECT question, answer
+		FROM quiz_hash_map
+		WHERE hash = $1;
+	`
+	var question, answer string
+	err := s.db.QueryRowContext(ctx, query, hash).Scan(&question, &answer)
+	if err == sql.ErrNoRows {
+		return "", "", store.ErrNotFound
+	}
+	return question, answer, err
+}
+
+func (s *Store) SaveUserAnswer(ctx context.Context, telegramID int, question, answer, hash string) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		// Answers may be saved before the user registers
+		var userID int
+		err := tx.QueryRowContext(ctx, "SELECT id FROM users WHERE telegram_id = $1", telegramID).Scan(&userID)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if err == nil {
+			if err := checkNotBanned(ctx, tx, userID, time.Now()); err != nil {
+				return err
+			}
+		}
+
+		query := `
+			INSERT INTO quiz_answers (telegram_id, question, answer, hash)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (telegram_id, question) DO UPDATE
+			SET answer = EXCLUDED.answer, hash = EXCLUDED.hash;
+		`
+		_, err = tx.ExecContext(ctx, query, telegramID, question, answer, hash)
+		return err
+	})
+}
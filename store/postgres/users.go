@@ -0,0 +1,315 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hvmnd/api/billing"
+	"hvmnd/api/ledger"
+	"hvmnd/api/models"
+	"hvmnd/api/store"
+	"time"
+)
+
+// userColumns is the column list scanUser expects.
+const userColumns = `
+	id, telegram_id, total_spent, balance, first_name, last_name, username,
+	language_code, banned, ban_reason, banned_until
+`
+
+func scanUser(row interface{ Scan(...interface{}) error }) (models.User, error) {
+	var user models.User
+	err := row.Scan(
+		&user.ID,
+		&user.TelegramID,
+		&user.TotalSpent,
+		&user.Balance,
+		&user.FirstName,
+		&user.LastName,
+		&user.Username,
+		&user.LanguageCode,
+		&user.Banned,
+		&user.BanReason,
+		&user.BannedUntil,
+	)
+	return user, err
+}
+
+func (s *Store) ListUsers(ctx context.Context, filter store.UserFilter, page store.Page) ([]models.User, store.PageInfo, error) {
+	var info store.PageInfo
+
+	conditions := ""
+	var args []interface{}
+	argIndex := 1
+
+	if filter.TelegramID != 0 {
+		conditions += fmt.Sprintf(" AND telegram_id = $%d", argIndex)
+		args = append(args, filter.TelegramID)
+		argIndex++
+	}
+	if filter.Username != "" {
+		conditions += fmt.Sprintf(" AND username = $%d", argIndex)
+		args = append(args, filter.Username)
+		argIndex++
+	}
+	if filter.ID != 0 {
+		conditions += fmt.Sprintf(" AND id = $%d", argIndex)
+		args = append(args, filter.ID)
+		argIndex++
+	}
+
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE 1=1"+conditions, args...).Scan(&info.Total)
+	if err != nil {
+		return nil, info, err
+	}
+
+	keyset, suffix, pageArgs := pageClauses(page, argIndex)
+	query := `
+		SELECT ` + userColumns + ` FROM users WHERE 1=1
+	` + conditions + keyset + suffix
+
+	rows, err := s.db.QueryContext(ctx, query, append(args, pageArgs...)...)
+	if err != nil {
+		return nil, info, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, info, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, info, err
+	}
+
+	if page.Overflows(len(users)) {
+		users = users[:page.Limit]
+		last := users[len(users)-1]
+		info.NextCursor = page.Next(store.UserSortValue(last, page.Sort), last.ID)
+	}
+
+	return users, info, nil
+}
+
+func (s *Store) UpsertUser(ctx context.Context, input models.UserInput) (models.User, error) {
+	query := `
+		INSERT INTO public.users (
+			telegram_id,
+			total_spent,
+			balance,
+			first_name,
+			last_name,
+			username,
+			language_code
+		)
+		VALUES ($1, 0, 0, $2, $3, $4, $5)
+		ON CONFLICT (telegram_id) DO UPDATE
+		SET
+			first_name = COALESCE(EXCLUDED.first_name, public.users.first_name),
+			last_name = COALESCE(EXCLUDED.last_name, public.users.last_name),
+			username = COALESCE(EXCLUDED.username, public.users.username),
+			language_code = COALESCE(EXCLUDED.language_code, public.users.language_code)
+		WHERE public.users.telegram_id = EXCLUDED.telegram_id
+		RETURNING ` + userColumns
+
+	var user models.User
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		user, err = scanUser(tx.QueryRowContext(
+			ctx,
+			query,
+			input.TelegramID,
+			input.FirstName,
+			input.LastName,
+			input.Username,
+			input.LanguageCode,
+		))
+		return err
+	})
+
+	return user, err
+}
+
+func (s *Store) AdjustUser(ctx context.Context, adjustment models.Adjustment) (models.Adjustment, models.User, error) {
+	adjustment.CreatedBy = store.ActorFrom(ctx)
+
+	var user models.User
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := lockBalance(ctx, tx, adjustment.UserID); err != nil {
+			if err == sql.ErrNoRows {
+				return store.ErrNotFound
+			}
+			return err
+		}
+
+		query := `
+			INSERT INTO user_adjustments (user_id, balance_delta, total_spent_delta, reason, created_by, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6) RETURNING id
+		`
+		err := tx.QueryRowContext(
+			ctx,
+			query,
+			adjustment.UserID,
+			adjustment.BalanceDelta,
+			adjustment.TotalSpentDelta,
+			adjustment.Reason,
+			adjustment.CreatedBy,
+			adjustment.CreatedAt,
+		).Scan(&adjustment.ID)
+		if err != nil {
+			return err
+		}
+
+		// Balance is never written directly; the delta goes through the ledger
+		if adjustment.BalanceDelta != 0 {
+			reference := fmt.Sprintf("adjustment:%d", adjustment.ID)
+			if _, err := ledger.Post(ctx, tx, adjustment.UserID, adjustment.BalanceDelta, ledger.ReasonManualAdjustment, reference); err != nil {
+				return err
+			}
+		}
+
+		query = `
+			UPDATE users SET
+			total_spent = total_spent + $1
+			WHERE id = $2
+			RETURNING ` + userColumns
+		user, err = scanUser(tx.QueryRowContext(ctx, query, adjustment.TotalSpentDelta, adjustment.UserID))
+		return err
+	})
+	if err != nil {
+		return models.Adjustment{}, models.User{}, err
+	}
+
+	return adjustment, user, nil
+}
+
+func (s *Store) Ledger(ctx context.Context, userID int, limit int) (store.LedgerSummary, error) {
+	var summary store.LedgerSummary
+
+	balance, ledgerBalance, err := ledger.Reconcile(ctx, s.db, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return summary, store.ErrNotFound
+		}
+		return summary, err
+	}
+
+	entries, err := ledger.Entries(ctx, s.db, userID, limit)
+	if err != nil {
+		return summary, err
+	}
+
+	return store.LedgerSummary{
+		Balance:       balance,
+		LedgerBalance: ledgerBalance,
+		Reconciled:    balance == ledgerBalance,
+		Entries:       entries,
+	}, nil
+}
+
+// checkNotBanned returns store.ErrNotFound if the user does not exist and
+// store.ErrUserBanned if they are banned at now.
+func checkNotBanned(ctx context.Context, q rowQueryer, userID int, now time.Time) error {
+	var banned sql.NullBool
+	var bannedUntil sql.NullTime
+	err := q.QueryRowContext(ctx, "SELECT banned, banned_until FROM users WHERE id = $1", userID).Scan(&banned, &bannedUntil)
+	if err == sql.ErrNoRows {
+		return store.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if models.BanActive(banned, bannedUntil, now) {
+		return store.ErrUserBanned
+	}
+	return nil
+}
+
+func (s *Store) BanUser(ctx context.Context, userID int, reason string, until time.Time, now time.Time) (models.User, []int, error) {
+	bannedUntil := sql.NullTime{Time: until, Valid: !until.IsZero()}
+
+	var user models.User
+	var released []int
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		// Lock the rented nodes before the user, in the order billing does
+		_, err := tx.ExecContext(ctx, "SELECT id FROM nodes WHERE renter = $1 ORDER BY id FOR UPDATE", userID)
+		if err != nil {
+			return err
+		}
+
+		query := `
+			UPDATE users SET
+			banned = TRUE,
+			ban_reason = $1,
+			banned_until = $2
+			WHERE id = $3
+			RETURNING ` + userColumns
+		user, err = scanUser(tx.QueryRowContext(ctx, query, reason, bannedUntil, userID))
+		if err == sql.ErrNoRows {
+			return store.ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		// Listed again under the user lock so a rental that was being opened
+		// when the nodes were locked is released as well
+		rows, err := tx.QueryContext(ctx, "SELECT id FROM nodes WHERE renter = $1 AND status = 'rented' ORDER BY id", userID)
+		if err != nil {
+			return err
+		}
+		var nodeIDs []int
+		for rows.Next() {
+			var nodeID int
+			if err := rows.Scan(&nodeID); err != nil {
+				rows.Close()
+				return err
+			}
+			nodeIDs = append(nodeIDs, nodeID)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, nodeID := range nodeIDs {
+			if _, err := billing.Release(ctx, tx, nodeID, userID, now, billing.EndReasonBanned); err != nil {
+				return fmt.Errorf("releasing node %d: %w", nodeID, err)
+			}
+			released = append(released, nodeID)
+		}
+
+		// Releasing charged the final minutes
+		user, err = scanUser(tx.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", userID))
+		return err
+	})
+	if err != nil {
+		return models.User{}, nil, err
+	}
+
+	return user, released, nil
+}
+
+func (s *Store) UnbanUser(ctx context.Context, userID int) (models.User, error) {
+	query := `
+		UPDATE users SET
+		banned = FALSE,
+		ban_reason = NULL,
+		banned_until = NULL
+		WHERE id = $1
+		RETURNING ` + userColumns
+	var user models.User
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		user, err = scanUser(tx.QueryRowContext(ctx, query, userID))
+		return err
+	})
+	if err == sql.ErrNoRows {
+		return models.User{}, store.ErrNotFound
+	}
+	return user, err
+}
@@ -0,0 +1,256 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"hvmnd/api/billing"
+	"hvmnd/api/ledger"
+	"hvmnd/api/models"
+	"hvmnd/api/money"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when the requested record does not exist.
+	ErrNotFound = errors.New("not found")
+
+	ErrDuplicateAnyDeskAddress = errors.New("any_desk_address is already registered")
+	ErrDuplicateMachineID      = errors.New("machine_id is already registered to an active node")
+	ErrNodeRented              = errors.New("node is rented")
+
+	ErrPaymentNotRefundable = errors.New("only paid payments can be refunded")
+	ErrRefundTooLarge       = errors.New("refund exceeds the refundable amount")
+	ErrInsufficientBalance  = errors.New("user balance does not cover the refund")
+
+	ErrNoExchangeRate = errors.New("no exchange rate is in effect for the currency")
+
+	// ErrUserBanned is returned by writes on behalf of a banned user. It is
+	// the error billing refuses rentals with.
+	ErrUserBanned = billing.ErrUserBanned
+)
+
+type UserFilter struct {
+	ID         int
+	TelegramID int
+	Username   string
+}
+
+// LedgerSummary is a user's stored balance reconciled against the ledger.
+type LedgerSummary struct {
+	Balance       money.Amount   `json:"balance"`
+	LedgerBalance money.Amount   `json:"ledger_balance"`
+	Reconciled    bool           `json:"reconciled"`
+	Entries       []ledger.Entry `json:"entries"`
+}
+
+type UserStore interface {
+	ListUsers(ctx context.Context, filter UserFilter, page Page) ([]models.User, PageInfo, error)
+	// UpsertUser creates or updates a user's profile by telegram id.
+	UpsertUser(ctx context.Context, input models.UserInput) (models.User, error)
+	// AdjustUser applies and records a manual adjustment, attributed to the
+	// actor of ctx. The balance delta is posted to the ledger. It returns
+	// the stored adjustment and the adjusted user, or ErrNotFound.
+	AdjustUser(ctx context.Context, adjustment models.Adjustment) (models.Adjustment, models.User, error)
+	Ledger(ctx context.Context, userID int, limit int) (LedgerSummary, error)
+	// BanUser bans a user for reason until the given time, or permanently
+	// when until is zero, and force-releases every node they are renting. It
+	// returns the banned user and the ids of the released nodes.
+	BanUser(ctx context.Context, userID int, reason string, until time.Time, now time.Time) (models.User, []int, error)
+	// UnbanUser lifts a user's ban. Both return ErrNotFound for an unknown
+	// user.
+	UnbanUser(ctx context.Context, userID int) (models.User, error)
+}
+
+type NodeFilter struct {
+	ID             int
+	Renter         int
+	RenterNotNull  bool
+	Status         models.NodeStatus
+	AnyDeskAddress string
+	OldID          int
+	// Software matches nodes that have every entry, either in their software
+	// catalog or in the free-text software and licenses columns.
+	Software     []string
+	GPUModel     string
+	MinVRAMGB    int
+	MinCPUCores  int
+	MinRAMGB     int
+	MinStorageGB int
+	MaxPrice     money.Amount
+}
+
+// NodeKey identifies a node by exactly one of its unique columns; the first
+// non-nil field wins.
+type NodeKey struct {
+	ID             *int
+	OldID          *int
+	AnyDeskAddress *string
+}
+
+type RentalFilter struct {
+	UserID int
+	NodeID int
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+type NodeStore interface {
+	// ListNodes hides decommissioned nodes unless filter.Status asks for them.
+	ListNodes(ctx context.Context, filter NodeFilter, page Page) ([]models.Node, PageInfo, error)
+	// CreateNode registers a node. input.AnyDeskPassword must already be
+	// encrypted. The AnyDesk address must be unique and the machine id must
+	// not belong to another node that is still in service.
+	CreateNode(ctx context.Context, input models.NodeInput) (models.Node, error)
+	// UpdateNode sets the given columns on the node identified by key. A nil
+	// value sets the column to NULL. The "software_catalog" key takes a
+	// []models.NodeSoftware that replaces the node's catalog. It returns ErrNotFound if no node matched
+	// and a *models.TransitionError if a models.NodeStatus value in changes
+	// is not reachable from the current status. Decommissioned nodes cannot
+	// be updated.
+	UpdateNode(ctx context.Context, key NodeKey, changes map[string]interface{}) error
+	// DecommissionNode takes a node out of service. It fails with
+	// ErrNodeRented while the node has a renter.
+	DecommissionNode(ctx context.Context, id int) error
+	// NodeStatusHistory lists the status changes of a node, oldest first.
+	NodeStatusHistory(ctx context.Context, nodeID int) ([]models.NodeStatusChange, error)
+	// NodeCredentials returns the node's login with the password still
+	// encrypted, together with the current renter.
+	NodeCredentials(ctx context.Context, id int) (models.NodeCredentials, *int, error)
+
+	RentNode(ctx context.Context, nodeID int, userID int, now time.Time) (int, error)
+	ReleaseNode(ctx context.Context, nodeID int, userID int, now time.Time, reason billing.EndReason) (money.Amount, error)
+	ListRentals(ctx context.Context, filter RentalFilter) ([]models.Rental, error)
+
+	// RentedNodeIDs lists the rented nodes whose billing is not paused.
+	RentedNodeIDs(ctx context.Context) ([]int, error)
+	BillNode(ctx context.Context, nodeID int, now time.Time) (bool, error)
+
+	// RecordHeartbeat stores a heartbeat for the node in service with the
+	// heartbeat's machine id and returns the updated node. A node that
+	// MarkOffline took offline becomes available again, one set offline by
+	// hand stays offline, and a paused rental resumes billing.
+	RecordHeartbeat(ctx context.Context, heartbeat models.Heartbeat) (models.Node, error)
+	// MarkOffline flags every node whose last heartbeat is older than cutoff:
+	// available nodes become offline and rented nodes have their billing
+	// paused. Nodes that never sent a heartbeat are left alone. It returns
+	// the ids of the nodes it changed.
+	MarkOffline(ctx context.Context, cutoff time.Time) ([]int, error)
+}
+
+type PaymentFilter struct {
+	ID     int
+	UserID int
+	Status string
+}
+
+// RefundPolicy decides what happens when money taken back from a user, by a
+// refund or by cancelling a paid ticket, exceeds their balance.
+type RefundPolicy string
+
+const (
+	// RefundPolicyReject refuses the refund.
+	RefundPolicyReject RefundPolicy = "reject"
+	// RefundPolicyAllowNegative takes the full amount and lets the balance
+	// go negative.
+	RefundPolicyAllowNegative RefundPolicy = "allow_negative"
+	// RefundPolicyClamp takes back only what the balance covers.
+	RefundPolicyClamp RefundPolicy = "clamp"
+)
+
+// DefaultRefundPolicy is used when REFUND_BALANCE_POLICY is not set.
+const DefaultRefundPolicy = RefundPolicyReject
+
+// Valid reports whether p is a known policy.
+func (p RefundPolicy) Valid() bool {
+	switch p {
+	case RefundPolicyReject, RefundPolicyAllowNegative, RefundPolicyClamp:
+		return true
+	}
+	return false
+}
+
+// Apply returns how much of amount may be taken from a user with the given
+// balance, or ErrInsufficientBalance when the policy refuses.
+func (p RefundPolicy) Apply(amount, balance money.Amount) (money.Amount, error) {
+	switch {
+	case amount <= balance, p == RefundPolicyAllowNegative:
+		return amount, nil
+	case p == RefundPolicyClamp:
+		return max(balance, 0), nil
+	}
+	return 0, ErrInsufficientBalance
+}
+
+type PaymentStore interface {
+	ListPayments(ctx context.Context, filter PaymentFilter, page Page) ([]models.Payment, PageInfo, error)
+	// CreatePayment opens an unpaid ticket for amount in currency. It
+	// returns ErrNotFound if the user does not exist and ErrUserBanned if
+	// they are banned.
+	CreatePayment(ctx context.Context, userID int, amount money.Amount, currency string, now time.Time) (int, error)
+	// CompletePayment and CancelPayment change a ticket's status atomically
+	// and return the status it had before the call. Only unpaid tickets are
+	// completed, or expired ones when force is set; completion converts the
+	// amount to the base currency at the rate in effect and fails with
+	// ErrNoExchangeRate when there is none. Cancelling a paid ticket takes
+	// back its unrefunded amount as policy allows.
+	CompletePayment(ctx context.Context, id int, force bool) (string, error)
+	CancelPayment(ctx context.Context, id int, policy RefundPolicy) (string, error)
+	// CancelUnpaidPayment cancels a ticket only while it is unpaid or
+	// expired and returns the status it had before the call. A paid ticket
+	// is left alone, so no money is ever taken back.
+	CancelUnpaidPayment(ctx context.Context, id int) (string, error)
+	// RefundPayment returns amount of a paid ticket to the payer. It fails
+	// with ErrPaymentNotRefundable, ErrRefundTooLarge or
+	// ErrInsufficientBalance; with RefundPolicyClamp the refund may be
+	// smaller than asked.
+	RefundPayment(ctx context.Context, paymentID int, amount money.Amount, reason string, policy RefundPolicy, now time.Time) (models.Refund, error)
+	// ListRefunds returns a ticket's refunds, oldest first. It returns
+	// ErrNotFound if the ticket does not exist.
+	ListRefunds(ctx context.Context, paymentID int) ([]models.Refund, error)
+	// ExpirePayments moves the unpaid tickets created before cutoff to
+	// expired and returns their ids.
+	ExpirePayments(ctx context.Context, cutoff time.Time) ([]int, error)
+
+	// RecordPaymentEvent stores a webhook event. If the provider already
+	// delivered an event with the same id, the stored event is returned
+	// instead and created is false.
+	RecordPaymentEvent(ctx context.Context, event models.PaymentEvent) (stored models.PaymentEvent, created bool, err error)
+	// FinishPaymentEvent records the result of processing an event.
+	FinishPaymentEvent(ctx context.Context, id int64, result string, now time.Time) error
+}
+
+type ExchangeRateStore interface {
+	// ExchangeRate returns the rate of currency in effect at the given time,
+	// or ErrNoExchangeRate. The base currency always has a rate of 1.
+	ExchangeRate(ctx context.Context, currency string, at time.Time) (models.ExchangeRate, error)
+	// ListExchangeRates returns the rates of currency, or of every currency
+	// when it is empty, newest first.
+	ListExchangeRates(ctx context.Context, currency string) ([]models.ExchangeRate, error)
+	// SetExchangeRate stores a rate, replacing the one of the same currency
+	// and effective time if there is one.
+	SetExchangeRate(ctx context.Context, rate models.ExchangeRate) (models.ExchangeRate, error)
+}
+
+type QuizStore interface {
+	SaveHashMapping(ctx context.Context, hash, question, answer string) error
+	GetQuestionAnswer(ctx context.Context, hash string) (string, string, error)
+	// SaveUserAnswer records a user's answer. It returns ErrUserBanned if
+	// the user is registered and banned.
+	SaveUserAnswer(ctx context.Context, telegramID int, question, answer, hash string) error
+}
+
+// AuditFilter selects audit entries. Zero fields match everything; From and
+// To bound created_at.
+type AuditFilter struct {
+	Actor      string
+	Route      string
+	EntityType string
+	EntityID   string
+	From       time.Time
+	To         time.Time
+}
+
+type AuditStore interface {
+	ListAudit(ctx context.Context, filter AuditFilter, page Page) ([]models.AuditEntry, PageInfo, error)
+}
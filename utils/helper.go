@@ -4,6 +4,9 @@ import (
 	"database/sql"
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
 )
 
 func NullStringOrValue(ns sql.NullString) interface{} {
@@ -13,6 +16,13 @@ func NullStringOrValue(ns sql.NullString) interface{} {
 	return nil
 }
 
+func NullOrValue[T any](n sql.Null[T]) interface{} {
+	if n.Valid {
+		return n.V
+	}
+	return nil
+}
+
 func NullTimeOrValue(nt sql.NullTime) interface{} {
 	if nt.Valid {
 		return nt.Time
@@ -20,6 +30,13 @@ func NullTimeOrValue(nt sql.NullTime) interface{} {
 	return nil
 }
 
+func NullFloat64OrValue(nf sql.NullFloat64) interface{} {
+	if nf.Valid {
+		return nf.Float64
+	}
+	return nil
+}
+
 func NullInt32OrValue(nt sql.NullInt32) interface{} {
 	if nt.Valid {
 		return nt.Int32
@@ -46,3 +63,18 @@ func GenerateHash(question, answer string) string {
 	hash := sha256.Sum256([]byte(data))
 	return hex.EncodeToString(hash[:])[:32] // Take the first 32 characters of the hex string
 }
+
+// DurationFromEnv parses the environment variable key as a time.Duration,
+// returning fallback when it is not set.
+func DurationFromEnv(key string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+	return d, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hvmnd/api/currency"
+	"hvmnd/api/money"
+	"hvmnd/api/providers"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// succeeded is a fake provider event that completes ticketID.
+func succeeded(eventID string, ticketID int, amount, currencyCode string) string {
+	return fmt.Sprintf(`{"id": %q, "type": "payment.succeeded", "payment_id": %d, "amount": %s, "currency": %q}`,
+		eventID, ticketID, amount, currencyCode)
+}
+
+// webhookResult returns the result recorded for a delivered event.
+func webhookResult(t *testing.T, resp response) string {
+	t.Helper()
+	var data struct {
+		Result string `json:"result"`
+	}
+	resp.decode(t, &data)
+	return data.Result
+}
+
+func TestWebhookRejectsABadSignature(t *testing.T) {
+	s := newTestServer(t)
+	userID := s.createUser(42)
+	ticketID := s.openTicket(userID, "100")
+
+	body := succeeded("evt_1", ticketID, "100", currency.Base())
+	resp := s.do("POST", "/api/v1/payments/webhook/fake", body, http.Header{
+		providers.FakeSignatureHeader: {providers.NewFake("other-secret").Sign([]byte(body))},
+	})
+	expectStatus(t, resp, http.StatusUnauthorized)
+	expectStatus(t, s.do("POST", "/api/v1/payments/webhook/fake", body, nil), http.StatusUnauthorized)
+
+	if balance, _ := s.ledger(userID); balance != 0 {
+		t.Fatalf("want nothing credited, got %v", balance)
+	}
+}
+
+func TestWebhookReplaysCreditOnce(t *testing.T) {
+	s := newTestServer(t)
+	userID := s.createUser(42)
+	ticketID := s.openTicket(userID, "100")
+
+	body := succeeded("evt_1", ticketID, "100", currency.Base())
+	resp := s.webhook(body)
+	expectStatus(t, resp, http.StatusOK)
+	if result := webhookResult(t, resp); result != "completed" {
+		t.Fatalf("want the ticket completed, got %q", result)
+	}
+
+	resp = s.webhook(body)
+	expectStatus(t, resp, http.StatusOK)
+	if resp.Message != "Event already processed" || webhookResult(t, resp) != "completed" {
+		t.Fatalf("want the replay acknowledged with the first result, got %q, %s", resp.Message, resp.Data)
+	}
+
+	// The provider may also report the same capture under a new event id
+	resp = s.webhook(succeeded("evt_2", ticketID, "100", currency.Base()))
+	expectStatus(t, resp, http.StatusOK)
+	if result := webhookResult(t, resp); result != "already_paid" {
+		t.Fatalf("want the ticket reported as already paid, got %q", result)
+	}
+
+	balance, reconciled := s.ledger(userID)
+	if balance != money.MustParse("100") || !reconciled {
+		t.Fatalf("want a reconciled balance of 100, got %v (reconciled %v)", balance, reconciled)
+	}
+}
+
+func TestWebhookRejectsAmountAndCurrencyMismatches(t *testing.T) {
+	s := newTestServer(t)
+	userID := s.createUser(42)
+	ticketID := s.openTicket(userID, "100")
+
+	resp := s.webhook(succeeded("evt_1", ticketID, "99.99", currency.Base()))
+	expectStatus(t, resp, http.StatusUnprocessableEntity)
+	if result := webhookResult(t, resp); result != "amount_mismatch" {
+		t.Fatalf("want an amount mismatch, got %q", result)
+	}
+
+	other := "EUR"
+	if currency.Base() == other {
+		other = "USD"
+	}
+	resp = s.webhook(succeeded("evt_2", ticketID, "100", other))
+	expectStatus(t, resp, http.StatusUnprocessableEntity)
+	if result := webhookResult(t, resp); result != "currency_mismatch" {
+		t.Fatalf("want a currency mismatch, got %q", result)
+	}
+
+	if balance, _ := s.ledger(userID); balance != 0 {
+		t.Fatalf("want nothing credited, got %v", balance)
+	}
+}
+
+func TestWebhookCompletesAnExpiredTicket(t *testing.T) {
+	s := newTestServer(t)
+	userID := s.createUser(42)
+	ticketID := s.openTicket(userID, "100")
+
+	if _, err := s.store.ExpirePayments(context.Background(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := s.webhook(succeeded("evt_1", ticketID, "100", currency.Base()))
+	expectStatus(t, resp, http.StatusOK)
+	if result := webhookResult(t, resp); result != "completed_expired" {
+		t.Fatalf("want the expired ticket completed, got %q", result)
+	}
+
+	balance, reconciled := s.ledger(userID)
+	if balance != money.MustParse("100") || !reconciled {
+		t.Fatalf("want a reconciled balance of 100, got %v (reconciled %v)", balance, reconciled)
+	}
+}
+
+func TestWebhookCancelLeavesAPaidTicketAlone(t *testing.T) {
+	s := newTestServer(t)
+	userID := s.createUser(42)
+	ticketID := s.openTicket(userID, "100")
+	expectStatus(t, s.webhook(succeeded("evt_1", ticketID, "100", currency.Base())), http.StatusOK)
+
+	for _, eventType := range []string{"payment.failed", "payment.cancelled"} {
+		resp := s.webhook(fmt.Sprintf(`{"id": "evt_%s", "type": %q, "payment_id": %d}`, eventType, eventType, ticketID))
+		expectStatus(t, resp, http.StatusConflict)
+		if result := webhookResult(t, resp); result != "status_conflict" {
+			t.Fatalf("%s: want a status conflict, got %q", eventType, result)
+		}
+	}
+
+	balance, reconciled := s.ledger(userID)
+	if balance != money.MustParse("100") || !reconciled {
+		t.Fatalf("want the credit kept at 100, got %v (reconciled %v)", balance, reconciled)
+	}
+
+	// An unpaid ticket is cancelled, once
+	ticketID = s.openTicket(userID, "50")
+	cancel := fmt.Sprintf(`{"id": "evt_cancel", "type": "payment.failed", "payment_id": %d}`, ticketID)
+	if result := webhookResult(t, s.webhook(cancel)); result != "cancelled" {
+		t.Fatalf("want the unpaid ticket cancelled, got %q", result)
+	}
+	again := fmt.Sprintf(`{"id": "evt_cancel_2", "type": "payment.cancelled", "payment_id": %d}`, ticketID)
+	if result := webhookResult(t, s.webhook(again)); result != "already_cancelled" {
+		t.Fatalf("want the ticket reported as already cancelled, got %q", result)
+	}
+}